@@ -0,0 +1,9 @@
+// Package api embeds the OpenAPI document of the json endpoints, it is served at /api/openapi.json
+package api
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3 document, keep it in sync with the json routes in cmd/web/routes.go
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
@@ -13,12 +18,13 @@ import (
 	"github.com/tsawler/bookings-app/internal/driver"
 	"github.com/tsawler/bookings-app/internal/handlers"
 	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/holds"
+	"github.com/tsawler/bookings-app/internal/icalsync"
 	"github.com/tsawler/bookings-app/internal/models"
 	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/storage"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
 var infoLog *log.Logger
@@ -26,16 +32,41 @@ var errorLog *log.Logger
 
 // main is the main function
 func main() {
+	args, err := config.Load(&app, os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatal("Configuration error: ", err)
+	}
+
 	db, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
-	defer close(app.MailChan) 
-	fmt.Println("start mail listener")
-	listenForMail()
+	if db != nil {
+		defer func() {
+			infoLog.Println("Closing database")
+			db.SQL.Close()
+		}()
+	}
 
-	
+	// "migrate up", "migrate down N" and "migrate status" manage the schema instead of starting the server
+	if len(args) > 0 && args[0] == "migrate" {
+		err = runMigrate(db, args[1:])
+		if err != nil {
+			errorLog.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Println("start mail listener")
+	mailCtx, stopMail := context.WithCancel(context.Background())
+	defer stopMail()
+	mailDone, err := listenForMail(mailCtx, handlers.Repo.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	// go package to send email
 	// from := "me@test.com"
@@ -43,18 +74,62 @@ func main() {
 	// err = smtp.SendMail("localhost:1025", auth, from,[]string{"user1@user.com", "user2@usr.com"}, []byte("hello world"))
 
 
-	fmt.Println(fmt.Sprintf("Staring application on port %s", portNumber))
+	// imports the calendars of other booking sites
+	syncCtx, stopSync := context.WithCancel(context.Background())
+	defer stopSync()
+	syncDone := icalsync.New(handlers.Repo.DB, app.ICalSyncInterval, infoLog, errorLog).Run(syncCtx)
+
+	// frees the rooms held for guests who left the reservation form
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	sweepDone := holds.New(handlers.Repo.DB, time.Minute, infoLog, errorLog).Run(sweepCtx)
+
+	fmt.Println(fmt.Sprintf("Staring application on port %s", app.Port))
 
 	srv := &http.Server{
-		Addr:    portNumber,
+		Addr:    app.Port,
 		Handler: routes(&app),
 	}
 
-	err = srv.ListenAndServe()
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// wait for ctrl-c or the SIGTERM sent by the process manager
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	<-quit
+
+	// a sync cut short is finished by the next start
+	stopSync()
+	<-syncDone
+	stopSweep()
+	<-sweepDone
+	shutdown(srv, stopMail, mailDone)
+}
+
+// shutdown stops accepting requests, waits for the ones in flight and lets the outbox send what is due
+func shutdown(srv *http.Server, stopMail context.CancelFunc, mailDone <-chan struct{}) {
+	infoLog.Println("Shutting down...")
+	ctx, cancel := context.WithTimeout(context.Background(), app.ShutdownTimeout)
+	defer cancel()
+
+	err := srv.Shutdown(ctx)
 	if err != nil {
-		log.Fatal(err)
+		errorLog.Println("requests did not finish in time:", err)
+	}
+
+	// the outbox makes a last pass, anything it cannot send stays queued for the next start
+	stopMail()
+	select {
+	case <-mailDone:
+		infoLog.Println("Mail outbox stopped")
+	case <-ctx.Done():
+		errorLog.Println("timed out sending pending mail, it stays in the outbox")
 	}
-	
 }
 
 func run() (*driver.DB, error) {
@@ -64,14 +139,9 @@ func run() (*driver.DB, error) {
 	gob.Register(models.User{})
 	gob.Register(models.Room{})
 	gob.Register(models.Restriction{})
+	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
 
-	mailChan := make(chan models.MailData) // init channel for mail data
-	app.MailChan = mailChan // need to remember close chan
-	
-	// change this to true when in production
-	app.InProduction = false
-
 	//format the info log
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -89,16 +159,30 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
-	//connet to db
+	// photos are served from the upload directory by routes
+	app.Storage = storage.NewLocal(app.UploadDir, "/uploads")
 
-	log.Println("Connecting to database...")
-	db, err := driver.ConnectDB("host=localhost port=5432 dbname=bookings user=liulian password=")
-	if err != nil {
-		log.Fatal("Cannot connect to database! Dying...")
+	//connet to db
+	var db *driver.DB
+	var err error
+	switch app.DBDriver {
+	case driver.Postgres:
+		log.Println("Connecting to database...")
+		db, err = driver.ConnectDB(app.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to database: %w", err)
+		}
+		log.Println("Connected to database!")
+	case driver.SQLite:
+		log.Println("Opening sqlite database", app.DBFile)
+		db, err = driver.ConnectSQLite(app.DBFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot open sqlite database: %w", err)
+		}
+	default:
+		log.Println("Using the in-memory database, data is lost on restart")
 	}
 
-	log.Println("Connected to database!")
-
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache")
@@ -106,9 +190,20 @@ func run() (*driver.DB, error) {
 	}
 
 	app.TemplateCache = tc
-	app.UseCache = false
 
-	repo := handlers.NewRepo(&app, db)
+	htmlEmails, textEmails, err := render.CreateEmailTemplateCache()
+	if err != nil {
+		return nil, fmt.Errorf("cannot create email template cache: %w", err)
+	}
+	app.EmailTemplateCache = htmlEmails
+	app.EmailTextTemplateCache = textEmails
+
+	var repo *handlers.Repository
+	if db != nil {
+		repo = handlers.NewRepo(&app, db)
+	} else {
+		repo = handlers.NewTestRepo(&app)
+	}
 	handlers.NewHandlers(repo)
 	render.NewRenderer(&app)
 	helpers.NewHelpers(&app)
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/tsawler/bookings-app/internal/apikeys"
+	"github.com/tsawler/bookings-app/internal/handlers"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// setupAPIKeys points the handlers at a fresh memory repo and returns a good, a revoked and a read only key
+func setupAPIKeys(t *testing.T) (good, revoked, readOnly string) {
+	t.Helper()
+
+	session = scs.New()
+	app.Session = session
+	handlers.NewHandlers(handlers.NewTestRepo(&app))
+	helpers.NewHelpers(&app)
+
+	newKey := func(scopes ...string) (string, int) {
+		key, prefix, hash, err := apikeys.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := handlers.Repo.DB.InsertAPIKey(models.APIKey{UserID: 1, Name: "test", Prefix: prefix, Hash: hash, Scopes: scopes})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key, id
+	}
+
+	good, _ = newKey(models.ScopeReadBlocks, models.ScopeWriteBlocks)
+	revoked, id := newKey(models.ScopeReadBlocks, models.ScopeWriteBlocks)
+	if err := handlers.Repo.DB.RevokeAPIKey(id); err != nil {
+		t.Fatal(err)
+	}
+	readOnly, _ = newKey(models.ScopeReadBlocks)
+	return good, revoked, readOnly
+}
+
+var okHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+})
+
+func TestRequireAPIKey(t *testing.T) {
+	good, revoked, readOnly := setupAPIKeys(t)
+	prefix, _ := apikeys.Prefix(good)
+
+	var tests = []struct {
+		name          string
+		authorization string
+		status        int
+	}{
+		{"good key", "Bearer " + good, http.StatusOK},
+		{"no key", "", http.StatusUnauthorized},
+		{"basic auth", "Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"not a key", "Bearer nonsense", http.StatusUnauthorized},
+		{"unknown prefix", "Bearer bk_00000000_secret", http.StatusUnauthorized},
+		{"wrong secret", "Bearer bk_" + prefix + "_secret", http.StatusUnauthorized},
+		{"revoked key", "Bearer " + revoked, http.StatusUnauthorized},
+		{"missing scope", "Bearer " + readOnly, http.StatusForbidden},
+	}
+
+	handler := RequireAPIKey(models.ScopeWriteBlocks)(okHandler)
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/api/v1/blocks", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: got %d, wanted %d: %s", e.name, rr.Code, e.status, rr.Body.String())
+		}
+		if rr.Code == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: 401 without WWW-Authenticate", e.name)
+		}
+	}
+
+	k, _ := handlers.Repo.DB.GetAPIKeyByPrefix(prefix)
+	if k.LastUsedAt.IsZero() {
+		t.Error("last use of the key was not recorded")
+	}
+}
+
+func TestAPIKeyAuth_Admin(t *testing.T) {
+	good, _, readOnly := setupAPIKeys(t)
+
+	// the same chain as the /admin routes
+	admin := func(h http.Handler) http.Handler {
+		return SessionLoad(APIKeyAuth(Auth(h)))
+	}
+
+	var tests = []struct {
+		name          string
+		handler       http.Handler
+		authorization string
+		status        int
+	}{
+		{"key with scope", Scope(models.ScopeReadBlocks)(okHandler), "Bearer " + good, http.StatusOK},
+		{"key without scope", Scope(models.ScopeReadReservations)(okHandler), "Bearer " + good, http.StatusForbidden},
+		{"read only key", Scope(models.ScopeWriteBlocks)(okHandler), "Bearer " + readOnly, http.StatusForbidden},
+		{"bad key", Scope(models.ScopeReadBlocks)(okHandler), "Bearer nonsense", http.StatusUnauthorized},
+		{"no key, no login", Scope(models.ScopeReadBlocks)(okHandler), "", http.StatusSeeOther},
+		{"key on api key pages", NoAPIKey(okHandler), "Bearer " + good, http.StatusForbidden},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("GET", "/admin/blocks", nil)
+		if e.authorization != "" {
+			req.Header.Set("Authorization", e.authorization)
+		}
+		rr := httptest.NewRecorder()
+		admin(e.handler).ServeHTTP(rr, req)
+
+		if rr.Code != e.status {
+			t.Errorf("%s: got %d, wanted %d", e.name, rr.Code, e.status)
+		}
+	}
+}
+
+func TestNoSurf_APIKey(t *testing.T) {
+	handler := NoSurf(okHandler)
+
+	req := httptest.NewRequest("POST", "/admin/blocks", strings.NewReader("action=save"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("post without csrf token got %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+
+	// the key itself is checked by APIKeyAuth
+	req = httptest.NewRequest("POST", "/admin/blocks", strings.NewReader("action=save"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer bk_abcd1234_secret")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("post with an api key got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// outside the admin nothing checks the key, so it doesn't stand in for the token
+	for _, path := range []string{"/make-reservation", "/my-reservation/token/cancel", "/administrator"} {
+		req = httptest.NewRequest("POST", path, strings.NewReader("first_name=Ada"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer junk")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("post to %s with a made up key got %d, wanted %d", path, rr.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRoutes_AdminBadKey(t *testing.T) {
+	setupAPIKeys(t)
+
+	// past the csrf check, the made up key is turned away before the handler
+	req := httptest.NewRequest("POST", "/admin/blocks", strings.NewReader("action=save"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Authorization", "Bearer junk")
+	rr := httptest.NewRecorder()
+	routes(&app).ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("post with a made up key got %d, wanted %d", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tsawler/bookings-app/internal/driver"
+	"github.com/tsawler/bookings-app/internal/migrate"
+	"github.com/tsawler/bookings-app/migrations"
+)
+
+const migrateUsage = "usage: migrate up | migrate down N | migrate status"
+
+// runMigrate handles the "migrate up", "migrate down N" and "migrate status" subcommands
+func runMigrate(db *driver.DB, args []string) error {
+	if db == nil {
+		return errors.New("migrate needs a postgres or sqlite database")
+	}
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, err := migrate.New(db.SQL, migrations.FS, db.Driver)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		ran, err := m.Up()
+		for _, mg := range ran {
+			infoLog.Printf("applied %s_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+		infoLog.Printf("%d migration(s) applied", len(ran))
+
+	case "down":
+		if len(args) != 2 {
+			return errors.New(migrateUsage)
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("migrate down: %q is not a positive number", args[1])
+		}
+		ran, err := m.Down(n)
+		for _, mg := range ran {
+			infoLog.Printf("rolled back %s_%s", mg.Version, mg.Name)
+		}
+		if err != nil {
+			return err
+		}
+
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+		for _, s := range status {
+			applied := "pending"
+			if s.Applied {
+				applied = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %-60s %s\n", s.Version, s.Name, applied)
+		}
+
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
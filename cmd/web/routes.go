@@ -5,7 +5,9 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/tsawler/bookings-app/internal/config"
 	"github.com/tsawler/bookings-app/internal/handlers"
+	"github.com/tsawler/bookings-app/internal/models"
 	"net/http"
+	"os"
 )
 
 func routes(app *config.AppConfig) http.Handler {
@@ -17,14 +19,18 @@ func routes(app *config.AppConfig) http.Handler {
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	// the pages the rooms had before they were in the database
+	mux.Get("/generals-quarters", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently).ServeHTTP)
+	mux.Get("/majors-suite", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently).ServeHTTP)
 
 	mux.Get("/search-availability", handlers.Repo.Availability)
 	mux.Post("/search-availability", handlers.Repo.PostAvailability)
 	mux.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom) // the repo.ChooseRoom implementation is in the handlers.go
-	mux.Get("/book-room", handlers.Repo.BookRoom)
+	// these hold the room, so they're posts a crawler won't follow
+	mux.Post("/choose-room/{id}", handlers.Repo.ChooseRoom) // the repo.ChooseRoom implementation is in the handlers.go
+	mux.Post("/book-room", handlers.Repo.BookRoom)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 
@@ -35,25 +41,105 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
 	mux.Get("/user/logout", handlers.Repo.Logout)
 
+	// the signed token from the confirmation mail stands in for a login
+	mux.Get("/my-reservation/{token}", handlers.Repo.GuestReservation)
+	mux.Post("/my-reservation/{token}/dates", handlers.Repo.GuestChangeDates)
+	mux.Post("/my-reservation/{token}/cancel", handlers.Repo.GuestCancel)
+
+	// the secret token is the password of the feed, calendar apps can't log in
+	mux.Get("/calendar/{token}.ics", handlers.Repo.RoomCalendar)
+
+	// the json api, no csrf token needed (see NoSurf). the reservations hold the guests' details, only api keys see them
+	mux.Get("/api/openapi.json", handlers.Repo.OpenAPI)
+	mux.Route("/api/v1", func(mux chi.Router) {
+		mux.NotFound(handlers.Repo.APINotFound)
+		mux.MethodNotAllowed(handlers.Repo.APIMethodNotAllowed)
+		mux.Get("/rooms", handlers.Repo.APIRooms)
+		mux.Get("/availability", handlers.Repo.APIAvailability)
+		mux.With(RequireAPIKey(models.ScopeWriteReservations)).Post("/reservations", handlers.Repo.APICreateReservation)
+		mux.With(RequireAPIKey(models.ScopeReadReservations)).Get("/reservations/{id}", handlers.Repo.APIReservation)
+		mux.With(RequireAPIKey(models.ScopeWriteReservations)).Delete("/reservations/{id}", handlers.Repo.APICancelReservation)
+		mux.With(RequireAPIKey(models.ScopeReadBlocks)).Get("/blocks", handlers.Repo.APIBlocks)
+		mux.With(RequireAPIKey(models.ScopeWriteBlocks)).Post("/blocks", handlers.Repo.APICreateBlock)
+		mux.With(RequireAPIKey(models.ScopeWriteBlocks)).Delete("/blocks/{id}", handlers.Repo.APIDeleteBlock)
+	})
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
+	uploads := http.FileServer(noDirs{http.Dir(app.UploadDir)})
+	mux.Handle("/uploads/*", http.StripPrefix("/uploads", uploads))
 
-	// only admin can access below page
+	// only admin can access below page, with a login or with an api key that has the scope
 	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(APIKeyAuth)
 		mux.Use(Auth)
-		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
-		mux.Get("/reservation-new", handlers.Repo.AdminNewReservation)
-		mux.Get("/reservation-all", handlers.Repo.AdminAllReservation)
-		mux.Get("/reservation-calendar", handlers.Repo.AdminReservationCalender)
-		mux.Get("/process-reservation/{src}/{id}", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/{id}", handlers.Repo.AdminDeleteReservation)
+		mux.With(Scope(models.ScopeReadReservations)).Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.With(Scope(models.ScopeReadReservations)).Get("/reservation-new", handlers.Repo.AdminNewReservation)
+		mux.With(Scope(models.ScopeReadReservations)).Get("/reservation-all", handlers.Repo.AdminAllReservation)
+		mux.With(Scope(models.ScopeReadBlocks)).Get("/reservation-calendar", handlers.Repo.AdminReservationCalender)
+		mux.With(Scope(models.ScopeWriteBlocks)).Post("/reservation-calendar", handlers.Repo.AdminPostReservationCalender)
+		mux.With(Scope(models.ScopeReadBlocks)).Get("/blocks", handlers.Repo.AdminBlocks)
+		mux.With(Scope(models.ScopeWriteBlocks)).Post("/blocks", handlers.Repo.AdminPostBlocks)
 		//display the single reservation
-		mux.Get("/reservation/{src}/{id}", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservation/{src}/{id}", handlers.Repo.AdminPostShowReservation)
-		
+		mux.With(Scope(models.ScopeReadReservations)).Get("/reservation/{src}/{id}", handlers.Repo.AdminShowReservation)
+		mux.With(Scope(models.ScopeWriteReservations)).Post("/reservation/{src}/{id}", handlers.Repo.AdminPostShowReservation)
+		mux.With(Scope(models.ScopeWriteReservations)).Post("/reservation/{src}/{id}/status", handlers.Repo.AdminReservationStatus)
 
+		// keys are managed by people only
+		mux.With(NoAPIKey).Get("/api-keys", handlers.Repo.AdminAPIKeys)
+		mux.With(NoAPIKey).Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+		mux.With(NoAPIKey).Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+		mux.With(NoAPIKey).Get("/calendar-feeds", handlers.Repo.AdminCalendarFeeds)
+		mux.With(NoAPIKey).Post("/calendar-feeds/{id}", handlers.Repo.AdminPostCalendarFeed)
+		// so are the rooms, their rates and stay rules, no scope covers them
+		mux.With(NoAPIKey).Get("/rooms", handlers.Repo.AdminRooms)
+		mux.With(NoAPIKey).Get("/rooms/new", handlers.Repo.AdminRoom)
+		mux.With(NoAPIKey).Post("/rooms/new", handlers.Repo.AdminPostRoom)
+		mux.With(NoAPIKey).Get("/rooms/{id}", handlers.Repo.AdminRoom)
+		mux.With(NoAPIKey).Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.With(NoAPIKey).Post("/rooms/{id}/archive", handlers.Repo.AdminArchiveRoom)
+		mux.With(NoAPIKey).Post("/rooms/{id}/restore", handlers.Repo.AdminRestoreRoom)
+		mux.With(NoAPIKey).Post("/rooms/{id}/move", handlers.Repo.AdminMoveRoom)
+		mux.With(NoAPIKey).Get("/rooms/{id}/photos", handlers.Repo.AdminRoomPhotos)
+		mux.With(NoAPIKey).Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhotos)
+		mux.With(NoAPIKey).Post("/rooms/{id}/photos/{photo}/delete", handlers.Repo.AdminDeleteRoomPhoto)
+		mux.With(NoAPIKey).Post("/rooms/{id}/photos/{photo}/move", handlers.Repo.AdminMoveRoomPhoto)
+		mux.With(NoAPIKey).Get("/rates", handlers.Repo.AdminRates)
+		mux.With(NoAPIKey).Post("/rates/seasons", handlers.Repo.AdminPostSeasonalRate)
+		mux.With(NoAPIKey).Post("/rates/seasons/{id}/delete", handlers.Repo.AdminDeleteSeasonalRate)
+		mux.With(NoAPIKey).Post("/rates/{id}", handlers.Repo.AdminPostRoomRates)
+		mux.With(NoAPIKey).Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.With(NoAPIKey).Post("/stay-rules/closed-dates", handlers.Repo.AdminPostClosedDate)
+		mux.With(NoAPIKey).Post("/stay-rules/closed-dates/{id}/delete", handlers.Repo.AdminDeleteClosedDate)
+		mux.With(NoAPIKey).Post("/stay-rules/{id}", handlers.Repo.AdminPostRoomStayRules)
+		mux.With(Scope(models.ScopeReadBlocks)).Get("/ical-sources", handlers.Repo.AdminICalSources)
+		mux.With(Scope(models.ScopeWriteBlocks)).Post("/ical-sources", handlers.Repo.AdminPostICalSource)
+		mux.With(Scope(models.ScopeWriteBlocks)).Post("/ical-sources/{id}/sync", handlers.Repo.AdminSyncICalSource)
+		mux.With(Scope(models.ScopeWriteBlocks)).Post("/ical-sources/{id}/delete", handlers.Repo.AdminDeleteICalSource)
 	})
 
-	
 	return mux
 }
+
+// noDirs serves files but not directory listings, the names of the uploaded photos are random so
+// their urls can't be guessed, a listing would give them away
+type noDirs struct {
+	fs http.FileSystem
+}
+
+func (n noDirs) Open(name string) (http.File, error) {
+	f, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/api"
+)
+
+// isJSONRoute tells the json routes from the html pages
+func isJSONRoute(route string) bool {
+	return strings.HasPrefix(route, "/api/") || strings.HasSuffix(route, "-json")
+}
+
+func TestRoutes_OpenAPI(t *testing.T) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatal("can't parse openapi.json:", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	mux := routes(&app).(*chi.Mux)
+	registered := make(map[string]bool)
+	err := chi.Walk(mux, func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		route = strings.TrimSuffix(route, "/")
+		if isJSONRoute(route) {
+			registered[method+" "+route] = true
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for r := range registered {
+		if !documented[r] {
+			t.Errorf("%s is not in api/openapi.json", r)
+		}
+	}
+	for d := range documented {
+		if !registered[d] {
+			t.Errorf("api/openapi.json documents %s, but there is no such route", d)
+		}
+	}
+}
+
+// serve sends a request through the whole router, middleware included
+func serve(method, url, body, authorization string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	rr := httptest.NewRecorder()
+	routes(&app).ServeHTTP(rr, req)
+	return rr
+}
+
+func TestRoutes_APIReservations(t *testing.T) {
+	// none of the keys has a reservation scope
+	good, _, _ := setupAPIKeys(t)
+
+	var tests = []struct {
+		method        string
+		url           string
+		authorization string
+		status        int
+	}{
+		{"GET", "/api/v1/reservations/1", "", http.StatusUnauthorized},
+		{"DELETE", "/api/v1/reservations/1", "", http.StatusUnauthorized},
+		{"POST", "/api/v1/reservations", "", http.StatusUnauthorized},
+		{"GET", "/api/v1/reservations/1", "Bearer nonsense", http.StatusUnauthorized},
+		{"GET", "/api/v1/reservations/1", "Bearer " + good, http.StatusForbidden},
+		{"DELETE", "/api/v1/reservations/1", "Bearer " + good, http.StatusForbidden},
+		{"POST", "/api/v1/reservations", "Bearer " + good, http.StatusForbidden},
+		// rooms and availability stay public
+		{"GET", "/api/v1/rooms", "", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		rr := serve(e.method, e.url, "{}", e.authorization)
+		if rr.Code != e.status {
+			t.Errorf("%s %s with %q: got %d, wanted %d: %s", e.method, e.url, e.authorization, rr.Code, e.status, rr.Body.String())
+		}
+		if rr.Code != http.StatusOK && !strings.HasPrefix(rr.Header().Get("Content-Type"), "application/json") {
+			t.Errorf("%s %s: expected a json error, got %q", e.method, e.url, rr.Body.String())
+		}
+	}
+}
+
+func TestRoutes_APINoCSRF(t *testing.T) {
+	good, _, _ := setupAPIKeys(t)
+
+	// json clients have no csrf cookie, their writes go through to the key check
+	rr := serve("POST", "/api/v1/blocks", `{"room_id":1,"start_date":"2074-01-10","end_date":"2074-01-12"}`, "Bearer "+good)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("creating a block got %d, wanted %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+	}
+	rr = serve("DELETE", "/api/v1/blocks/999", "", "")
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("deleting a block without a key got %d, wanted %d: %s", rr.Code, http.StatusUnauthorized, rr.Body.String())
+	}
+
+	// the pages still need the token
+	rr = serve("POST", "/make-reservation", "first_name=Ada", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("posting a form without a csrf token got %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRoutes_HoldsNeedAPost(t *testing.T) {
+	// following a link, as a crawler would, can't hold a room
+	for _, url := range []string{"/choose-room/1", "/book-room?id=1&s=2083-01-10&e=2083-01-12"} {
+		rr := serve("GET", url, "", "")
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("GET %s got %d, wanted %d", url, rr.Code, http.StatusMethodNotAllowed)
+		}
+	}
+
+	// and the forms need the csrf token
+	rr := serve("POST", "/book-room", "id=1&s=2083-01-10&e=2083-01-12", "")
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("booking without a csrf token got %d, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRoutes_Uploads(t *testing.T) {
+	setupAPIKeys(t)
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rooms", "1"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rooms", "1", "secret.jpg"), []byte("photo"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(uploadDir string) { app.UploadDir = uploadDir }(app.UploadDir)
+	app.UploadDir = dir
+
+	rr := serve("GET", "/uploads/rooms/1/secret.jpg", "", "")
+	if rr.Code != http.StatusOK || rr.Body.String() != "photo" {
+		t.Errorf("a photo got %d: %q", rr.Code, rr.Body.String())
+	}
+	// the directories aren't listed
+	for _, url := range []string{"/uploads/", "/uploads/rooms/1/", "/uploads/rooms/1"} {
+		rr = serve("GET", url, "", "")
+		if rr.Code != http.StatusNotFound || strings.Contains(rr.Body.String(), "secret.jpg") {
+			t.Errorf("GET %s got %d: %q", url, rr.Code, rr.Body.String())
+		}
+	}
+}
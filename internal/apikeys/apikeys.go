@@ -0,0 +1,61 @@
+// Package apikeys creates and checks the api keys machine clients send in the Authorization header.
+// A key looks like bk_<prefix>_<secret>, the prefix finds the key in the database and only a hash is stored
+package apikeys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"strings"
+)
+
+const keyStart = "bk_"
+
+// Generate creates a new key, the key is shown to the user once, prefix and hash are stored
+func Generate() (key, prefix, hash string, err error) {
+	p := make([]byte, 4)
+	if _, err = rand.Read(p); err != nil {
+		return "", "", "", err
+	}
+	secret := make([]byte, 24)
+	if _, err = rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = hex.EncodeToString(p)
+	key = keyStart + prefix + "_" + hex.EncodeToString(secret)
+	return key, prefix, Hash(key), nil
+}
+
+// Hash returns the hash stored for key. the keys are random, so a fast hash is enough
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Prefix returns the prefix of key, ok is false if key doesn't look like one of ours
+func Prefix(key string) (prefix string, ok bool) {
+	if !strings.HasPrefix(key, keyStart) {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimPrefix(key, keyStart), "_")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// Check tells if key matches the stored hash
+func Check(key, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(Hash(key)), []byte(hash)) == 1
+}
+
+// FromHeader takes the key out of an "Authorization: Bearer <key>" header value
+func FromHeader(header string) (string, bool) {
+	const scheme = "Bearer "
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme):]), true
+}
@@ -0,0 +1,68 @@
+package apikeys
+
+import "testing"
+
+func TestGenerate(t *testing.T) {
+	key, prefix, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, ok := Prefix(key)
+	if !ok || p != prefix {
+		t.Errorf("prefix of %s is %q, wanted %q", key, p, prefix)
+	}
+	if !Check(key, hash) {
+		t.Error("key does not match its hash")
+	}
+	if Check(key+"x", hash) {
+		t.Error("wrong key matches the hash")
+	}
+
+	other, _, _, _ := Generate()
+	if other == key {
+		t.Error("two keys are the same")
+	}
+}
+
+func TestPrefix(t *testing.T) {
+	var tests = []struct {
+		key    string
+		prefix string
+		ok     bool
+	}{
+		{"bk_abcd1234_secret", "abcd1234", true},
+		{"abcd1234_secret", "", false},
+		{"bk_abcd1234", "", false},
+		{"bk__secret", "", false},
+		{"bk_a_b_c", "", false},
+	}
+
+	for _, e := range tests {
+		prefix, ok := Prefix(e.key)
+		if prefix != e.prefix || ok != e.ok {
+			t.Errorf("%s: got %q %v, wanted %q %v", e.key, prefix, ok, e.prefix, e.ok)
+		}
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	var tests = []struct {
+		header string
+		key    string
+		ok     bool
+	}{
+		{"Bearer bk_abcd_efgh", "bk_abcd_efgh", true},
+		{"bearer bk_abcd_efgh", "bk_abcd_efgh", true},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearer ", "", false},
+		{"", "", false},
+	}
+
+	for _, e := range tests {
+		key, ok := FromHeader(e.header)
+		if key != e.key || ok != e.ok {
+			t.Errorf("%q: got %q %v, wanted %q %v", e.header, key, ok, e.key, e.ok)
+		}
+	}
+}
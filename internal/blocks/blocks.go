@@ -0,0 +1,160 @@
+// Package blocks turns an owner block rule, a date range with an optional recurring pattern,
+// into the room restrictions that block those days
+package blocks
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// the patterns a rule can have
+const (
+	Range    = "range"    // every day from start to end
+	Weekends = "weekends" // saturdays and sundays
+	Weekly   = "weekly"   // the chosen weekdays
+	Yearly   = "yearly"   // the same dates every year
+)
+
+// MaxDays caps the length of a rule, so a typo in the year can't block a decade
+const MaxDays = 3 * 366
+
+const dateLayout = "2006-01-02"
+
+// MonthDay is a date without a year, for the yearly pattern
+type MonthDay struct {
+	Month time.Month
+	Day   int
+}
+
+// ParseMonthDay parses "12-25" or "2021-12-25", the year is ignored
+func ParseMonthDay(s string) (MonthDay, error) {
+	s = strings.TrimSpace(s)
+	t, err := time.Parse("01-02", s)
+	if err != nil {
+		t, err = time.Parse(dateLayout, s)
+	}
+	if err != nil {
+		return MonthDay{}, fmt.Errorf("invalid date %q, use MM-DD", s)
+	}
+	return MonthDay{Month: t.Month(), Day: t.Day()}, nil
+}
+
+// Rule describes the days to block
+type Rule struct {
+	Start    time.Time
+	End      time.Time // the last day blocked
+	Pattern  string
+	Weekdays []time.Weekday // weekly
+	Dates    []MonthDay     // yearly
+}
+
+// Validate checks the rule makes sense
+func (r Rule) Validate() error {
+	if r.End.Before(r.Start) {
+		return errors.New("the end date must not be before the start date")
+	}
+	if r.End.Sub(r.Start) > MaxDays*24*time.Hour {
+		return fmt.Errorf("a block can't be longer than %d days", MaxDays)
+	}
+	switch r.Pattern {
+	case Range, Weekends:
+	case Weekly:
+		if len(r.Weekdays) == 0 {
+			return errors.New("choose at least one weekday")
+		}
+	case Yearly:
+		if len(r.Dates) == 0 {
+			return errors.New("enter at least one date")
+		}
+	default:
+		return fmt.Errorf("unknown pattern %q", r.Pattern)
+	}
+	return nil
+}
+
+// Days returns the days the rule blocks, in order
+func (r Rule) Days() []time.Time {
+	var days []time.Time
+	for d := r.Start; !d.After(r.End); d = d.AddDate(0, 0, 1) {
+		if r.matches(d) {
+			days = append(days, d)
+		}
+	}
+	return days
+}
+
+func (r Rule) matches(d time.Time) bool {
+	switch r.Pattern {
+	case Range:
+		return true
+	case Weekends:
+		return d.Weekday() == time.Saturday || d.Weekday() == time.Sunday
+	case Weekly:
+		for _, w := range r.Weekdays {
+			if d.Weekday() == w {
+				return true
+			}
+		}
+	case Yearly:
+		for _, md := range r.Dates {
+			if d.Month() == md.Month && d.Day() == md.Day {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Plan works out the owner blocks for a room: days already restricted by existing are left out,
+// consecutive days are joined into one block. conflicts are the existing restrictions on any of the days
+func Plan(roomID int, days []time.Time, existing []models.RoomRestriction) (blocks, conflicts []models.RoomRestriction) {
+	taken := make(map[string]bool)
+	for _, day := range days {
+		for _, rr := range existing {
+			// the end date is the first free day
+			if !day.Before(rr.StartDate) && day.Before(rr.EndDate) {
+				taken[day.Format(dateLayout)] = true
+				if !containsRestriction(conflicts, rr.ID) {
+					conflicts = append(conflicts, rr)
+				}
+			}
+		}
+	}
+
+	var free []time.Time
+	for _, day := range days {
+		if !taken[day.Format(dateLayout)] {
+			free = append(free, day)
+		}
+	}
+	sort.Slice(free, func(i, j int) bool { return free[i].Before(free[j]) })
+
+	for _, day := range free {
+		n := len(blocks)
+		if n > 0 && blocks[n-1].EndDate.Equal(day) {
+			blocks[n-1].EndDate = day.AddDate(0, 0, 1)
+			continue
+		}
+		blocks = append(blocks, models.RoomRestriction{
+			StartDate:     day,
+			EndDate:       day.AddDate(0, 0, 1),
+			RoomID:        roomID,
+			RestrictionID: models.RestrictionOwnerBlock,
+		})
+	}
+	return blocks, conflicts
+}
+
+func containsRestriction(restrictions []models.RoomRestriction, id int) bool {
+	for _, rr := range restrictions {
+		if rr.ID == id {
+			return true
+		}
+	}
+	return false
+}
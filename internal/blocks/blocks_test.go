@@ -0,0 +1,102 @@
+package blocks
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse(dateLayout, s)
+	return t
+}
+
+func TestRule_Days(t *testing.T) {
+	var tests = []struct {
+		name string
+		rule Rule
+		want []string
+	}{
+		{"range", Rule{Start: date("2060-03-01"), End: date("2060-03-03"), Pattern: Range}, []string{"2060-03-01", "2060-03-02", "2060-03-03"}},
+		// 2060-03-06 is a saturday
+		{"weekends", Rule{Start: date("2060-03-01"), End: date("2060-03-10"), Pattern: Weekends}, []string{"2060-03-06", "2060-03-07"}},
+		{"weekly", Rule{Start: date("2060-03-01"), End: date("2060-03-15"), Pattern: Weekly, Weekdays: []time.Weekday{time.Monday}}, []string{"2060-03-01", "2060-03-08", "2060-03-15"}},
+		{"yearly", Rule{Start: date("2060-01-01"), End: date("2062-12-31"), Pattern: Yearly, Dates: []MonthDay{{time.December, 25}}}, []string{"2060-12-25", "2061-12-25", "2062-12-25"}},
+	}
+
+	for _, e := range tests {
+		if err := e.rule.Validate(); err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		days := e.rule.Days()
+		if len(days) != len(e.want) {
+			t.Errorf("%s: got %d days, wanted %d", e.name, len(days), len(e.want))
+			continue
+		}
+		for i, d := range days {
+			if d.Format(dateLayout) != e.want[i] {
+				t.Errorf("%s: day %d is %s, wanted %s", e.name, i, d.Format(dateLayout), e.want[i])
+			}
+		}
+	}
+}
+
+func TestRule_Validate(t *testing.T) {
+	var tests = []struct {
+		name string
+		rule Rule
+	}{
+		{"end before start", Rule{Start: date("2060-03-02"), End: date("2060-03-01"), Pattern: Range}},
+		{"too long", Rule{Start: date("2060-01-01"), End: date("2070-01-01"), Pattern: Range}},
+		{"no weekdays", Rule{Start: date("2060-01-01"), End: date("2060-02-01"), Pattern: Weekly}},
+		{"no dates", Rule{Start: date("2060-01-01"), End: date("2060-02-01"), Pattern: Yearly}},
+		{"unknown pattern", Rule{Start: date("2060-01-01"), End: date("2060-02-01"), Pattern: "fortnightly"}},
+	}
+
+	for _, e := range tests {
+		if err := e.rule.Validate(); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
+
+func TestParseMonthDay(t *testing.T) {
+	md, err := ParseMonthDay(" 12-25")
+	if err != nil || md != (MonthDay{time.December, 25}) {
+		t.Errorf("got %v %v", md, err)
+	}
+	md, err = ParseMonthDay("2021-01-02")
+	if err != nil || md != (MonthDay{time.January, 2}) {
+		t.Errorf("got %v %v", md, err)
+	}
+	if _, err = ParseMonthDay("christmas"); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestPlan(t *testing.T) {
+	rule := Rule{Start: date("2060-03-01"), End: date("2060-03-10"), Pattern: Range}
+	existing := []models.RoomRestriction{
+		// a guest staying the nights of the 4th and 5th
+		{ID: 7, StartDate: date("2060-03-04"), EndDate: date("2060-03-06"), RoomID: 1, ReservationID: 3, RestrictionID: models.RestrictionReservation},
+	}
+
+	blocks, conflicts := Plan(1, rule.Days(), existing)
+
+	if len(conflicts) != 1 || conflicts[0].ID != 7 {
+		t.Errorf("expected the reservation to conflict, got %v", conflicts)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks around the reservation, got %d", len(blocks))
+	}
+	if !blocks[0].StartDate.Equal(date("2060-03-01")) || !blocks[0].EndDate.Equal(date("2060-03-04")) {
+		t.Errorf("first block is %v to %v", blocks[0].StartDate, blocks[0].EndDate)
+	}
+	if !blocks[1].StartDate.Equal(date("2060-03-06")) || !blocks[1].EndDate.Equal(date("2060-03-11")) {
+		t.Errorf("second block is %v to %v", blocks[1].StartDate, blocks[1].EndDate)
+	}
+	if blocks[0].RoomID != 1 || blocks[0].RestrictionID != models.RestrictionOwnerBlock {
+		t.Errorf("block has the wrong room or restriction: %v", blocks[0])
+	}
+}
@@ -3,18 +3,39 @@ package config
 import (
 	"html/template"
 	"log"
+	texttemplate "text/template"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
-	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/storage"
 )
 
 // AppConfig holds the application config
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
+	EmailTemplateCache     map[string]*template.Template     // html emails
+	EmailTextTemplateCache map[string]*texttemplate.Template // their plain text alternatives
 	InfoLog       *log.Logger
 	ErrorLog	  *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
-	MailChan 	  chan models.MailData // a channel for mail data
+	Storage       storage.Storage // uploaded photos
+
+	// loaded from flags, environment variables or the config file, see Load
+	Port     string
+	BaseURL  string // e.g. https://bookings.example.com, without a trailing slash
+	DBDriver string // postgres, sqlite or memory
+	DSN      string // postgres connection string
+	DBFile   string // sqlite database file
+	MailTransport string // smtp, file or memory
+	MailDir       string // where the file mailer drops .eml files
+	MailHost      string
+	MailPort      int
+	LinkSecret    []byte // signs the reservation links sent to guests
+	UploadDir     string // where the local storage keeps uploaded photos
+
+	ICalSyncInterval time.Duration // how often the calendars of other booking sites are imported
+	HoldTime         time.Duration // how long a room is held for a guest filling in the reservation form
+	ShutdownTimeout  time.Duration
 }
@@ -0,0 +1,232 @@
+package config
+
+import (
+	"bufio"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one configuration value, it can come from a flag, an environment variable or the config file
+type setting struct {
+	flag  string
+	env   string
+	def   string
+	usage string
+}
+
+var settings = []setting{
+	{"port", "BOOKINGS_PORT", ":8080", "Address to listen on"},
+	{"baseurl", "BOOKINGS_BASE_URL", "http://localhost:8080", "Public address of the site, for links in mail and calendar feeds"},
+	{"production", "BOOKINGS_IN_PRODUCTION", "false", "Run in production mode (secure cookies)"},
+	{"cache", "BOOKINGS_USE_CACHE", "false", "Use the template cache instead of reading templates on each request"},
+	{"dbdriver", "BOOKINGS_DB_DRIVER", "postgres", "Database to use: postgres, sqlite or memory"},
+	{"dsn", "BOOKINGS_DB_DSN", "host=localhost port=5432 dbname=bookings", "Postgres connection string"},
+	{"dbfile", "BOOKINGS_DB_FILE", "bookings.db", "Database file when using sqlite"},
+	{"mailer", "BOOKINGS_MAILER", "smtp", "How to send mail: smtp, file (.eml files in -maildir) or memory"},
+	{"maildir", "BOOKINGS_MAIL_DIR", "mail", "Directory for the .eml files of the file mailer"},
+	{"mailhost", "BOOKINGS_MAIL_HOST", "localhost", "SMTP server host"},
+	{"mailport", "BOOKINGS_MAIL_PORT", "1025", "SMTP server port"},
+	{"uploads", "BOOKINGS_UPLOAD_DIR", "uploads", "Directory the photos uploaded in the admin are kept in"},
+	{"linksecret", "BOOKINGS_LINK_SECRET", "", "Secret that signs the links guests manage their reservation with, at least 32 characters. Random if empty, old links then stop working on restart"},
+	{"icalsync", "BOOKINGS_ICAL_SYNC_INTERVAL", "15m", "How often the calendars of other booking sites are imported"},
+	{"holdtime", "BOOKINGS_HOLD_TIME", "15m", "How long a room is held for a guest filling in the reservation form"},
+	{"shutdowntimeout", "BOOKINGS_SHUTDOWN_TIMEOUT", "30s", "How long to wait for requests and pending mail when shutting down"},
+}
+
+// value is a setting after loading, source says where it came from for error messages
+type value struct {
+	raw    string
+	source string
+}
+
+// Load fills the settings of a from, in order of precedence, command-line flags, environment variables,
+// the config file given by -config (or BOOKINGS_CONFIG) and the defaults.
+// It returns the arguments left after the flags, e.g. the migrate subcommand.
+func Load(a *AppConfig, args []string) ([]string, error) {
+	fs := flag.NewFlagSet("bookings", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("BOOKINGS_CONFIG"), "Optional config file of KEY=value lines, using the environment variable names")
+	flags := make(map[string]*string)
+	for _, s := range settings {
+		flags[s.flag] = fs.String(s.flag, s.def, fmt.Sprintf("%s (env %s)", s.usage, s.env))
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]value)
+	for _, s := range settings {
+		values[s.flag] = value{s.def, "default"}
+	}
+
+	if *configFile != "" {
+		fileValues, err := readConfigFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range settings {
+			if v, ok := fileValues[s.env]; ok {
+				values[s.flag] = v
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			values[s.flag] = value{v, "environment variable " + s.env}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := flags[f.Name]; ok {
+			values[f.Name] = value{*flags[f.Name], "flag -" + f.Name}
+		}
+	})
+
+	err = a.apply(values)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Args(), nil
+}
+
+// readConfigFile reads KEY=value lines, blank lines and lines starting with # are skipped
+func readConfigFile(path string) (map[string]value, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	defer f.Close()
+
+	known := make(map[string]bool)
+	for _, s := range settings {
+		known[s.env] = true
+	}
+
+	values := make(map[string]value)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, v, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !known[key] {
+			return nil, fmt.Errorf("%s line %d: unknown setting %q", path, n, line)
+		}
+		values[key] = value{strings.Trim(strings.TrimSpace(v), `"`), fmt.Sprintf("%s line %d", path, n)}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read config file: %w", err)
+	}
+	return values, nil
+}
+
+// apply parses and validates the loaded values into the config
+func (a *AppConfig) apply(values map[string]value) error {
+	var err error
+	invalid := func(name, reason string) error {
+		return fmt.Errorf("invalid %s %q (from %s): %s", name, values[name].raw, values[name].source, reason)
+	}
+
+	a.Port = values["port"].raw
+	if _, port, err := net.SplitHostPort(a.Port); err != nil || port == "" {
+		return invalid("port", "must look like :8080 or host:8080")
+	}
+
+	a.BaseURL = strings.TrimSuffix(values["baseurl"].raw, "/")
+	if u, err := url.Parse(a.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return invalid("baseurl", "must look like https://bookings.example.com")
+	}
+
+	a.InProduction, err = strconv.ParseBool(values["production"].raw)
+	if err != nil {
+		return invalid("production", "must be true or false")
+	}
+
+	a.UseCache, err = strconv.ParseBool(values["cache"].raw)
+	if err != nil {
+		return invalid("cache", "must be true or false")
+	}
+
+	a.DBDriver = values["dbdriver"].raw
+	a.DSN = values["dsn"].raw
+	a.DBFile = values["dbfile"].raw
+	switch a.DBDriver {
+	case "postgres":
+		if strings.TrimSpace(a.DSN) == "" {
+			return invalid("dsn", "is required for postgres")
+		}
+	case "sqlite":
+		if strings.TrimSpace(a.DBFile) == "" {
+			return invalid("dbfile", "is required for sqlite")
+		}
+	case "memory":
+	default:
+		return invalid("dbdriver", "must be postgres, sqlite or memory")
+	}
+
+	a.MailTransport = values["mailer"].raw
+	a.MailDir = values["maildir"].raw
+	switch a.MailTransport {
+	case "smtp", "memory":
+	case "file":
+		if strings.TrimSpace(a.MailDir) == "" {
+			return invalid("maildir", "is required for the file mailer")
+		}
+	default:
+		return invalid("mailer", "must be smtp, file or memory")
+	}
+
+	a.MailHost = values["mailhost"].raw
+	if strings.TrimSpace(a.MailHost) == "" {
+		return invalid("mailhost", "cannot be blank")
+	}
+	a.MailPort, err = strconv.Atoi(values["mailport"].raw)
+	if err != nil || a.MailPort < 1 || a.MailPort > 65535 {
+		return invalid("mailport", "must be a port number")
+	}
+
+	a.UploadDir = values["uploads"].raw
+	if strings.TrimSpace(a.UploadDir) == "" {
+		return invalid("uploads", "cannot be blank")
+	}
+
+	a.LinkSecret = []byte(values["linksecret"].raw)
+	switch {
+	case len(a.LinkSecret) == 0 && a.InProduction:
+		return invalid("linksecret", "is required in production, links would stop working on every restart")
+	case len(a.LinkSecret) == 0:
+		a.LinkSecret = make([]byte, 32)
+		if _, err := rand.Read(a.LinkSecret); err != nil {
+			return err
+		}
+	case len(a.LinkSecret) < 32:
+		return invalid("linksecret", "must be at least 32 characters")
+	}
+
+	a.ICalSyncInterval, err = time.ParseDuration(values["icalsync"].raw)
+	if err != nil || a.ICalSyncInterval < time.Minute {
+		return invalid("icalsync", "must be a duration of at least 1m")
+	}
+
+	a.HoldTime, err = time.ParseDuration(values["holdtime"].raw)
+	if err != nil || a.HoldTime < time.Minute {
+		return invalid("holdtime", "must be a duration of at least 1m")
+	}
+
+	a.ShutdownTimeout, err = time.ParseDuration(values["shutdowntimeout"].raw)
+	if err != nil || a.ShutdownTimeout <= 0 {
+		return invalid("shutdowntimeout", "must be a duration like 30s")
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Defaults(t *testing.T) {
+	var a AppConfig
+	args, err := Load(&a, []string{"migrate", "status"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Port != ":8080" || a.DBDriver != "postgres" || a.MailPort != 1025 || a.InProduction || a.UseCache {
+		t.Errorf("unexpected defaults: %+v", a)
+	}
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("expected the subcommand to be left over, got %v", args)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bookings.env")
+	err := os.WriteFile(file, []byte("# comment\n\nBOOKINGS_PORT=:9000\nBOOKINGS_MAIL_PORT=2525\nBOOKINGS_USE_CACHE=true\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BOOKINGS_MAIL_PORT", "2626")
+	t.Setenv("BOOKINGS_USE_CACHE", "true")
+
+	var a AppConfig
+	_, err = Load(&a, []string{"-config", file, "-cache=false"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Port != ":9000" {
+		t.Errorf("expected port from the config file, got %s", a.Port)
+	}
+	if a.MailPort != 2626 {
+		t.Errorf("expected the environment to override the config file, got %d", a.MailPort)
+	}
+	if a.UseCache {
+		t.Error("expected the flag to override the environment")
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	var tests = []struct {
+		name    string
+		args    []string
+		message string
+	}{
+		{"port", []string{"-port", "8080"}, "invalid port"},
+		{"base url", []string{"-baseurl", "bookings.example.com"}, "invalid baseurl"},
+		{"production", []string{"-production", "maybe"}, "invalid production"},
+		{"driver", []string{"-dbdriver", "mysql"}, "invalid dbdriver"},
+		{"dsn", []string{"-dsn", ""}, "invalid dsn"},
+		{"sqlite file", []string{"-dbdriver", "sqlite", "-dbfile", ""}, "invalid dbfile"},
+		{"mail port", []string{"-mailport", "0"}, "invalid mailport"},
+		{"mailer", []string{"-mailer", "pigeon"}, "invalid mailer"},
+		{"link secret", []string{"-linksecret", "too short"}, "invalid linksecret"},
+		{"link secret in production", []string{"-production", "true", "-linksecret", ""}, "invalid linksecret"},
+		{"ical sync", []string{"-icalsync", "5s"}, "invalid icalsync"},
+		{"hold time", []string{"-holdtime", "forever"}, "invalid holdtime"},
+		{"mail dir", []string{"-mailer", "file", "-maildir", ""}, "invalid maildir"},
+		{"upload dir", []string{"-uploads", " "}, "invalid uploads"},
+		{"config file", []string{"-config", "does-not-exist.env"}, "cannot read config file"},
+	}
+
+	for _, e := range tests {
+		var a AppConfig
+		_, err := Load(&a, e.args)
+		if err == nil || !strings.Contains(err.Error(), e.message) {
+			t.Errorf("%s: expected error containing %q, got %v", e.name, e.message, err)
+		}
+	}
+}
+
+func TestLoad_UnknownConfigKey(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "bookings.env")
+	os.WriteFile(file, []byte("BOOKINGS_PROT=:9000\n"), 0644)
+
+	var a AppConfig
+	_, err := Load(&a, []string{"-config", file})
+	if err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("expected an error pointing at line 1, got %v", err)
+	}
+}
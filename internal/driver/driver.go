@@ -10,8 +10,15 @@ import (
 )
 
 
+// the database drivers we can connect to
+const (
+	Postgres = "postgres"
+	SQLite   = "sqlite"
+)
+
 type DB struct {
-	SQL *sql.DB
+	SQL    *sql.DB
+	Driver string // Postgres or SQLite, picks the repository implementation
 }
 
 var dbCon = &DB{}
@@ -25,13 +32,14 @@ func ConnectDB(dsn string) (*DB, error) {
 	// create new db and set up config
 	d, err := NewDataBase(dsn)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	d.SetMaxOpenConns(maxOpenDbCon)
 	d.SetConnMaxLifetime(maxDbLifetime)
 	d.SetMaxIdleConns(maxIDleDbCon)
 
 	dbCon.SQL = d
+	dbCon.Driver = Postgres
 
 	err =testDB(d) // ping db
 	
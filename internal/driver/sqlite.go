@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/tsawler/bookings-app/internal/migrate"
+	"github.com/tsawler/bookings-app/migrations"
+	_ "modernc.org/sqlite"
+)
+
+// ConnectSQLite opens (or creates) the sqlite database file at path and makes sure the schema exists
+func ConnectSQLite(path string) (*DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	d, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite has a single writer, one connection avoids "database is locked" errors
+	d.SetMaxOpenConns(1)
+	d.SetConnMaxLifetime(maxDbLifetime)
+
+	err = testDB(d)
+	if err != nil {
+		return nil, err
+	}
+
+	err = createSQLiteSchema(d)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCon.SQL = d
+	dbCon.Driver = SQLite
+	return dbCon, nil
+}
+
+// createSQLiteSchema brings the schema up to date with the embedded sqlite migrations
+func createSQLiteSchema(d *sql.DB) error {
+	m, err := migrate.New(d, migrations.FS, SQLite)
+	if err != nil {
+		return err
+	}
+	_, err = m.Up()
+	return err
+}
@@ -0,0 +1,67 @@
+// Package guestlink signs the links in the confirmation mail that let a guest manage their reservation
+// without an account. A token is <reservation id>.<expiry as unix time>.<hmac of both>, so nothing is stored
+package guestlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalid is returned for tokens we didn't sign, or that were changed
+	ErrInvalid = errors.New("invalid link")
+	// ErrExpired is returned for tokens we signed that are too old
+	ErrExpired = errors.New("link has expired")
+)
+
+// how long after the departure a link keeps working, for a look at the booking after the stay
+const afterDeparture = 7 * 24 * time.Hour
+
+// Expiry returns when the link of a stay ending on departure stops working
+func Expiry(departure time.Time) time.Time {
+	return departure.Add(afterDeparture)
+}
+
+// Sign returns the token for reservation id, valid until expires
+func Sign(secret []byte, id int, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", id, expires.Unix())
+	return payload + "." + mac(secret, payload)
+}
+
+// Verify returns the reservation id of token. The signature is checked before the expiry,
+// so ErrExpired means the token was ours
+func Verify(secret []byte, token string, now time.Time) (int, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, ErrInvalid
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(mac(secret, payload)), []byte(parts[2])) {
+		return 0, ErrInvalid
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil || id <= 0 {
+		return 0, ErrInvalid
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, ErrInvalid
+	}
+	if now.Unix() >= expires {
+		return 0, ErrExpired
+	}
+	return id, nil
+}
+
+func mac(secret []byte, payload string) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
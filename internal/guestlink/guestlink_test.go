@@ -0,0 +1,49 @@
+package guestlink
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignVerify(t *testing.T) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, 42, now.Add(time.Hour))
+
+	id, err := Verify(secret, token, now)
+	if err != nil || id != 42 {
+		t.Errorf("expected reservation 42, got %d, %v", id, err)
+	}
+
+	_, err = Verify(secret, token, now.Add(2*time.Hour))
+	if !errors.Is(err, ErrExpired) {
+		t.Errorf("expected the token to have expired, got %v", err)
+	}
+}
+
+func TestVerify_Invalid(t *testing.T) {
+	now := time.Date(2030, 6, 1, 12, 0, 0, 0, time.UTC)
+	token := Sign(secret, 42, now.Add(time.Hour))
+	parts := strings.Split(token, ".")
+
+	var tests = []struct {
+		name  string
+		token string
+	}{
+		{"empty", ""},
+		{"other reservation", "43." + parts[1] + "." + parts[2]},
+		{"later expiry", parts[0] + ".9999999999." + parts[2]},
+		{"no signature", parts[0] + "." + parts[1]},
+		{"other secret", Sign([]byte("another secret, just as long....."), 42, now.Add(time.Hour))},
+	}
+
+	for _, e := range tests {
+		_, err := Verify(secret, e.token, now)
+		if !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: expected ErrInvalid, got %v", e.name, err)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/apikeys"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// AdminAPIKeys lists the api keys, a key that was just created is shown once
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	m.renderAPIKeys(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderAPIKeys(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	keys, err := m.DB.AllAPIKeys()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	selected := make(map[string]bool)
+	for _, s := range form.Values["scope"] {
+		selected[s] = true
+	}
+
+	data := make(map[string]interface{})
+	data["api_keys"] = keys
+	data["scopes"] = models.APIKeyScopes
+	data["selected_scopes"] = selected
+
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	render.Template(w, r, "admin-api-keys.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostAPIKey creates an api key for the logged in user
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name")
+
+	var scopes []string
+	for _, s := range form.Values["scope"] {
+		if !models.ValidScope(s) {
+			form.Errors.Add("scope", fmt.Sprintf("Unknown scope %s", s))
+			continue
+		}
+		scopes = append(scopes, s)
+	}
+	if len(form.Values["scope"]) == 0 {
+		form.Errors.Add("scope", "Choose at least one scope")
+	}
+
+	if !form.Valid() {
+		m.renderAPIKeys(w, r, form)
+		return
+	}
+
+	key, prefix, hash, err := apikeys.Generate()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.InsertAPIKey(models.APIKey{
+		UserID: m.App.Session.GetInt(r.Context(), "user_id"),
+		Name:   form.Get("name"),
+		Prefix: prefix,
+		Hash:   hash,
+		Scopes: scopes,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// only shown once, we keep nothing but the hash
+	m.App.Session.Put(r.Context(), "new_api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "API key created, copy it now")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an api key, clients using it get a 401 from now on
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.RevokeAPIKey(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
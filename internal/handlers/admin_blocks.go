@@ -0,0 +1,214 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/blocks"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// the weekday checkboxes, in time.Weekday order
+var weekdayNames = []string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+// AdminBlocks shows the form for blocking date ranges and recurring days in one or more rooms
+func (m *Repository) AdminBlocks(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["weekday_names"] = weekdayNames
+	data["selected_rooms"] = map[int]bool{}
+	data["selected_weekdays"] = map[int]bool{}
+
+	render.Template(w, r, "admin-blocks.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+		Data: data,
+	})
+}
+
+// AdminPostBlocks previews the blocks for the submitted rule, with the reservations in the way,
+// and saves them when the save button was pressed
+func (m *Repository) AdminPostBlocks(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	roomNames := make(map[int]string)
+	for _, x := range rooms {
+		roomNames[x.ID] = x.RoomName
+	}
+
+	form := forms.New(r.PostForm)
+	rule, roomIDs := blockRuleFromForm(form, roomNames)
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["weekday_names"] = weekdayNames
+	selectedRooms := make(map[int]bool)
+	for _, id := range roomIDs {
+		selectedRooms[id] = true
+	}
+	data["selected_rooms"] = selectedRooms
+	selectedWeekdays := make(map[int]bool)
+	for _, d := range rule.Weekdays {
+		selectedWeekdays[int(d)] = true
+	}
+	data["selected_weekdays"] = selectedWeekdays
+
+	if !form.Valid() {
+		render.Template(w, r, "admin-blocks.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
+		return
+	}
+
+	days := rule.Days()
+	var planned, conflicts []models.RoomRestriction
+	for _, id := range roomIDs {
+		existing, err := m.DB.GetReservationForRoomByDate(id, rule.Start, rule.End)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		b, c := blocks.Plan(id, days, existing)
+		planned = append(planned, b...)
+		conflicts = append(conflicts, c...)
+	}
+
+	if r.Form.Get("action") == "save" {
+		_, err = m.DB.InsertBlocks(planned)
+		if err == nil {
+			m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d block(s) saved", len(planned)))
+			http.Redirect(w, r, fmt.Sprintf("/admin/reservation-calendar?y=%d&m=%d", rule.Start.Year(), rule.Start.Month()), http.StatusSeeOther)
+			return
+		}
+		if !errors.Is(err, repository.ErrRoomUnavailable) {
+			helpers.ServerError(w, err)
+			return
+		}
+		// booked between the preview and the save, show the preview again
+		m.App.Session.Put(r.Context(), "warning", "Some of these days were just booked, please check the preview again")
+	}
+
+	// names for the preview
+	for i := range planned {
+		planned[i].Room.RoomName = roomNames[planned[i].RoomID]
+	}
+	for i := range conflicts {
+		conflicts[i].Room.RoomName = roomNames[conflicts[i].RoomID]
+		if conflicts[i].ReservationID > 0 {
+			res, err := m.DB.GetReservationByID(conflicts[i].ReservationID)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			conflicts[i].Reservation = res
+		}
+	}
+
+	data["preview"] = true
+	data["blocks"] = planned
+	data["conflicts"] = conflicts
+
+	intMap := make(map[string]int)
+	intMap["blocked_days"] = countDays(planned)
+
+	render.Template(w, r, "admin-blocks.page.tmpl", &models.TemplateData{
+		Form:   form,
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// blockRuleFromForm reads the rule and the chosen rooms, problems are added to the form errors
+func blockRuleFromForm(form *forms.Form, roomNames map[int]string) (blocks.Rule, []int) {
+	form.Required("start_date", "end_date", "pattern")
+
+	layout := "2006-01-02"
+	rule := blocks.Rule{Pattern: form.Get("pattern")}
+
+	var err error
+	if form.Has("start_date") {
+		rule.Start, err = time.Parse(layout, form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+	}
+	if form.Has("end_date") {
+		rule.End, err = time.Parse(layout, form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		}
+	}
+
+	for _, x := range form.Values["weekday"] {
+		d, err := strconv.Atoi(x)
+		if err == nil && d >= 0 && d <= 6 {
+			rule.Weekdays = append(rule.Weekdays, time.Weekday(d))
+		}
+	}
+
+	// yearly dates, e.g. "12-24, 12-25, 01-01"
+	for _, x := range strings.Split(form.Get("dates"), ",") {
+		if strings.TrimSpace(x) == "" {
+			continue
+		}
+		md, err := blocks.ParseMonthDay(x)
+		if err != nil {
+			form.Errors.Add("dates", err.Error())
+			continue
+		}
+		rule.Dates = append(rule.Dates, md)
+	}
+
+	var roomIDs []int
+	for _, x := range form.Values["room"] {
+		id, err := strconv.Atoi(x)
+		if err != nil || roomNames[id] == "" {
+			form.Errors.Add("room", "Unknown room")
+			continue
+		}
+		roomIDs = append(roomIDs, id)
+	}
+	if len(form.Values["room"]) == 0 {
+		form.Errors.Add("room", "Choose at least one room")
+	}
+
+	if form.Valid() {
+		if err := rule.Validate(); err != nil {
+			form.Errors.Add("pattern", err.Error())
+		}
+	}
+
+	return rule, roomIDs
+}
+
+// countDays adds up the days covered by restrictions
+func countDays(restrictions []models.RoomRestriction) int {
+	n := 0
+	for _, rr := range restrictions {
+		n += int(rr.EndDate.Sub(rr.StartDate).Hours() / 24)
+	}
+	return n
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/ical"
+	"github.com/tsawler/bookings-app/internal/icalsync"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// the largest calendar file that can be uploaded
+const maxCalendarUpload = 5 << 20
+
+// AdminICalSources lists the calendars of other booking sites that are imported
+func (m *Repository) AdminICalSources(w http.ResponseWriter, r *http.Request) {
+	m.renderICalSources(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderICalSources(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	sources, err := m.DB.AllICalSources()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["sources"] = sources
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-ical-sources.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostICalSource adds a calendar, from a url or an uploaded file, and imports it right away
+func (m *Repository) AdminPostICalSource(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseMultipartForm(maxCalendarUpload)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room", "name")
+
+	src := models.ICalSource{
+		Name: form.Get("name"),
+		URL:  strings.TrimSpace(form.Get("url")),
+	}
+
+	src.RoomID, _ = strconv.Atoi(form.Get("room"))
+	if form.Has("room") {
+		if _, err := m.DB.GetRoomByID(src.RoomID); err != nil {
+			form.Errors.Add("room", "Unknown room")
+		}
+	}
+
+	file, _, err := r.FormFile("calendar")
+	if err == nil {
+		defer file.Close()
+		content, err := io.ReadAll(io.LimitReader(file, maxCalendarUpload))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		src.Content = string(content)
+	}
+
+	switch {
+	case src.URL != "" && src.Content != "":
+		form.Errors.Add("url", "Give a url or upload a file, not both")
+	case src.URL != "":
+		if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			form.Errors.Add("url", "Invalid url")
+		}
+	case src.Content != "":
+		if _, err := ical.Parse(strings.NewReader(src.Content)); err != nil {
+			form.Errors.Add("calendar", fmt.Sprintf("Can't read the file: %s", err))
+		}
+	default:
+		form.Errors.Add("url", "Give the url of the calendar or upload a file")
+	}
+
+	if !form.Valid() {
+		m.renderICalSources(w, r, form)
+		return
+	}
+
+	src.ID, err = m.DB.InsertICalSource(src)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	m.syncICalSource(w, r, src.ID)
+}
+
+// AdminSyncICalSource imports a calendar now instead of waiting for the next sync
+func (m *Repository) AdminSyncICalSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	m.syncICalSource(w, r, id)
+}
+
+// syncICalSource syncs a calendar and tells the admin how it went
+func (m *Repository) syncICalSource(w http.ResponseWriter, r *http.Request, id int) {
+	src, err := m.DB.GetICalSourceByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	syncer := icalsync.New(m.DB, m.App.ICalSyncInterval, m.App.InfoLog, m.App.ErrorLog)
+	result, err := syncer.Sync(r.Context(), src)
+	switch {
+	case err != nil:
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Could not import %s: %s", src.Name, err))
+	case len(result.Conflicts) > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Imported %s: %s. Some events overlap reservations or blocks of ours, see below", src.Name, result))
+	default:
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %s: %s", src.Name, result))
+	}
+	http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+}
+
+// AdminDeleteICalSource stops importing a calendar and removes its blocks
+func (m *Repository) AdminDeleteICalSource(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteICalSource(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Calendar removed, its blocks are gone")
+	http.Redirect(w, r, "/admin/ical-sources", http.StatusSeeOther)
+}
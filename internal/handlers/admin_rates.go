@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// AdminRates shows the rates of every room and the seasons that override them
+func (m *Repository) AdminRates(w http.ResponseWriter, r *http.Request) {
+	m.renderRates(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderRates(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	seasons, err := m.DB.AllSeasonalRates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["seasons"] = seasons
+
+	render.Template(w, r, "admin-rates.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostRoomRates sets the nightly and weekend rate of a room
+func (m *Repository) AdminPostRoomRates(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	nightly, err := pricing.ParseAmount(r.Form.Get("nightly_rate"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Invalid nightly rate for %s", room.RoomName))
+		http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+		return
+	}
+	weekend, err := optionalAmount(r.Form.Get("weekend_rate"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Invalid weekend rate for %s", room.RoomName))
+		http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomRates(room.ID, nightly, weekend)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Rates of %s saved, they apply to new reservations", room.RoomName))
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// AdminPostSeasonalRate adds a season with its own rates for a room
+func (m *Repository) AdminPostSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room", "name", "first_night", "last_night", "nightly_rate")
+
+	var s models.SeasonalRate
+	s.Name = form.Get("name")
+	s.RoomID, _ = strconv.Atoi(form.Get("room"))
+	if form.Has("room") {
+		if _, err := m.DB.GetRoomByID(s.RoomID); err != nil {
+			form.Errors.Add("room", "Unknown room")
+		}
+	}
+
+	layout := "2006-01-02"
+	firstNight, err1 := time.Parse(layout, form.Get("first_night"))
+	lastNight, err2 := time.Parse(layout, form.Get("last_night"))
+	switch {
+	case !form.Has("first_night") || !form.Has("last_night"):
+	case err1 != nil:
+		form.Errors.Add("first_night", "Invalid date")
+	case err2 != nil:
+		form.Errors.Add("last_night", "Invalid date")
+	case lastNight.Before(firstNight):
+		form.Errors.Add("last_night", "The last night can't be before the first")
+	}
+	s.FirstNight = firstNight
+	s.LastNight = lastNight
+
+	if form.Has("nightly_rate") {
+		s.NightlyRate, err = pricing.ParseAmount(form.Get("nightly_rate"))
+		if err != nil {
+			form.Errors.Add("nightly_rate", "Invalid amount")
+		}
+	}
+	s.WeekendRate, err = optionalAmount(form.Get("weekend_rate"))
+	if err != nil {
+		form.Errors.Add("weekend_rate", "Invalid amount")
+	}
+
+	if !form.Valid() {
+		m.renderRates(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertSeasonalRate(s)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Season %s added", s.Name))
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// AdminDeleteSeasonalRate removes a season, its nights go back to the rates of the room
+func (m *Repository) AdminDeleteSeasonalRate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteSeasonalRate(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Season removed")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// optionalAmount is ParseAmount for the weekend rates, left empty they are 0: the same as the nightly rate
+func optionalAmount(s string) (int, error) {
+	if strings.TrimSpace(s) == "" {
+		return 0, nil
+	}
+	return pricing.ParseAmount(s)
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/photos"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// the most photos taken in one upload
+const maxPhotosPerUpload = 20
+
+// the largest upload request read, the photos at their largest and room for the rest of the form
+const maxUploadBytes = maxPhotosPerUpload*photos.MaxSize + 1<<20
+
+// AdminRoomPhotos shows the photos of a room with the form to upload more
+func (m *Repository) AdminRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	if room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	roomPhotos, err := m.DB.RoomPhotos(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = roomPhotos
+
+	render.Template(w, r, "admin-room-photos.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminPostRoomPhotos uploads photos of a room. Every file is checked on its own,
+// the good ones are kept and the others are listed in the error
+func (m *Repository) AdminPostRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	if room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	redirect := fmt.Sprintf("/admin/rooms/%d/photos", room.ID)
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+	err := r.ParseMultipartForm(photos.MaxSize)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		helpers.ClientError(w, http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	files := r.MultipartForm.File["photos"]
+	if len(files) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Choose the photos to upload")
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+	if len(files) > maxPhotosPerUpload {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Upload at most %d photos at a time", maxPhotosPerUpload))
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
+		return
+	}
+
+	service := photos.New(m.App.Storage)
+	var rejected []string
+	added := 0
+	for _, fh := range files {
+		if fh.Size > photos.MaxSize {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", fh.Filename, photos.ErrTooLarge))
+			continue
+		}
+		f, err := fh.Open()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		p, err := service.Save(room.ID, f)
+		f.Close()
+		if errors.Is(err, photos.ErrTooLarge) || errors.Is(err, photos.ErrUnsupportedType) || errors.Is(err, photos.ErrInvalidImage) {
+			rejected = append(rejected, fmt.Sprintf("%s: %s", fh.Filename, err))
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		_, err = m.DB.InsertRoomPhoto(p)
+		if err != nil {
+			service.Delete(p)
+			helpers.ServerError(w, err)
+			return
+		}
+		added++
+	}
+
+	if added > 0 {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%d photo(s) of %s added", added, room.RoomName))
+	}
+	if len(rejected) > 0 {
+		m.App.Session.Put(r.Context(), "error", "Not added: "+strings.Join(rejected, ", "))
+	}
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from the room and its files from the storage
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.adminRoomPhotoFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoomPhoto(p.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the photo is gone from the site already, files left behind only take up space
+	err = photos.New(m.App.Storage).Delete(p)
+	if err != nil {
+		m.App.ErrorLog.Println("deleting the files of photo", p.ID, err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", p.RoomID), http.StatusSeeOther)
+}
+
+// AdminMoveRoomPhoto moves a photo one place up or down, the first one is shown in the room lists
+func (m *Repository) AdminMoveRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	p, ok := m.adminRoomPhotoFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	roomPhotos, err := m.DB.RoomPhotos(p.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	ids := make([]int, len(roomPhotos))
+	for i, photo := range roomPhotos {
+		ids[i] = photo.ID
+	}
+	moveID(ids, p.ID, r.Form.Get("direction") == "up")
+
+	err = m.DB.ReorderRoomPhotos(p.RoomID, ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", p.RoomID), http.StatusSeeOther)
+}
+
+// adminRoomPhotoFromURL loads the photo in the url, it has to be one of the room in the url.
+// if ok is false the response is written
+func (m *Repository) adminRoomPhotoFromURL(w http.ResponseWriter, r *http.Request) (models.RoomPhoto, bool) {
+	roomID, err1 := strconv.Atoi(chi.URLParam(r, "id"))
+	id, err2 := strconv.Atoi(chi.URLParam(r, "photo"))
+	if err1 != nil || err2 != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.RoomPhoto{}, false
+	}
+	p, err := m.DB.GetRoomPhotoByID(id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && p.RoomID != roomID) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.RoomPhoto{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.RoomPhoto{}, false
+	}
+	return p, true
+}
@@ -0,0 +1,266 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// the most guests a room can be for, more is surely a typo
+const maxCapacity = 50
+
+// slugs are lowercase words joined by dashes, they end up in the url of the room page
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// AdminRooms lists the rooms in the order the site shows them, and the archived ones
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	archived, err := m.DB.ArchivedRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["archived"] = archived
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminRoom shows the form for a room, a new one under /admin/rooms/new
+func (m *Repository) AdminRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	form := forms.New(nil)
+	if room.ID == 0 {
+		room.Capacity = 2
+	}
+	m.renderAdminRoom(w, r, room, form)
+}
+
+func (m *Repository) renderAdminRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["room"] = room
+
+	stringMap := make(map[string]string)
+	stringMap["capacity"] = strconv.Itoa(room.Capacity)
+	stringMap["amenities"] = strings.Join(room.Amenities, "\n")
+	// what was typed, so a rejected form can be corrected
+	if form.Values != nil {
+		stringMap["capacity"] = form.Get("capacity")
+		stringMap["amenities"] = form.Get("amenities")
+	}
+
+	render.Template(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// AdminPostRoom creates a room or saves the changes to one
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "capacity")
+
+	room.RoomName = strings.TrimSpace(form.Get("name"))
+	room.Description = strings.TrimSpace(form.Get("description"))
+	room.Amenities = nil
+	for _, a := range strings.Split(form.Get("amenities"), "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			room.Amenities = append(room.Amenities, a)
+		}
+	}
+
+	room.Slug = strings.TrimSpace(form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+	}
+	if form.Has("name") && !slugPattern.MatchString(room.Slug) {
+		form.Errors.Add("slug", "Use lowercase letters, digits and dashes only")
+	}
+
+	room.Capacity, err = strconv.Atoi(form.Get("capacity"))
+	if form.Has("capacity") && (err != nil || room.Capacity < 1 || room.Capacity > maxCapacity) {
+		form.Errors.Add("capacity", fmt.Sprintf("Enter a number of guests from 1 to %d", maxCapacity))
+	}
+
+	if form.Valid() {
+		if room.ID == 0 {
+			room.ID, err = m.DB.InsertRoom(room)
+		} else {
+			err = m.DB.UpdateRoom(room)
+		}
+		if errors.Is(err, repository.ErrSlugTaken) {
+			form.Errors.Add("slug", "Another room has this slug already")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		m.renderAdminRoom(w, r, room, form)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s saved", room.RoomName))
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminArchiveRoom takes a room off the site, its reservations are kept
+func (m *Repository) AdminArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, true)
+}
+
+// AdminRestoreRoom puts an archived room back on the site
+func (m *Repository) AdminRestoreRoom(w http.ResponseWriter, r *http.Request) {
+	m.setRoomArchived(w, r, false)
+}
+
+func (m *Repository) setRoomArchived(w http.ResponseWriter, r *http.Request, archived bool) {
+	room, ok := m.adminRoomFromURL(w, r)
+	if !ok {
+		return
+	}
+	if room.ID == 0 {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err := m.DB.SetRoomArchived(room.ID, archived)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if archived {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s archived, it can't be booked any more", room.RoomName))
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s is back on the site", room.RoomName))
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminMoveRoom moves a room one place up or down in the list
+func (m *Repository) AdminMoveRoom(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	ids := make([]int, len(rooms))
+	for i, room := range rooms {
+		ids[i] = room.ID
+	}
+	moveID(ids, id, r.Form.Get("direction") == "up")
+
+	err = m.DB.ReorderRooms(ids)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// moveID swaps id with the one before it, or after it if up is false. ids at the ends stay put
+func moveID(ids []int, id int, up bool) {
+	for i := range ids {
+		if ids[i] != id {
+			continue
+		}
+		j := i + 1
+		if up {
+			j = i - 1
+		}
+		if j >= 0 && j < len(ids) {
+			ids[i], ids[j] = ids[j], ids[i]
+		}
+		return
+	}
+}
+
+// adminRoomFromURL loads the room of the id in the url, an empty room for /admin/rooms/new.
+// if ok is false the response is written
+func (m *Repository) adminRoomFromURL(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	param := chi.URLParam(r, "id")
+	if param == "" {
+		return models.Room{}, true
+	}
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Room{}, false
+	}
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Room{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.Room{}, false
+	}
+	return room, true
+}
+
+// slugify makes a slug of a room name, "Major's Suite" becomes "majors-suite"
+func slugify(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, c := range strings.ToLower(name) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'':
+			// dropped, not a word break
+		default:
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// the longest limit in days or nights the stay rules take, ten years
+const maxRuleDays = 3650
+
+// AdminStayRules shows the stay rules of every room and the dates closed to arrival or departure
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	m.renderStayRules(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderStayRules(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	closed, err := m.DB.AllClosedDates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["closed"] = closed
+	data["weekdays"] = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday}
+
+	render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminPostRoomStayRules sets the stay rules of a room
+func (m *Repository) AdminPostRoomStayRules(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	rules, msg := parseStayRules(r.PostForm)
+	if msg != "" {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s: %s", room.RoomName, msg))
+		http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomStayRules(room.ID, rules)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Stay rules of %s saved, they apply to new bookings", room.RoomName))
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// parseStayRules reads the stay rules form of a room, msg says what's wrong with it if anything
+func parseStayRules(values url.Values) (rules models.StayRules, msg string) {
+	form := forms.New(values)
+
+	var ok bool
+	if rules.MinNights, ok = ruleDays(form.Get("min_nights"), 1); !ok || rules.MinNights == 0 {
+		return rules, "The minimum stay is at least 1 night"
+	}
+	if rules.MaxNights, ok = ruleDays(form.Get("max_nights"), 0); !ok {
+		return rules, "Invalid maximum stay"
+	}
+	if rules.MaxNights > 0 && rules.MaxNights < rules.MinNights {
+		return rules, "The maximum stay can't be shorter than the minimum"
+	}
+	if rules.LeadDays, ok = ruleDays(form.Get("lead_days"), 0); !ok {
+		return rules, "Invalid number of days before arrival"
+	}
+	if rules.WindowDays, ok = ruleDays(form.Get("window_days"), 0); !ok {
+		return rules, "Invalid number of days ahead"
+	}
+	if rules.WindowDays > 0 && rules.WindowDays < rules.LeadDays {
+		return rules, "Booking can't open fewer days ahead than it closes"
+	}
+
+	for _, d := range values["arrival_days"] {
+		day, err := strconv.Atoi(d)
+		if err != nil || day < int(time.Sunday) || day > int(time.Saturday) {
+			return rules, "Invalid arrival day"
+		}
+		rules.ArrivalDays |= 1 << day
+	}
+	if rules.ArrivalDays == 0 {
+		return rules, "Choose at least one arrival day"
+	}
+	return rules, ""
+}
+
+// ruleDays reads a number of days or nights, empty is the default def
+func ruleDays(s string, def int) (int, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return def, true
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 || n > maxRuleDays {
+		return 0, false
+	}
+	return n, true
+}
+
+// AdminPostClosedDate closes days of a room to arrivals, departures or both
+func (m *Repository) AdminPostClosedDate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room", "first_date", "last_date")
+
+	var c models.ClosedDate
+	c.Note = strings.TrimSpace(form.Get("note"))
+	c.RoomID, _ = strconv.Atoi(form.Get("room"))
+	if form.Has("room") {
+		if _, err := m.DB.GetRoomByID(c.RoomID); err != nil {
+			form.Errors.Add("room", "Unknown room")
+		}
+	}
+
+	layout := "2006-01-02"
+	firstDate, err1 := time.Parse(layout, form.Get("first_date"))
+	lastDate, err2 := time.Parse(layout, form.Get("last_date"))
+	switch {
+	case !form.Has("first_date") || !form.Has("last_date"):
+	case err1 != nil:
+		form.Errors.Add("first_date", "Invalid date")
+	case err2 != nil:
+		form.Errors.Add("last_date", "Invalid date")
+	case lastDate.Before(firstDate):
+		form.Errors.Add("last_date", "The last day can't be before the first")
+	}
+	c.FirstDate = firstDate
+	c.LastDate = lastDate
+
+	c.ClosedToArrival = form.Get("closed_to_arrival") != ""
+	c.ClosedToDeparture = form.Get("closed_to_departure") != ""
+	if !c.ClosedToArrival && !c.ClosedToDeparture {
+		form.Errors.Add("closed_to", "Close the days to arrivals, departures or both")
+	}
+
+	if !form.Valid() {
+		m.renderStayRules(w, r, form)
+		return
+	}
+
+	_, err = m.DB.InsertClosedDate(c)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Closed dates added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteClosedDate opens closed dates again
+func (m *Repository) AdminDeleteClosedDate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteClosedDate(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Closed dates removed")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
@@ -0,0 +1,555 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/api"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/stayrules"
+)
+
+// the json api under /api/v1, it uses the same database repo as the html pages
+
+const apiDateLayout = "2006-01-02"
+
+// apiError is the body of every error response: {"error": {"status": 422, "message": "...", "fields": {...}}}
+type apiError struct {
+	Status  int                 `json:"status"`
+	Message string              `json:"message"`
+	Fields  map[string][]string `json:"fields,omitempty"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiRoom struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type apiRoomsResponse struct {
+	Rooms []apiRoom `json:"rooms"`
+}
+
+type apiAvailabilityResponse struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Rooms     []apiRoom `json:"rooms"` // the rooms free for the whole stay
+}
+
+type apiReservation struct {
+	ID        int       `json:"id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	Email     string    `json:"email"`
+	Phone     string    `json:"phone"`
+	Room      apiRoom   `json:"room"`
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Status    string    `json:"status"`
+	Price     int       `json:"price"` // the total in cents
+	Adults    int       `json:"adults"`
+	Children  int       `json:"children"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type apiReservationResponse struct {
+	Reservation apiReservation `json:"reservation"`
+}
+
+// apiReservationRequest is the body for creating a reservation
+type apiReservationRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Adults    int    `json:"adults"` // 1 if left out
+	Children  int    `json:"children"`
+}
+
+// apiBlock is an owner block, the room is free again on the end date
+type apiBlock struct {
+	ID        int     `json:"id"`
+	Room      apiRoom `json:"room"`
+	StartDate string  `json:"start_date"`
+	EndDate   string  `json:"end_date"`
+}
+
+type apiBlocksResponse struct {
+	Blocks []apiBlock `json:"blocks"`
+}
+
+type apiBlockResponse struct {
+	Block apiBlock `json:"block"`
+}
+
+// apiBlockRequest is the body for creating an owner block
+type apiBlockRequest struct {
+	RoomID    int    `json:"room_id"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func toAPIRoom(room models.Room) apiRoom {
+	return apiRoom{ID: room.ID, Name: room.RoomName}
+}
+
+func toAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:        res.ID,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		Room:      toAPIRoom(res.Room),
+		StartDate: res.StartDate.Format(apiDateLayout),
+		EndDate:   res.EndDate.Format(apiDateLayout),
+		Status:    res.Status,
+		Price:     res.Price,
+		Adults:    res.Adults,
+		Children:  res.Children,
+		CreatedAt: res.CreatedAt,
+	}
+}
+
+// writeAPIError sends the error envelope
+func writeAPIError(w http.ResponseWriter, status int, message string, fields map[string][]string) {
+	writeJSONResponse(w, status, apiErrorResponse{Error: apiError{
+		Status:  status,
+		Message: message,
+		Fields:  fields,
+	}})
+}
+
+// APIError sends the error envelope, for the api middleware
+func APIError(w http.ResponseWriter, status int, message string) {
+	writeAPIError(w, status, message, nil)
+}
+
+// apiServerError logs err and sends a 500 without the details
+func (m *Repository) apiServerError(w http.ResponseWriter, err error) {
+	m.App.ErrorLog.Println(err)
+	writeAPIError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError), nil)
+}
+
+// APINotFound answers unknown api routes
+func (m *Repository) APINotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusNotFound, "no such endpoint", nil)
+}
+
+// APIMethodNotAllowed answers known api routes called with the wrong method
+func (m *Repository) APIMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed), nil)
+}
+
+// OpenAPI serves the OpenAPI document of the json routes
+func (m *Repository) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(api.OpenAPI)
+}
+
+// APIRooms lists all rooms
+func (m *Repository) APIRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	resp := apiRoomsResponse{Rooms: []apiRoom{}}
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, toAPIRoom(room))
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+// APIAvailability lists the rooms free from start to end with room for adults and children,
+// or just checks room_id if it is given
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	start, end := apiStayDates(form)
+	adults, children := guestCounts(form, 0)
+	roomID := 0
+	if form.Has("room_id") {
+		var err error
+		roomID, err = strconv.Atoi(form.Get("room_id"))
+		if err != nil || roomID < 1 {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid query", form.Errors)
+		return
+	}
+
+	resp := apiAvailabilityResponse{
+		StartDate: start.Format(apiDateLayout),
+		EndDate:   end.Format(apiDateLayout),
+		Rooms:     []apiRoom{},
+	}
+
+	var rooms []models.Room
+	if roomID > 0 {
+		// archived rooms can't be booked, they aren't found either
+		room, ok, err := m.bookableRoom(roomID)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		if !ok {
+			writeAPIError(w, http.StatusNotFound, "room not found", nil)
+			return
+		}
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(start, end, roomID)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		if available && room.Capacity >= adults+children {
+			rooms = append(rooms, room)
+		}
+	} else {
+		var err error
+		rooms, err = m.DB.SearchAvailabilityForAllRooms(start, end, adults+children)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+	}
+
+	// like the website, rooms whose stay rules don't allow these dates aren't free for them
+	stays := stayrules.New(m.DB)
+	for _, room := range rooms {
+		violations, err := stays.Check(room.ID, start, end)
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		if len(violations) == 0 {
+			resp.Rooms = append(resp.Rooms, toAPIRoom(room))
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+// APICreateReservation books a room, the guest gets the same confirmation mail as on the website
+func (m *Repository) APICreateReservation(w http.ResponseWriter, r *http.Request) {
+	var body apiReservationRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	// validate like the reservation form
+	form := forms.New(url.Values{
+		"first_name": {body.FirstName},
+		"last_name":  {body.LastName},
+		"email":      {body.Email},
+		"phone":      {body.Phone},
+		"start_date": {body.StartDate},
+		"end_date":   {body.EndDate},
+		"adults":     {apiCount(body.Adults)},
+		"children":   {apiCount(body.Children)},
+	})
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
+	form.IsEmail("email")
+	start, end := apiStayDates(form)
+	adults, children := guestCounts(form, 0)
+	if body.RoomID < 1 {
+		form.Errors.Add("room_id", "This field cannot be blank")
+	}
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid reservation", form.Errors)
+		return
+	}
+
+	room, ok, err := m.bookableRoom(body.RoomID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if !ok {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid reservation", map[string][]string{"room_id": {"Unknown room"}})
+		return
+	}
+	if adults+children > room.Capacity {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid reservation", map[string][]string{"adults": {tooManyGuests(room.Capacity)}})
+		return
+	}
+	// the api books what the site books, no more
+	violations, err := stayrules.New(m.DB).Check(room.ID, start, end)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	if len(violations) > 0 {
+		stayrules.AddErrors(form, violations, "start_date", "end_date")
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid reservation", form.Errors)
+		return
+	}
+
+	quote, err := pricing.New(m.DB).Quote(room.ID, start, end)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: body.FirstName,
+		LastName:  body.LastName,
+		Email:     body.Email,
+		Phone:     body.Phone,
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    room.ID,
+		Room:      room,
+		Price:     quote.Total,
+		Adults:    adults,
+		Children:  children,
+	}
+
+	newReservationID, err := m.DB.BookReservation(reservation, 0, func(res models.Reservation) ([]models.MailData, error) {
+		return m.reservationMails(res, quote)
+	})
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		writeAPIError(w, http.StatusConflict, "the room is not available for those dates", nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(newReservationID)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/reservations/%d", res.ID))
+	writeJSONResponse(w, http.StatusCreated, apiReservationResponse{Reservation: toAPIReservation(res)})
+}
+
+// APIReservation shows one reservation
+func (m *Repository) APIReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+	writeJSONResponse(w, http.StatusOK, apiReservationResponse{Reservation: toAPIReservation(res)})
+}
+
+// APICancelReservation cancels a reservation, the room is free again for its dates.
+// The reservation is kept, with status cancelled
+func (m *Repository) APICancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.apiReservationFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.CancelReservation(res, nil)
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		writeAPIError(w, http.StatusConflict, fmt.Sprintf("a %s reservation can't be cancelled", res.Status), nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIBlocks lists the owner blocks from start_date to end_date, of all rooms or of room_id
+func (m *Repository) APIBlocks(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(r.URL.Query())
+	start, end := apiStayDates(form)
+	if form.Has("room_id") {
+		roomID, err := strconv.Atoi(form.Get("room_id"))
+		if err != nil || roomID < 1 {
+			form.Errors.Add("room_id", "Invalid room")
+		}
+	}
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid query", form.Errors)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	resp := apiBlocksResponse{Blocks: []apiBlock{}}
+	for _, room := range rooms {
+		if form.Has("room_id") && strconv.Itoa(room.ID) != form.Get("room_id") {
+			continue
+		}
+		// the last day of the range is the day before end
+		restrictions, err := m.DB.GetReservationForRoomByDate(room.ID, start, end.AddDate(0, 0, -1))
+		if err != nil {
+			m.apiServerError(w, err)
+			return
+		}
+		for _, rr := range restrictions {
+			if rr.RestrictionID == models.RestrictionOwnerBlock {
+				rr.Room = room
+				resp.Blocks = append(resp.Blocks, toAPIBlock(rr))
+			}
+		}
+	}
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+// APICreateBlock blocks a room from start_date until end_date
+func (m *Repository) APICreateBlock(w http.ResponseWriter, r *http.Request) {
+	var body apiBlockRequest
+	if !decodeJSONBody(w, r, &body) {
+		return
+	}
+
+	form := forms.New(url.Values{
+		"start_date": {body.StartDate},
+		"end_date":   {body.EndDate},
+	})
+	start, end := apiStayDates(form)
+	if body.RoomID < 1 {
+		form.Errors.Add("room_id", "This field cannot be blank")
+	}
+	if !form.Valid() {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid block", form.Errors)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(body.RoomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusUnprocessableEntity, "invalid block", map[string][]string{"room_id": {"Unknown room"}})
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+
+	block := models.RoomRestriction{
+		StartDate:     start,
+		EndDate:       end,
+		RoomID:        room.ID,
+		RestrictionID: models.RestrictionOwnerBlock,
+		Room:          room,
+	}
+	ids, err := m.DB.InsertBlocks([]models.RoomRestriction{block})
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		writeAPIError(w, http.StatusConflict, "the room is not free for those dates", nil)
+		return
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	block.ID = ids[0]
+
+	writeJSONResponse(w, http.StatusCreated, apiBlockResponse{Block: toAPIBlock(block)})
+}
+
+// APIDeleteBlock removes an owner block
+func (m *Repository) APIDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "block not found", nil)
+		return
+	}
+
+	n, err := m.DB.DeleteBlockByID(id)
+	if err != nil {
+		m.apiServerError(w, err)
+		return
+	}
+	// reservations and other restrictions are not blocks either
+	if n == 0 {
+		writeAPIError(w, http.StatusNotFound, "block not found", nil)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func toAPIBlock(rr models.RoomRestriction) apiBlock {
+	return apiBlock{
+		ID:        rr.ID,
+		Room:      toAPIRoom(rr.Room),
+		StartDate: rr.StartDate.Format(apiDateLayout),
+		EndDate:   rr.EndDate.Format(apiDateLayout),
+	}
+}
+
+// decodeJSONBody reads the json request body into v, it sends the error response and returns false when it can't
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeAPIError(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %s", err), nil)
+		return false
+	}
+	return true
+}
+
+// apiReservationFromURL loads the reservation {id}, the error response is sent when it can't
+func (m *Repository) apiReservationFromURL(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "reservation not found", nil)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeAPIError(w, http.StatusNotFound, "reservation not found", nil)
+		return res, false
+	}
+	if err != nil {
+		m.apiServerError(w, err)
+		return res, false
+	}
+	return res, true
+}
+
+// apiCount is a count from a json body as a form value, 0 (or left out) is empty so the form default applies
+func apiCount(n int) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.Itoa(n)
+}
+
+// apiStayDates reads start_date and end_date, problems are added to the form errors
+func apiStayDates(form *forms.Form) (time.Time, time.Time) {
+	form.Required("start_date", "end_date")
+
+	start, err := time.Parse(apiDateLayout, form.Get("start_date"))
+	if err != nil && form.Has("start_date") {
+		form.Errors.Add("start_date", "Invalid date, use YYYY-MM-DD")
+	}
+	end, err := time.Parse(apiDateLayout, form.Get("end_date"))
+	if err != nil && form.Has("end_date") {
+		form.Errors.Add("end_date", "Invalid date, use YYYY-MM-DD")
+	}
+	if form.Errors.Get("start_date") == "" && form.Errors.Get("end_date") == "" && !end.After(start) {
+		form.Errors.Add("end_date", "The end date must be after the start date")
+	}
+	return start, end
+}
@@ -0,0 +1,282 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// apiRequest sends a request to the api routes and decodes the json answer into v, if v isn't nil
+func apiRequest(t *testing.T, method, url, body string, v interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	getAPIRoutes().ServeHTTP(rr, req)
+
+	if v != nil && rr.Body.Len() > 0 {
+		if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: can't parse %q: %v", method, url, rr.Body.String(), err)
+		}
+	}
+	return rr
+}
+
+func TestAPI_Rooms(t *testing.T) {
+	var resp apiRoomsResponse
+	rr := apiRequest(t, "GET", "/api/v1/rooms", "", &resp)
+	if rr.Code != http.StatusOK || len(resp.Rooms) != 2 {
+		t.Errorf("got %d with rooms %v", rr.Code, resp.Rooms)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("wrong content type %q", ct)
+	}
+}
+
+func TestAPI_Availability(t *testing.T) {
+	var tests = []struct {
+		name           string
+		query          string
+		expectedStatus int
+		expectedRooms  int
+		errorField     string
+	}{
+		{"all rooms", "start_date=2070-01-01&end_date=2070-01-05", http.StatusOK, 2, ""},
+		{"one room", "start_date=2070-01-01&end_date=2070-01-05&room_id=2", http.StatusOK, 1, ""},
+		{"family of four", "start_date=2070-01-01&end_date=2070-01-05&adults=2&children=2", http.StatusOK, 1, ""},
+		{"too many for the room", "start_date=2070-01-01&end_date=2070-01-05&room_id=1&adults=3", http.StatusOK, 0, ""},
+		{"no adults", "start_date=2070-01-01&end_date=2070-01-05&adults=0", http.StatusUnprocessableEntity, 0, "adults"},
+		{"unknown room", "start_date=2070-01-01&end_date=2070-01-05&room_id=99", http.StatusNotFound, 0, ""},
+		{"missing end", "start_date=2070-01-01", http.StatusUnprocessableEntity, 0, "end_date"},
+		{"bad date", "start_date=01/01/2070&end_date=2070-01-05", http.StatusUnprocessableEntity, 0, "start_date"},
+		{"end before start", "start_date=2070-01-05&end_date=2070-01-01", http.StatusUnprocessableEntity, 0, "end_date"},
+	}
+
+	for _, e := range tests {
+		var resp struct {
+			apiAvailabilityResponse
+			apiErrorResponse
+		}
+		rr := apiRequest(t, "GET", "/api/v1/availability?"+e.query, "", &resp)
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if len(resp.Rooms) != e.expectedRooms {
+			t.Errorf("%s: got %d rooms, wanted %d", e.name, len(resp.Rooms), e.expectedRooms)
+		}
+		if e.expectedStatus != http.StatusOK && resp.Error.Status != e.expectedStatus {
+			t.Errorf("%s: error envelope has status %d", e.name, resp.Error.Status)
+		}
+		if e.errorField != "" && len(resp.Error.Fields[e.errorField]) == 0 {
+			t.Errorf("%s: no error for %s in %v", e.name, e.errorField, resp.Error.Fields)
+		}
+	}
+}
+
+func TestAPI_AvailabilityStayRules(t *testing.T) {
+	// room 2 takes a week at least
+	rules := models.DefaultStayRules
+	rules.MinNights = 7
+	if err := Repo.DB.UpdateRoomStayRules(2, rules); err != nil {
+		t.Fatal(err)
+	}
+	defer Repo.DB.UpdateRoomStayRules(2, models.DefaultStayRules)
+
+	var tests = []struct {
+		name  string
+		query string
+		rooms []int
+	}{
+		{"too short for room 2", "start_date=2072-01-01&end_date=2072-01-05", []int{1}},
+		{"only room 2, too short", "start_date=2072-01-01&end_date=2072-01-05&room_id=2", nil},
+		{"a week", "start_date=2072-01-01&end_date=2072-01-08", []int{1, 2}},
+	}
+
+	for _, e := range tests {
+		var resp apiAvailabilityResponse
+		rr := apiRequest(t, "GET", "/api/v1/availability?"+e.query, "", &resp)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", e.name, rr.Code)
+		}
+		var ids []int
+		for _, room := range resp.Rooms {
+			ids = append(ids, room.ID)
+		}
+		if fmt.Sprint(ids) != fmt.Sprint(e.rooms) {
+			t.Errorf("%s: got rooms %v, wanted %v", e.name, ids, e.rooms)
+		}
+	}
+}
+
+func TestAPI_Reservations(t *testing.T) {
+	body := `{"first_name":"Ada","last_name":"Lovelace","email":"ada@example.com","phone":"555",
+		"room_id":2,"start_date":"2071-06-01","end_date":"2071-06-04","children":2}`
+
+	var created apiReservationResponse
+	rr := apiRequest(t, "POST", "/api/v1/reservations", body, &created)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create returned %d: %s", rr.Code, rr.Body.String())
+	}
+	location := fmt.Sprintf("/api/v1/reservations/%d", created.Reservation.ID)
+	if rr.Header().Get("Location") != location || created.Reservation.Room.ID != 2 ||
+		created.Reservation.Adults != 1 || created.Reservation.Children != 2 {
+		t.Errorf("unexpected reservation %v at %q", created.Reservation, rr.Header().Get("Location"))
+	}
+
+	// the same room again
+	var apiErr apiErrorResponse
+	rr = apiRequest(t, "POST", "/api/v1/reservations", body, &apiErr)
+	if rr.Code != http.StatusConflict || apiErr.Error.Status != http.StatusConflict {
+		t.Errorf("double booking returned %d", rr.Code)
+	}
+
+	var read apiReservationResponse
+	rr = apiRequest(t, "GET", location, "", &read)
+	if rr.Code != http.StatusOK || read.Reservation.Email != "ada@example.com" || read.Reservation.StartDate != "2071-06-01" {
+		t.Errorf("read returned %d: %v", rr.Code, read.Reservation)
+	}
+
+	rr = apiRequest(t, "DELETE", location, "", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("cancel returned %d", rr.Code)
+	}
+	// kept for the history, the room is free again
+	rr = apiRequest(t, "GET", location, "", &read)
+	if rr.Code != http.StatusOK || read.Reservation.Status != models.StatusCancelled {
+		t.Errorf("cancelled reservation returned %d with status %q", rr.Code, read.Reservation.Status)
+	}
+	rr = apiRequest(t, "DELETE", location, "", &apiErr)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("cancelling twice returned %d", rr.Code)
+	}
+	rr = apiRequest(t, "POST", "/api/v1/reservations", body, nil)
+	if rr.Code != http.StatusCreated {
+		t.Errorf("booking the cancelled dates returned %d", rr.Code)
+	}
+}
+
+func TestAPI_Blocks(t *testing.T) {
+	body := `{"room_id":1,"start_date":"2072-12-24","end_date":"2072-12-27"}`
+
+	var created apiBlockResponse
+	rr := apiRequest(t, "POST", "/api/v1/blocks", body, &created)
+	if rr.Code != http.StatusCreated || created.Block.Room.ID != 1 || created.Block.EndDate != "2072-12-27" {
+		t.Fatalf("create returned %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr = apiRequest(t, "POST", "/api/v1/blocks", `{"room_id":1,"start_date":"2072-12-26","end_date":"2072-12-28"}`, nil)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("overlapping block returned %d", rr.Code)
+	}
+	rr = apiRequest(t, "POST", "/api/v1/blocks", `{"room_id":99,"start_date":"2072-12-26","end_date":"2072-12-28"}`, nil)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("block of unknown room returned %d", rr.Code)
+	}
+
+	// the block ends on the 27th, so it isn't listed from then on
+	var tests = []struct {
+		query  string
+		blocks int
+	}{
+		{"start_date=2072-12-01&end_date=2072-12-31", 1},
+		{"start_date=2072-12-26&end_date=2072-12-27", 1},
+		{"start_date=2072-12-01&end_date=2072-12-24", 0},
+		{"start_date=2072-12-27&end_date=2072-12-31", 0},
+		{"start_date=2072-12-01&end_date=2072-12-31&room_id=2", 0},
+	}
+	for _, e := range tests {
+		var list apiBlocksResponse
+		rr = apiRequest(t, "GET", "/api/v1/blocks?"+e.query, "", &list)
+		if rr.Code != http.StatusOK || len(list.Blocks) != e.blocks {
+			t.Errorf("%s: got %d with %d blocks, wanted %d", e.query, rr.Code, len(list.Blocks), e.blocks)
+		}
+	}
+
+	rr = apiRequest(t, "DELETE", fmt.Sprintf("/api/v1/blocks/%d", created.Block.ID), "", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("delete returned %d", rr.Code)
+	}
+	var list apiBlocksResponse
+	apiRequest(t, "GET", "/api/v1/blocks?start_date=2072-12-01&end_date=2072-12-31", "", &list)
+	if len(list.Blocks) != 0 {
+		t.Errorf("deleted block is still listed: %v", list.Blocks)
+	}
+
+	// gone now, and a reservation is no block
+	rr = apiRequest(t, "DELETE", fmt.Sprintf("/api/v1/blocks/%d", created.Block.ID), "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("deleting the block again returned %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+	arrival, _ := time.Parse("2006-01-02", "2072-11-01")
+	_, err := Repo.DB.BookReservation(models.Reservation{RoomID: 1, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 2)}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	booked, _ := Repo.DB.GetReservationForRoomByDate(1, arrival, arrival)
+	rr = apiRequest(t, "DELETE", fmt.Sprintf("/api/v1/blocks/%d", booked[0].ID), "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("deleting a reservation returned %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestAPI_CreateReservationInvalid(t *testing.T) {
+	// no arrivals in room 2 on the first of february
+	closed, _ := time.Parse("2006-01-02", "2071-02-01")
+	id, err := Repo.DB.InsertClosedDate(models.ClosedDate{RoomID: 2, FirstDate: closed, LastDate: closed, ClosedToArrival: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// TestRepository_StayRules counts the closed dates
+	defer Repo.DB.DeleteClosedDate(id)
+
+	var tests = []struct {
+		name           string
+		body           string
+		expectedStatus int
+		errorField     string
+	}{
+		{"not json", `first_name=Ada`, http.StatusBadRequest, ""},
+		{"unknown field", `{"name":"Ada"}`, http.StatusBadRequest, ""},
+		{"bad email", `{"first_name":"Ada","last_name":"L","email":"ada","room_id":1,"start_date":"2071-01-01","end_date":"2071-01-02"}`, http.StatusUnprocessableEntity, "email"},
+		{"short name", `{"first_name":"A","last_name":"L","email":"a@example.com","room_id":1,"start_date":"2071-01-01","end_date":"2071-01-02"}`, http.StatusUnprocessableEntity, "first_name"},
+		{"no room", `{"first_name":"Ada","last_name":"L","email":"a@example.com","start_date":"2071-01-01","end_date":"2071-01-02"}`, http.StatusUnprocessableEntity, "room_id"},
+		{"unknown room", `{"first_name":"Ada","last_name":"L","email":"a@example.com","room_id":99,"start_date":"2071-01-01","end_date":"2071-01-02"}`, http.StatusUnprocessableEntity, "room_id"},
+		{"negative children", `{"first_name":"Ada","last_name":"L","email":"a@example.com","room_id":1,"start_date":"2071-01-01","end_date":"2071-01-02","children":-1}`, http.StatusUnprocessableEntity, "children"},
+		{"too many guests", `{"first_name":"Ada","last_name":"L","email":"a@example.com","room_id":1,"start_date":"2071-01-01","end_date":"2071-01-02","adults":2,"children":1}`, http.StatusUnprocessableEntity, "adults"},
+		{"closed to arrival", `{"first_name":"Ada","last_name":"L","email":"a@example.com","room_id":2,"start_date":"2071-02-01","end_date":"2071-02-03"}`, http.StatusUnprocessableEntity, "start_date"},
+	}
+
+	for _, e := range tests {
+		var resp apiErrorResponse
+		rr := apiRequest(t, "POST", "/api/v1/reservations", e.body, &resp)
+		if rr.Code != e.expectedStatus || resp.Error.Status != e.expectedStatus {
+			t.Errorf("%s: got status %d, wanted %d", e.name, rr.Code, e.expectedStatus)
+		}
+		if e.errorField != "" && len(resp.Error.Fields[e.errorField]) == 0 {
+			t.Errorf("%s: no error for %s in %v", e.name, e.errorField, resp.Error.Fields)
+		}
+	}
+}
+
+func TestAPI_NotFound(t *testing.T) {
+	var resp apiErrorResponse
+	rr := apiRequest(t, "GET", "/api/v1/nothing-here", "", &resp)
+	if rr.Code != http.StatusNotFound || resp.Error.Status != http.StatusNotFound {
+		t.Errorf("unknown endpoint returned %d", rr.Code)
+	}
+	rr = apiRequest(t, "PUT", "/api/v1/rooms", "", &resp)
+	if rr.Code != http.StatusMethodNotAllowed || resp.Error.Status != http.StatusMethodNotAllowed {
+		t.Errorf("wrong method returned %d", rr.Code)
+	}
+	rr = apiRequest(t, "GET", "/api/v1/reservations/abc", "", &resp)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("invalid id returned %d", rr.Code)
+	}
+}
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/guestlink"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/render"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/stayrules"
+)
+
+// guestLink returns the link the guest manages res with, it works until a week after the departure
+func (m *Repository) guestLink(res models.Reservation) string {
+	return m.App.BaseURL + guestPath(m.guestToken(res))
+}
+
+func (m *Repository) guestToken(res models.Reservation) string {
+	return guestlink.Sign(m.App.LinkSecret, res.ID, guestlink.Expiry(res.EndDate))
+}
+
+func guestPath(token string) string {
+	return "/my-reservation/" + token
+}
+
+// GuestReservation shows a guest their reservation, opened from the link in the confirmation mail
+func (m *Repository) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	m.renderGuestReservation(w, r, res, forms.New(nil))
+}
+
+func (m *Repository) renderGuestReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["changeable"] = changeable(res)
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = chi.URLParam(r, "token")
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+	// what the guest typed, so a rejected change can be corrected
+	if form.Has("start_date") {
+		stringMap["new_start_date"] = form.Get("start_date")
+		stringMap["new_end_date"] = form.Get("end_date")
+	} else {
+		stringMap["new_start_date"] = stringMap["start_date"]
+		stringMap["new_end_date"] = stringMap["end_date"]
+	}
+
+	render.Template(w, r, "guest-reservation.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      form,
+	})
+}
+
+// GuestChangeDates moves the reservation of the link to other dates of the same room
+func (m *Repository) GuestChangeDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	if !changeable(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be changed any more, please contact us")
+		http.Redirect(w, r, guestPath(chi.URLParam(r, "token")), http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+
+	layout := "2006-01-02"
+	startDate, err1 := time.Parse(layout, form.Get("start_date"))
+	endDate, err2 := time.Parse(layout, form.Get("end_date"))
+	switch {
+	case !form.Valid():
+	case err1 != nil:
+		form.Errors.Add("start_date", "Invalid date")
+	case err2 != nil:
+		form.Errors.Add("end_date", "Invalid date")
+	case startDate.Before(today()):
+		form.Errors.Add("start_date", "Arrival can't be in the past")
+	case !endDate.After(startDate):
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	case startDate.Equal(res.StartDate) && endDate.Equal(res.EndDate):
+		form.Errors.Add("start_date", "These are the dates you already have")
+	default:
+		violations, err := stayrules.New(m.DB).Check(res.RoomID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		stayrules.AddErrors(form, violations, "start_date", "end_date")
+	}
+	if !form.Valid() {
+		m.renderGuestReservation(w, r, res, form)
+		return
+	}
+
+	// the room is ours already for the nights of the current stay, only check the others
+	for _, nights := range addedNights(res.StartDate, res.EndDate, startDate, endDate) {
+		available, err := m.DB.SearchAvailabilityByDatesByRoomID(nights[0], nights[1], res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !available {
+			form.Errors.Add("start_date", "Sorry, the room is not available for these dates")
+			m.renderGuestReservation(w, r, res, form)
+			return
+		}
+	}
+
+	// the new dates are priced with today's rates
+	quote, err := pricing.New(m.DB).Quote(res.RoomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	previous := res
+	res.StartDate = startDate
+	res.EndDate = endDate
+	res.Price = quote.Total
+	err = m.DB.ChangeReservationDates(res, func(res models.Reservation) ([]models.MailData, error) {
+		return m.guestChangeMails(res, previous, quote, false)
+	})
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		// booked by someone else since we checked
+		form.Errors.Add("start_date", "Sorry, the room is not available for these dates")
+		m.renderGuestReservation(w, r, previous, form)
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		// cancelled or checked in since the page was loaded
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be changed any more, please contact us")
+		http.Redirect(w, r, guestPath(chi.URLParam(r, "token")), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// the old link expires with the old dates, go on with a new one
+	m.App.Session.Put(r.Context(), "flash", "Your reservation is changed, we sent you an email with the new dates")
+	http.Redirect(w, r, guestPath(m.guestToken(res)), http.StatusSeeOther)
+}
+
+// GuestCancel cancels the reservation of the link, which frees the room
+func (m *Repository) GuestCancel(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	if !changeable(res) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be cancelled any more, please contact us")
+		http.Redirect(w, r, guestPath(chi.URLParam(r, "token")), http.StatusSeeOther)
+		return
+	}
+
+	err := m.DB.CancelReservation(res, func(res models.Reservation) ([]models.MailData, error) {
+		return m.guestChangeMails(res, res, models.Quote{}, true)
+	})
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be cancelled any more, please contact us")
+		http.Redirect(w, r, guestPath(chi.URLParam(r, "token")), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation is cancelled")
+	http.Redirect(w, r, guestPath(chi.URLParam(r, "token")), http.StatusSeeOther)
+}
+
+// guestReservation checks the token of the link and loads its reservation, if ok is false the response is written
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := guestlink.Verify(m.App.LinkSecret, chi.URLParam(r, "token"), time.Now())
+	if errors.Is(err, guestlink.ErrExpired) {
+		m.App.Session.Put(r.Context(), "error", "This link has expired, please contact us about your reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "This reservation no longer exists")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return models.Reservation{}, false
+	}
+	return res, true
+}
+
+// guestChangeMails tells the guest and the hoster about a change the guest made, quote is the price of the new dates
+func (m *Repository) guestChangeMails(res, previous models.Reservation, quote models.Quote, cancelled bool) ([]models.MailData, error) {
+	var guestMail models.EmailData = models.ReservationChangedEmail{Reservation: res, Link: m.guestLink(res), Quote: quote}
+	subject := "Reservation Changed"
+	if cancelled {
+		guestMail = models.ReservationCancelledEmail{Reservation: res}
+		subject = "Reservation Cancelled"
+	}
+
+	html, text, err := render.Email(guestMail)
+	if err != nil {
+		return nil, err
+	}
+	guestMsg := models.MailData{
+		To:           res.Email,
+		From:         "admin@admin.com",
+		Subject:      subject,
+		Content:      html,
+		PlainContent: text,
+	}
+
+	html, text, err = render.Email(models.GuestChangeNotificationEmail{Reservation: res, Previous: previous, Cancelled: cancelled})
+	if err != nil {
+		return nil, err
+	}
+	hosterMsg := models.MailData{
+		To:           "hoster@email.com",
+		From:         "admin@admin.com",
+		Subject:      subject,
+		Content:      html,
+		PlainContent: text,
+	}
+
+	return []models.MailData{guestMsg, hosterMsg}, nil
+}
+
+// changeable tells if the guest can still change or cancel res themselves, only until the day of arrival
+func changeable(res models.Reservation) bool {
+	return res.Active() && res.StartDate.After(today())
+}
+
+// today is the current date at midnight UTC, like the dates of reservations
+func today() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// addedNights returns the date ranges a stay moved from oldStart-oldEnd to start-end covers
+// that the old stay didn't, at most one before and one after the old stay
+func addedNights(oldStart, oldEnd, start, end time.Time) [][2]time.Time {
+	var ranges [][2]time.Time
+	if start.Before(oldStart) {
+		before := [2]time.Time{start, end}
+		if oldStart.Before(end) {
+			before[1] = oldStart
+		}
+		ranges = append(ranges, before)
+	}
+	if end.After(oldEnd) {
+		after := [2]time.Time{start, end}
+		if oldEnd.After(start) {
+			after[0] = oldEnd
+		}
+		ranges = append(ranges, after)
+	}
+	return ranges
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -16,9 +17,11 @@ import (
 	"github.com/tsawler/bookings-app/internal/forms"
 	"github.com/tsawler/bookings-app/internal/helpers"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 	"github.com/tsawler/bookings-app/internal/render"
 	"github.com/tsawler/bookings-app/internal/repository"
 	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+	"github.com/tsawler/bookings-app/internal/stayrules"
 )
 
 // Repo the repository used by the handlers
@@ -32,12 +35,26 @@ type Repository struct {
 
 // NewRepo creates a new repository
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+	if db.Driver == driver.SQLite {
+		return &Repository{
+			App: a,
+			DB:  dbrepo.NewSQLiteRepo(db.SQL, a),
+		}
+	}
 	return &Repository{
 		App: a,
 		DB: dbrepo.NewPostgresRepo(db.SQL,a),
 	}
 }
 
+// NewTestRepo creates a new repository backed by the in-memory database
+func NewTestRepo(a *config.AppConfig) *Repository {
+	return &Repository{
+		App: a,
+		DB:  dbrepo.NewMemoryRepo(a),
+	}
+}
+
 // NewHandlers sets the repository for the handlers
 func NewHandlers(r *Repository) {
 	Repo = r
@@ -73,8 +90,19 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		helpers.ServerError(w,err)
 		return
 	}
-	//store the roomName into res info and session
+	//what the stay costs, night by night
+	quote, err := pricing.New(m.DB).Quote(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	//store the roomName and price into res info and session
 	res.Room.RoomName = room.RoomName
+	res.Room.Capacity = room.Capacity
+	res.Price = quote.Total
+	if res.Adults == 0 {
+		res.Adults = 1 // booked from a room page, nobody said how many yet
+	}
 	m.App.Session.Put(r.Context(), "reservation",res) // update session info
 
 	//transfer to time.time format and store in the model structure
@@ -87,6 +115,10 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
+	if hold, ok := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction); ok {
+		data["hold"] = hold
+	}
 	// parse the date to frontend
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
@@ -134,6 +166,20 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
 
+	room, ok, err := m.bookableRoom(reservation.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	//taken off the site since the form was shown
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room can't be booked anymore. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	reservation.Room.RoomName = room.RoomName
+	reservation.Room.Capacity = room.Capacity
+
 	// reservation := models.Reservation{
 	// 	FirstName: r.Form.Get("first_name"),
 	// 	LastName:  r.Form.Get("last_name"),
@@ -150,101 +196,219 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.Required("first_name", "last_name", "email")
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
+	reservation.Adults, reservation.Children = guestCounts(form, room.Capacity)
+
+	//the rules may have changed since the dates were chosen
+	violations, err := stayrules.New(m.DB).Check(reservation.RoomID, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	stayrules.AddErrors(form, violations, "start_date", "end_date")
+
+	//quote again, the rates may have changed since the form was shown
+	quote, err := pricing.New(m.DB).Quote(reservation.RoomID, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	reservation.Price = quote.Total
+
+	//the room held for the guest, if it's still there it becomes theirs
+	hold, held := m.App.Session.Get(r.Context(), "hold").(models.RoomRestriction)
 	
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		data["quote"] = quote
+		if held {
+			data["hold"] = hold
+		}
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = reservation.StartDate.Format("2006-01-02")
+		stringMap["end_date"] = reservation.EndDate.Format("2006-01-02")
 		render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 			Form: form,
 			Data: data,
+			StringMap: stringMap,
 		})
 		return
 	}
-	//insert into db
-	newReservationID, err := m.DB.InsertReservation(reservation)
-
+	//insert the reservation, block the room and queue the confirmation mail in one transaction
+	newReservationID, err := m.DB.BookReservation(reservation, hold.ID, func(res models.Reservation) ([]models.MailData, error) {
+		return m.reservationMails(res, quote)
+	})
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room was just booked by someone else for those dates. Please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
+		m.App.Session.Put(r.Context(), "error","can't make reservation!")
 		helpers.ServerError(w,err)
 		return
 	}
+	reservation.ID = newReservationID
 
-	//put the update reservation info into session
-	m.App.Session.Put(r.Context(),"reservation",reservation)
+	m.App.Session.Remove(r.Context(), "hold")
+	m.App.Session.Put(r.Context(), "reservation", reservation)
 
-	restriction := models.RoomRestriction{
-		StartDate:    reservation.StartDate,
-		EndDate:      reservation.EndDate,
-		RoomID:       reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
-	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// reservationMails builds the confirmation mail for the guest and the hoster, they go into the outbox with the reservation.
+// quote is worked out beforehand, the mails are built while the database is busy booking
+func (m *Repository) reservationMails(reservation models.Reservation, quote models.Quote) ([]models.MailData, error) {
+	//send notification to guest, with the link to manage the reservation and the price
+	html, text, err := render.Email(models.ReservationConfirmationEmail{Reservation: reservation, Link: m.guestLink(reservation), Quote: quote})
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error","can't insert room restriction!")
-		helpers.ServerError(w,err)
-		return
+		return nil, err
 	}
 
-	//send notification to guest
-	//self difined content
-		htmlMessage := fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong> <br>
-		Dear %s: <br>
-		This is to confirm your reservation from %s to %s.
-	`, reservation.FirstName, reservation.StartDate.Format("2006-01-02"),reservation.EndDate.Format("2006-01-02"))
-
-	msg := models.MailData{
+	guestMsg := models.MailData{
 		To: reservation.Email,
 		From: "admin@admin.com",
 		Subject:"Reservation Confirmation",
-		Content: htmlMessage,
-		Template: "basic.html",
+		Content: html,
+		PlainContent: text,
 	}
-	m.App.MailChan <- msg
 
 	//send email to hoster
-	htmlMessage = fmt.Sprintf(`
-		<strong>Reservation Confirmation</strong> <br>
-		Your got a reservation for %s from %s to %s.
-	`, reservation.Room.RoomName, reservation.StartDate.Format("2006-01-02"),reservation.EndDate.Format("2006-01-02"))
+	html, text, err = render.Email(models.ReservationNotificationEmail{Reservation: reservation})
+	if err != nil {
+		return nil, err
+	}
 
-	msg = models.MailData{
+	hosterMsg := models.MailData{
 		To: "hoster@email.com",
 		From: "admin@admin.com",
-		Subject:"Reservation Confirmation",
-		Content: htmlMessage,
-		Template: "basic.html",
+		Subject:"Reservation Notification",
+		Content: html,
+		PlainContent: text,
 	}
-	m.App.MailChan <- msg
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
+	return []models.MailData{guestMsg, hosterMsg}, nil
+}
 
+// guestCounts reads the adults and children fields of form, left empty they are 1 and 0.
+// A count that isn't a number, no adults or more guests than capacity are form errors, a capacity of 0 only
+// checks that no room could be too small, see maxCapacity
+func guestCounts(form *forms.Form, capacity int) (adults, children int) {
+	adults, children = 1, 0
+	var err error
+	if form.Has("adults") {
+		adults, err = strconv.Atoi(strings.TrimSpace(form.Get("adults")))
+		if err != nil || adults < 1 {
+			form.Errors.Add("adults", "At least one adult has to stay")
+			return
+		}
+	}
+	if form.Has("children") {
+		children, err = strconv.Atoi(strings.TrimSpace(form.Get("children")))
+		if err != nil || children < 0 {
+			form.Errors.Add("children", "Enter the number of children, 0 if none")
+			return
+		}
+	}
+	switch {
+	case capacity > 0 && adults+children > capacity:
+		form.Errors.Add("adults", tooManyGuests(capacity))
+	case adults+children > maxCapacity:
+		form.Errors.Add("adults", fmt.Sprintf("No room sleeps more than %d guests", maxCapacity))
+	}
+	return
+}
 
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+func tooManyGuests(capacity int) string {
+	return fmt.Sprintf("The room sleeps %d guests at most", capacity)
+}
+
+// largestCapacity is how many guests the largest room on the site sleeps
+func (m *Repository) largestCapacity() (int, error) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		return 0, err
+	}
+	largest := 0
+	for _, room := range rooms {
+		if room.Capacity > largest {
+			largest = room.Capacity
+		}
+	}
+	return largest, nil
 }
 
 
-// Generals renders the room page
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// Rooms lists the rooms that can be booked
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	covers, err := m.DB.CoverPhotos()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["covers"] = covers
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-// Majors renders the room page
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// Room renders the page of the room with the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) || room.Archived() {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	photos, err := m.DB.RoomPhotos(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = photos
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
 // Availability renders the search availability page
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{})
+	render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
 }
 
 // PostAvailability handles post
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
+	form := forms.New(r.PostForm)
+	adults, children := guestCounts(form, 0)
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", form.Errors.Get("adults")+form.Errors.Get("children"))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout,start)
 	if err != nil {
@@ -257,26 +421,80 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate) 
+	// searching again lets go of the room held for the last search, or the guest couldn't find it again
+	err = m.releaseHold(r)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// rooms too small for everyone are left out
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults+children)
 
 	if err != nil {
 		helpers.ServerError(w,err)
 		return
 	}
+	// and so are rooms whose stay rules don't allow these dates
+	stays := stayrules.New(m.DB)
+	var allowed []models.Room
+	var violations []stayrules.Violation
+	for _, room := range rooms {
+		v, err := stays.Check(room.ID, startDate, endDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if len(v) == 0 {
+			allowed = append(allowed, room)
+			continue
+		}
+		for _, rule := range v {
+			rule.Message = fmt.Sprintf("%s: %s", room.RoomName, rule.Message)
+			violations = append(violations, rule)
+		}
+	}
+	rooms = allowed
+	//free rooms that can't be booked for these dates, tell why
+	if len(rooms) == 0 && len(violations) > 0 {
+		stayrules.AddErrors(form, violations, "start", "end")
+		render.Template(w, r, "search-availability.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
 	//no room, show error and redirect to the search page
 	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(),"error","No availability")
+		msg := "No availability"
+		//no dates would help a party too big for every room
+		largest, err := m.largestCapacity()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if adults+children > largest {
+			msg = fmt.Sprintf("Our largest room sleeps %d guests", largest)
+		}
+		m.App.Session.Put(r.Context(),"error",msg)
 		http.Redirect(w,r,"/search-availability", http.StatusSeeOther)
 		return
 	}
+	covers, err := m.DB.CoverPhotos()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	//log.Print("rooms", len(rooms))
 	//parse the date to front-end
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["covers"] = covers
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate: endDate,
+		Adults: adults,
+		Children: children,
 	}
 	//store the start/end date in the session, will be used for the next step: make reservation
 	m.App.Session.Put(r.Context(),"reservation", res)
@@ -301,33 +519,71 @@ type jsonResponse struct {
 // AvailabilityJSON handles request for availability and sends JSON response
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	
+	err := r.ParseForm()
+	if err != nil {
+		writeJSONResponse(w, http.StatusBadRequest, jsonResponse{OK: false, Message: "can't parse form"})
+		return
+	}
+
 	//Get the info from frontend and parse the correct format
 	sd := r.Form.Get("start")
 	ed := r.Form.Get("end")
 	layout := "2006-01-02"
-	startDate,_:= time.Parse(layout,sd)
-	endDate,_:= time.Parse(layout,ed)
-	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+	startDate, err1 := time.Parse(layout,sd)
+	endDate, err2 := time.Parse(layout,ed)
+	roomID, err3 := strconv.Atoi(r.Form.Get("room_id"))
+	if err1 != nil || err2 != nil || err3 != nil || !endDate.After(startDate) {
+		writeJSONResponse(w, http.StatusBadRequest, jsonResponse{
+			OK: false,
+			Message: "invalid dates or room",
+			StartDate: sd,
+			EndDate: ed,
+			RoomID: r.Form.Get("room_id"),
+		})
+		return
+	}
 
 	//call db function
-	available, _ := m.DB.SearchAvailabilityByDatesByRoomID(startDate,endDate,roomID)
-	log.Println("available:", available)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate,endDate,roomID)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		writeJSONResponse(w, http.StatusInternalServerError, jsonResponse{OK: false, Message: "error querying database"})
+		return
+	}
+	//a free room may still not be bookable for these dates
+	message := ""
+	if available {
+		violations, err := stayrules.New(m.DB).Check(roomID, startDate, endDate)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			writeJSONResponse(w, http.StatusInternalServerError, jsonResponse{OK: false, Message: "error querying database"})
+			return
+		}
+		available = len(violations) == 0
+		message = stayrules.Messages(violations)
+	}
 	//parse the search result to resp
 	resp := jsonResponse{
 		OK:      available,
-		Message: "",
+		Message: message,
 		StartDate: sd,
 		EndDate: ed,
 		RoomID: strconv.Itoa(roomID),
 	}
 
-	out, err := json.MarshalIndent(resp, "", "     ")
+	writeJSONResponse(w, http.StatusOK, resp)
+}
+
+// writeJSONResponse sends v as indented json
+func writeJSONResponse(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "     ")
 	if err != nil {
 		helpers.ServerError(w,err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(out)
 }
 
@@ -349,6 +605,15 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	data := make(map[string]interface{})
 
 	data["reservation"] = reservation
+	// the session forgets the reservation now, the link is how the guest gets back to it
+	data["link"] = m.guestLink(reservation)
+
+	quote, err := pricing.New(m.DB).Quote(reservation.RoomID, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	data["quote"] = quote
 
 	sd := reservation.StartDate.Format("2006-01-02")
 	ed := reservation.EndDate.Format("2006-01-02")
@@ -382,7 +647,28 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, ok, err = m.bookableRoom(roomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
 	res.RoomID = roomID
+	//keep the room for the guest while they fill in the form
+	err = m.holdRoom(r, res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, someone else is booking this room for those dates. Please choose another one or try again in a few minutes")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	//update the reservation in the session 
 	m.App.Session.Put(r.Context(), "reservation",res)
 	//redirect to the make reservation 
@@ -391,10 +677,15 @@ func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 
 //take url parameters, build a session, and redirect to make reservation page
 func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	//get info from url
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	start := r.URL.Query().Get("s")
-	end := r.URL.Query().Get("e")
+	//get info from the book now form
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	roomID, _ := strconv.Atoi(r.Form.Get("id"))
+	start := r.Form.Get("s")
+	end := r.Form.Get("e")
 
 	//put info into reservation session
 	var res models.Reservation
@@ -404,6 +695,7 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	startDate, err := time.Parse(layout,start)
 	if err != nil {
 		helpers.ServerError(w,err)
+		return
 	}
 
 	endDate, err := time.Parse(layout,end)
@@ -415,18 +707,85 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	res.StartDate = startDate
 	res.EndDate = endDate
 
-	//get the room name from db
-	room, err := m.DB.GetRoomByID(roomID)
+	//get the room name from db, archived rooms can't be booked
+	room, ok, err := m.bookableRoom(roomID)
 	if err != nil {
 		helpers.ServerError(w,err)
 		return
 	}
+	if !ok {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	//the link may be made up or old, check the stay rules again
+	violations, err := stayrules.New(m.DB).Check(roomID, startDate, endDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if len(violations) > 0 {
+		m.App.Session.Put(r.Context(), "error", stayrules.Messages(violations))
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
+		return
+	}
+	err = m.holdRoom(r, res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, the room is not available for those dates anymore")
+		http.Redirect(w, r, "/rooms/"+room.Slug, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	//store the roomName into res info and session
 	res.Room.RoomName = room.RoomName
 	m.App.Session.Put(r.Context(), "reservation",res) // update session info
 	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
+// bookableRoom loads a room guests can book, ok is false if there is no such room or it is archived
+func (m *Repository) bookableRoom(id int) (room models.Room, ok bool, err error) {
+	room, err = m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return room, false, nil
+	}
+	if err != nil {
+		return room, false, err
+	}
+	return room, !room.Archived(), nil
+}
+
+// holdRoom holds the room and dates of res for the guest for HoldTime, so nobody else can book them
+// while the reservation form is filled in. the hold goes into the session, replacing the one held before
+func (m *Repository) holdRoom(r *http.Request, res models.Reservation) error {
+	err := m.releaseHold(r)
+	if err != nil {
+		return err
+	}
+	hold := models.RoomRestriction{
+		StartDate: res.StartDate,
+		EndDate:   res.EndDate,
+		RoomID:    res.RoomID,
+		ExpiresAt: time.Now().Add(m.App.HoldTime),
+	}
+	hold.ID, err = m.DB.InsertHold(hold)
+	if err != nil {
+		return err
+	}
+	m.App.Session.Put(r.Context(), "hold", hold)
+	return nil
+}
+
+// releaseHold lets go of the room held for the guest, if any, before it expires
+func (m *Repository) releaseHold(r *http.Request) error {
+	hold, ok := m.App.Session.Pop(r.Context(), "hold").(models.RoomRestriction)
+	if !ok {
+		return nil
+	}
+	return m.DB.DeleteHold(hold.ID)
+}
+
 func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
 	render.Template(w,r, "log.page.tmpl", &models.TemplateData{
 		Form: forms.New(nil),
@@ -495,19 +854,40 @@ func (m *Repository) AdminNewReservation(w http.ResponseWriter, r *http.Request)
 }
 
 func (m *Repository) AdminAllReservation(w http.ResponseWriter, r *http.Request){
-	reservations, err := m.DB.AllReservation()
+	// ?status=cancelled shows only the cancelled ones, no status shows all
+	status := r.URL.Query().Get("status")
+	if status != "" && !validStatus(status) {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	reservations, err := m.DB.AllReservation(status)
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 	data := make(map[string]interface{}) // not sure the value structure, use interface
 	data["reservations"] = reservations
+	data["statuses"] = models.Statuses
+
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
 
 	render.Template(w,r, "admin-all-reservation.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
 		Data: data,
 	})
 }
 
+func validStatus(status string) bool {
+	for _, s := range models.Statuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Repository) AdminReservationCalender(w http.ResponseWriter, r *http.Request){
 	// calculate the calendar month shall be showed
 	// set up default value
@@ -548,7 +928,7 @@ func (m *Repository) AdminReservationCalender(w http.ResponseWriter, r *http.Req
 	currentYear, currentMonth, _ := now.Date()
 	currentLocation := now.Location()
 	firstOfMonth := time.Date(currentYear, currentMonth,1,0,0,0,0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0,0,-1)
+	lastOfMonth := firstOfMonth.AddDate(0,1,-1)
 
 	intMap := make(map[string]int)
 	intMap["days_in_month"] = lastOfMonth.Day()
@@ -563,16 +943,18 @@ func (m *Repository) AdminReservationCalender(w http.ResponseWriter, r *http.Req
 	for _, x := range rooms {
 		reservationMap := make(map[string]int) // reservationed
 		blockMap := make(map[string]int) // blocked , not availabe, but also not reservationed
+		externalMap := make(map[string]int) // booked on another site, comes from a calendar import
+		holdMap := make(map[string]string) // held for a guest filling in the reservation form, until when
 
 		// zero meaning room availabe at that day
 		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0,0,1) {
 			reservationMap[d.Format("2006-01-2")] = 0
 			blockMap[d.Format("2006-01-2")] = 0
+			externalMap[d.Format("2006-01-2")] = 0
 		}
 
 		//get all restriction for current room in this month
 		restriction, err := m.DB.GetReservationForRoomByDate(x.ID, firstOfMonth, lastOfMonth)
-		print("from db:", restriction)
 
 		if err != nil {
 			helpers.ServerError(w, err)
@@ -580,21 +962,31 @@ func (m *Repository) AdminReservationCalender(w http.ResponseWriter, r *http.Req
 		}
 
 		for _, y := range restriction {
-			if y.ReservationID > 0 {
-				// get the all days for this reservation
-				for d := y.StartDate; d.After(y.EndDate) == false; d = d.AddDate(0,0,1) {
+			// the guest leaves on the end date, so that day is free again
+			for d := y.StartDate; d.Before(y.EndDate); d = d.AddDate(0,0,1) {
+				switch {
+				case y.ReservationID > 0:
 					reservationMap[d.Format("2006-01-2")] = y.ReservationID
+				case y.RestrictionID == models.RestrictionExternal:
+					// removed by the import only
+					externalMap[d.Format("2006-01-2")] = y.ID
+				case y.RestrictionID == models.RestrictionHold:
+					// kept out of the block map, saving the calendar must not remove it
+					holdMap[d.Format("2006-01-2")] = y.ExpiresAt.Format("15:04")
+				case d.Before(firstOfMonth) || d.After(lastOfMonth):
+					// no checkbox for it on this month, saving must not take it for unticked
+				default:
+					// the id of the room restriction, needed to remove the block
+					blockMap[d.Format("2006-01-2")] = y.ID
 				}
-			}else{
-				blockMap[y.StartDate.Format("2006-01-2")] = y.RestrictionID
 			}
 		}
-		log.Println("block: ",blockMap)
-		log.Println("res map: ", reservationMap)
 		data[fmt.Sprintf("reservation_map_%d", x.ID)] = reservationMap
-		data[fmt.Sprintf("reservation_block_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("block_map_%d", x.ID)] = blockMap
+		data[fmt.Sprintf("external_map_%d", x.ID)] = externalMap
+		data[fmt.Sprintf("hold_map_%d", x.ID)] = holdMap
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", x.ID),blockMap)
+		m.App.Session.Put(r.Context(), blockMapSessionKey(x.ID, currentYear, currentMonth), blockMap)
 	}
 	render.Template(w,r, "admin-reservation-calender.page.tmpl", &models.TemplateData{
 		StringMap: stringMap,
@@ -603,6 +995,92 @@ func (m *Repository) AdminReservationCalender(w http.ResponseWriter, r *http.Req
 	})
 }
 
+// blockMapSessionKey is where the calendar keeps the blocks of a room it showed for a month, calendars
+// open on different months don't overwrite each other
+func blockMapSessionKey(roomID, year int, month time.Month) string {
+	return fmt.Sprintf("block_map_%d_%d-%02d", roomID, year, month)
+}
+
+// AdminPostReservationCalender saves the owner blocks ticked on the calendar
+func (m *Repository) AdminPostReservationCalender(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	// the blocks shown on the calendar are in the session, the days no longer ticked are freed.
+	// a block can cover more than one day, the days still ticked stay blocked
+	for _, x := range rooms {
+		blockMap, _ := m.App.Session.Get(r.Context(), blockMapSessionKey(x.ID, year, time.Month(month))).(map[string]int)
+		unticked := make(map[int][]time.Time)
+		for day, id := range blockMap {
+			if id > 0 && !form.Has(fmt.Sprintf("remove_block_%d_%s", x.ID, day)) {
+				d, err := time.Parse("2006-01-2", day)
+				if err != nil {
+					continue
+				}
+				unticked[id] = append(unticked[id], d)
+			}
+		}
+		for id, days := range unticked {
+			err := m.DB.DeleteBlockDays(id, days)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+		}
+	}
+
+	// ticked free days become new blocks, named add_block_<room id>_<date>
+	skipped := 0
+	for name := range r.PostForm {
+		if !strings.HasPrefix(name, "add_block_") {
+			continue
+		}
+		exploded := strings.Split(name, "_")
+		if len(exploded) != 4 {
+			continue
+		}
+		roomID, err := strconv.Atoi(exploded[2])
+		if err != nil {
+			continue
+		}
+		day, err := time.Parse("2006-01-2", exploded[3])
+		if err != nil {
+			continue
+		}
+
+		err = m.DB.InsertBlockForRoom(roomID, day)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			// booked since the calendar was shown
+			skipped++
+			continue
+		}
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	if skipped > 0 {
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%d day(s) could not be blocked, the room is already taken", skipped))
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservation-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
 func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
 	//get the url and read the id 
 	exploded := strings.Split(r.RequestURI, "/") //=> split url
@@ -620,8 +1098,15 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 		helpers.ServerError(w, err)
 		return
 	}
+	//the breakdown at today's rates, the guest pays res.Price which was quoted when booking
+	quote, err := pricing.New(m.DB).Quote(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["quote"] = quote
 	render.Template(w,r, "admin-reservation-show.page.tmpl", &models.TemplateData{
 		StringMap:  stringMap,
 		Data: data,
@@ -630,6 +1115,13 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 }
 //update reservation by admin
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	// nosurf doesn't parse the form of requests with an api key
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
 	//get the url and read the id 
 	exploded := strings.Split(r.RequestURI, "/") //=> split url
 	id, err := strconv.Atoi(exploded[4]) //string to int
@@ -651,6 +1143,25 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	res.Email = r.Form.Get("email")
 	res.Phone = r.Form.Get("phone")
 
+	// counts left out stay as they are, the capacity is only checked if they change
+	form := forms.New(r.Form)
+	if !form.Has("adults") {
+		form.Set("adults", strconv.Itoa(res.Adults))
+	}
+	if !form.Has("children") {
+		form.Set("children", strconv.Itoa(res.Children))
+	}
+	adults, children := guestCounts(form, 0)
+	if form.Valid() && (adults != res.Adults || children != res.Children) && adults+children > res.Room.Capacity {
+		form.Errors.Add("adults", tooManyGuests(res.Room.Capacity))
+	}
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", form.Errors.Get("adults")+form.Errors.Get("children"))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservation/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+	res.Adults, res.Children = adults, children
+
 	err = m.DB.UpdateReservation(res)
 
 	if err != nil {
@@ -658,21 +1169,53 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 		return
 	}
 	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	http.Redirect(w,r, fmt.Sprintf("/admin/reservation-%s",src), http.StatusSeeOther)
+	http.Redirect(w,r, adminReservationsURL(src), http.StatusSeeOther)
 }
 
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
+// AdminReservationStatus moves a reservation along its lifecycle, e.g. confirms or cancels it
+func (m *Repository) AdminReservationStatus(w http.ResponseWriter, r *http.Request) {
+	// nosurf doesn't parse the form of requests with an api key
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ClientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
 	src := chi.URLParam(r, "src")
-	m.DB.UpdateProcessedForReservation(id, 1) // change processed to 1
-	m.App.Session.Put(r.Context(), "flash", "reservation marked as processed")
-	http.Redirect(w,r, fmt.Sprintf("/admin/reservation-%s",src), http.StatusSeeOther)
+	status := r.Form.Get("status")
+
+	err = m.DB.UpdateReservationStatus(id, status)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, repository.ErrInvalidTransition) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Can't change the reservation: %s", err))
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservation/%s/%d", src, id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if status == models.StatusCancelled {
+		m.App.Session.Put(r.Context(), "flash", "Reservation cancelled, the room is free again for its dates")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation marked as %s", status))
+	}
+	http.Redirect(w, r, adminReservationsURL(src), http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-	m.DB.DeleteReservation(id) // change processed to 1
-	m.App.Session.Put(r.Context(), "flash", "reservation deleted")
-	http.Redirect(w,r, fmt.Sprintf("/admin/reservation-%s",src), http.StatusSeeOther)
-}
\ No newline at end of file
+// adminReservationsURL is the page a reservation was opened from
+func adminReservationsURL(src string) string {
+	if src == "cal" {
+		return "/admin/reservation-calendar"
+	}
+	return fmt.Sprintf("/admin/reservation-%s", src)
+}
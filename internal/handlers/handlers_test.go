@@ -0,0 +1,1542 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/apikeys"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
+	"github.com/tsawler/bookings-app/internal/storage"
+)
+
+var theTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+}{
+	{"home", "/", http.StatusOK},
+	{"about", "/about", http.StatusOK},
+	{"rooms", "/rooms", http.StatusOK},
+	{"gq", "/rooms/generals-quarters", http.StatusOK},
+	{"ms", "/rooms/majors-suite", http.StatusOK},
+	{"unknown room", "/rooms/penthouse", http.StatusNotFound},
+	{"sa", "/search-availability", http.StatusOK},
+	{"contact", "/contact", http.StatusOK},
+	{"login", "/user/login", http.StatusOK},
+	{"new reservations", "/admin/reservation-new", http.StatusOK},
+	{"cancelled reservations", "/admin/reservation-all?status=cancelled", http.StatusOK},
+	{"unknown status", "/admin/reservation-all?status=paid", http.StatusBadRequest},
+	{"rates", "/admin/rates", http.StatusOK},
+	{"stay rules", "/admin/stay-rules", http.StatusOK},
+	{"admin rooms", "/admin/rooms", http.StatusOK},
+	{"new room", "/admin/rooms/new", http.StatusOK},
+	{"edit room", "/admin/rooms/1", http.StatusOK},
+	{"edit unknown room", "/admin/rooms/999", http.StatusNotFound},
+	{"room photos", "/admin/rooms/1/photos", http.StatusOK},
+	{"photos of unknown room", "/admin/rooms/999/photos", http.StatusNotFound},
+}
+
+func TestHandlers(t *testing.T) {
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	for _, e := range theTests {
+		resp, err := ts.Client().Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != e.expectedStatusCode {
+			t.Errorf("for %s, expected %d but got %d", e.name, e.expectedStatusCode, resp.StatusCode)
+		}
+	}
+}
+
+func TestRepository_Reservation(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "Quarters",
+		},
+	}
+
+	req, _ := http.NewRequest("GET", "/make-reservation", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	session.Put(ctx, "reservation", reservation)
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// no reservation in the session
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+
+	// room does not exist
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	reservation.RoomID = 100
+	session.Put(ctx, "reservation", reservation)
+
+	http.HandlerFunc(Repo.Reservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestRepository_PostReservation(t *testing.T) {
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, "2050-01-01")
+	endDate, _ := time.Parse(layout, "2050-01-03")
+
+	postReservation := func(reservation models.Reservation, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+		return rr
+	}
+
+	reservation := models.Reservation{
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    1,
+	}
+	form := url.Values{}
+	form.Add("first_name", "John")
+	form.Add("last_name", "<b>Smith</b>")
+	form.Add("email", "john@smith.com")
+	form.Add("phone", "555-555-5555")
+
+	// other tests may have queued mail already
+	before, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+
+	rr := postReservation(reservation, form)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+		t.Errorf("PostReservation handler returned %d to %q, wanted %d to /reservation-summary", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, 1)
+	if available {
+		t.Error("room still shows available after the reservation was made")
+	}
+
+	// confirmation for the guest and the hoster are queued in the outbox
+	queued, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+	queued = queued[len(before):]
+	if len(queued) != 2 {
+		t.Fatalf("expected 2 messages in the outbox, got %d", len(queued))
+	}
+	for _, msg := range queued {
+		if !strings.Contains(msg.Mail.Content, "2050-01-03") || !strings.Contains(msg.Mail.PlainContent, "2050-01-03") {
+			t.Errorf("message to %s is missing the departure date", msg.Mail.To)
+		}
+	}
+	// guest input is escaped in the html the hoster gets
+	if host := queued[1].Mail; strings.Contains(host.Content, "<b>Smith</b>") || !strings.Contains(host.Content, "&lt;b&gt;Smith&lt;/b&gt;") {
+		t.Error("guest name was not escaped in the html mail")
+	}
+
+	// the same dates again, the room is taken now
+	rr = postReservation(reservation, form)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("PostReservation handler returned %d to %q for a booked room, wanted %d to /search-availability", rr.Code, rr.Header().Get("Location"), http.StatusSeeOther)
+	}
+
+	// invalid form re-renders the page
+	form.Set("first_name", "J")
+	reservation.RoomID = 2
+	rr = postReservation(reservation, form)
+	if rr.Code != http.StatusOK {
+		t.Errorf("PostReservation handler returned wrong response code for invalid data: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+
+	// the quarters sleep 2
+	form.Set("first_name", "John")
+	reservation.RoomID = 1
+	reservation.StartDate = startDate.AddDate(0, 1, 0)
+	reservation.EndDate = endDate.AddDate(0, 1, 0)
+	var guests = []struct {
+		adults   string
+		children string
+		code     int
+	}{
+		{"2", "1", http.StatusOK},
+		{"0", "2", http.StatusOK},
+		{"two", "", http.StatusOK},
+		{"1", "-1", http.StatusOK},
+		{"1", "1", http.StatusSeeOther},
+	}
+	for _, e := range guests {
+		form.Set("adults", e.adults)
+		form.Set("children", e.children)
+		rr = postReservation(reservation, form)
+		if rr.Code != e.code {
+			t.Errorf("%s adults and %s children: got %d, wanted %d", e.adults, e.children, rr.Code, e.code)
+		}
+	}
+	booked, _ := Repo.DB.GetReservationForRoomByDate(1, reservation.StartDate, reservation.EndDate)
+	if len(booked) != 1 {
+		t.Fatalf("expected one reservation, got %d", len(booked))
+	}
+	res, _ := Repo.DB.GetReservationByID(booked[0].ReservationID)
+	if res.Adults != 1 || res.Children != 1 || res.Guests() != 2 {
+		t.Errorf("reservation saved with %d adults and %d children", res.Adults, res.Children)
+	}
+}
+
+func TestRepository_PostAvailability(t *testing.T) {
+	var ctx context.Context
+	post := func(adults, children string) *httptest.ResponseRecorder {
+		form := url.Values{"start": {"2061-01-01"}, "end": {"2061-01-03"}, "adults": {adults}, "children": {children}}
+		req, _ := http.NewRequest("POST", "/search-availability", strings.NewReader(form.Encode()))
+		ctx = getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostAvailability).ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the quarters sleep 2, the suite 4
+	rr := post("2", "1")
+	if rr.Code != http.StatusOK || strings.Contains(rr.Body.String(), "Quarters") || !strings.Contains(rr.Body.String(), "Master") {
+		t.Errorf("expected only the suite for 3 guests, got %d", rr.Code)
+	}
+	rr = post("3", "2")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("expected no availability for 5 guests, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if msg := session.GetString(ctx, "error"); msg != "Our largest room sleeps 4 guests" {
+		t.Errorf("expected to hear how many the largest room sleeps, got %q", msg)
+	}
+	rr = post("60", "")
+	if msg := session.GetString(ctx, "error"); rr.Code != http.StatusSeeOther || msg != "No room sleeps more than 50 guests" {
+		t.Errorf("expected to hear the most guests any room takes, got %d with %q", rr.Code, msg)
+	}
+	rr = post("0", "")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/search-availability" {
+		t.Errorf("expected to search again without adults, got %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+}
+
+func TestGuestCounts(t *testing.T) {
+	var tests = []struct {
+		adults   string
+		children string
+		capacity int
+		expected string
+	}{
+		{"2", "1", 4, ""},
+		{"0", "1", 4, "At least one adult has to stay"},
+		{"two", "", 4, "At least one adult has to stay"},
+		{"1", "-1", 4, "Enter the number of children, 0 if none"},
+		{"4", "1", 4, "The room sleeps 4 guests at most"},
+		{"51", "", 0, "No room sleeps more than 50 guests"},
+		{"40", "20", 0, "No room sleeps more than 50 guests"},
+		{"50", "", 0, ""},
+	}
+
+	for _, e := range tests {
+		form := forms.New(url.Values{"adults": {e.adults}, "children": {e.children}})
+		guestCounts(form, e.capacity)
+		msg := form.Errors.Get("adults") + form.Errors.Get("children")
+		if msg != e.expected {
+			t.Errorf("%s adults and %s children in a room for %d: got %q, wanted %q", e.adults, e.children, e.capacity, msg, e.expected)
+		}
+	}
+}
+
+func TestRepository_AvailabilityJSON(t *testing.T) {
+	form := url.Values{}
+	form.Add("start", "2060-01-01")
+	form.Add("end", "2060-01-02")
+	form.Add("room_id", "1")
+
+	req, _ := http.NewRequest("POST", "/search-availability-json", strings.NewReader(form.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+
+	var j jsonResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &j); err != nil {
+		t.Fatal("failed to parse json")
+	}
+	if !j.OK {
+		t.Error("expected the room to be available")
+	}
+
+	// bad dates are a client error
+	form.Set("start", "invalid")
+	req, _ = http.NewRequest("POST", "/search-availability-json", strings.NewReader(form.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AvailabilityJSON).ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("AvailabilityJSON returned %d for an invalid date, wanted %d", rr.Code, http.StatusBadRequest)
+	}
+}
+
+func TestRepository_ChooseRoom(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/choose-room/1", nil)
+	ctx := getCtx(req)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "1")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	session.Put(ctx, "reservation", models.Reservation{})
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ChooseRoom).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("ChooseRoom handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusSeeOther)
+	}
+
+	res, _ := session.Get(ctx, "reservation").(models.Reservation)
+	if res.RoomID != 1 {
+		t.Errorf("ChooseRoom stored room %d in the session, wanted 1", res.RoomID)
+	}
+}
+
+func TestRepository_Hold(t *testing.T) {
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, "2079-01-10")
+	endDate, _ := time.Parse(layout, "2079-01-12")
+
+	// a guest with their own session, searching for the dates
+	guest := func(startDate, endDate time.Time) context.Context {
+		req, _ := http.NewRequest("GET", "/search-availability", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "reservation", models.Reservation{StartDate: startDate, EndDate: endDate, Adults: 1})
+		return ctx
+	}
+	chooseRoom := func(ctx context.Context, roomID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/choose-room/"+roomID, nil)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", roomID)
+		req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.ChooseRoom).ServeHTTP(rr, req)
+		return rr
+	}
+
+	first, second := guest(startDate, endDate), guest(startDate, endDate)
+	rr := chooseRoom(first, "1")
+	if rr.Header().Get("Location") != "/make-reservation" {
+		t.Fatalf("expected the first guest to get the room, redirected to %q", rr.Header().Get("Location"))
+	}
+	hold, ok := session.Get(first, "hold").(models.RoomRestriction)
+	if !ok || !hold.ExpiresAt.After(time.Now().Add(14*time.Minute)) {
+		t.Errorf("expected a hold for 15 minutes in the session, got %+v", hold)
+	}
+
+	// someone else can't take the room while it is held
+	rr = chooseRoom(second, "1")
+	if rr.Header().Get("Location") != "/search-availability" || session.GetString(second, "error") == "" {
+		t.Errorf("expected the second guest to be sent back to the search, redirected to %q", rr.Header().Get("Location"))
+	}
+
+	// the held room shows on the calendar, it isn't a block
+	req, _ := http.NewRequest("GET", "/admin/reservation-calendar?y=2079&m=1", nil)
+	req = req.WithContext(getCtx(req))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReservationCalender).ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), "Held for a guest until") {
+		t.Error("expected the hold on the calendar")
+	}
+
+	// the first guest books it, the hold becomes the reservation
+	form := url.Values{}
+	form.Add("first_name", "Holden")
+	form.Add("last_name", "Caulfield")
+	form.Add("email", "holden@caulfield.com")
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+	req = req.WithContext(first)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Header().Get("Location") != "/reservation-summary" {
+		t.Fatalf("PostReservation redirected to %q, wanted /reservation-summary", rr.Header().Get("Location"))
+	}
+	if session.Exists(first, "hold") {
+		t.Error("expected the hold to be gone from the session")
+	}
+	restrictions, _ := Repo.DB.GetReservationForRoomByDate(1, startDate, endDate)
+	if len(restrictions) != 1 || restrictions[0].ID != hold.ID || restrictions[0].RestrictionID != models.RestrictionReservation {
+		t.Errorf("expected the hold to be the restriction of the reservation, got %+v", restrictions)
+	}
+
+	// choosing another room lets go of the one held before
+	startDate, endDate = startDate.AddDate(0, 1, 0), endDate.AddDate(0, 1, 0)
+	third := guest(startDate, endDate)
+	chooseRoom(third, "1")
+	chooseRoom(third, "2")
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, 1); !available {
+		t.Error("expected room 1 to be free again")
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, 2); available {
+		t.Error("expected room 2 to be held")
+	}
+}
+
+func TestRepository_ArchivedRoom(t *testing.T) {
+	roomID, err := Repo.DB.InsertRoom(models.Room{RoomName: "Attic", Slug: "attic", Capacity: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Repo.DB.SetRoomArchived(roomID, true); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.Itoa(roomID)
+	layout := "2006-01-02"
+	startDate, _ := time.Parse(layout, "2082-01-10")
+	endDate, _ := time.Parse(layout, "2082-01-12")
+	stay := models.Reservation{StartDate: startDate, EndDate: endDate, RoomID: roomID, Adults: 1}
+
+	// chosen from old search results
+	req, _ := http.NewRequest("POST", "/choose-room/"+id, nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", stay)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", id)
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.ChooseRoom).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("ChooseRoom returned %d for an archived room, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// an old link to book it
+	form := url.Values{"id": {id}, "s": {"2082-01-10"}, "e": {"2082-01-12"}}
+	req, _ = http.NewRequest("POST", "/book-room", strings.NewReader(form.Encode()))
+	req = req.WithContext(getCtx(req))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.BookRoom).ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("BookRoom returned %d for an archived room, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// archived while the form was filled in
+	form = url.Values{"first_name": {"Bertha"}, "last_name": {"Mason"}, "email": {"bertha@mason.com"}}
+	req, _ = http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+	ctx = getCtx(req)
+	session.Put(ctx, "reservation", stay)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req)
+	if rr.Header().Get("Location") != "/search-availability" || session.GetString(ctx, "error") == "" {
+		t.Errorf("PostReservation redirected to %q for an archived room, wanted /search-availability", rr.Header().Get("Location"))
+	}
+
+	// or through the api
+	var resp apiErrorResponse
+	rr = apiRequest(t, "POST", "/api/v1/reservations",
+		`{"first_name":"Bertha","last_name":"Mason","email":"bertha@mason.com","room_id":`+id+`,"start_date":"2082-01-10","end_date":"2082-01-12"}`, &resp)
+	if rr.Code != http.StatusUnprocessableEntity || len(resp.Error.Fields["room_id"]) == 0 {
+		t.Errorf("the api returned %d for an archived room: %s", rr.Code, rr.Body.String())
+	}
+	rr = apiRequest(t, "GET", "/api/v1/availability?start_date=2082-01-10&end_date=2082-01-12&room_id="+id, "", &resp)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("the api availability returned %d for an archived room: %s", rr.Code, rr.Body.String())
+	}
+
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID); !available {
+		t.Error("the archived room was held or booked")
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	var tests = []struct {
+		name             string
+		email            string
+		password         string
+		expectedLocation string
+	}{
+		{"valid", "admin@admin.com", "password", "/"},
+		{"wrong password", "admin@admin.com", "wrong", "/user/login"},
+		{"unknown user", "nobody@admin.com", "password", "/user/login"},
+	}
+
+	for _, e := range tests {
+		form := url.Values{}
+		form.Add("email", e.email)
+		form.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(form.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.PostShowLogin).ServeHTTP(rr, req)
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRepository_AdminPostReservationCalender(t *testing.T) {
+	layout := "2006-01-02"
+	blocked, _ := time.Parse(layout, "2060-03-10")
+	free, _ := time.Parse(layout, "2060-03-20")
+
+	err := Repo.DB.InsertBlockForRoom(1, blocked)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// showing the calendar puts the block maps into the session
+	req, _ := http.NewRequest("GET", "/admin/reservation-calendar?y=2060&m=3", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminReservationCalender).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("AdminReservationCalender handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	if !strings.Contains(rr.Body.String(), "remove_block_1_2060-03-10") {
+		t.Error("existing block is not shown on the calendar")
+	}
+
+	// untick the block of room 1, tick a day for room 2
+	form := url.Values{}
+	form.Add("y", "2060")
+	form.Add("m", "03")
+	form.Add("add_block_2_2060-03-20", "1")
+	req, _ = http.NewRequest("POST", "/admin/reservation-calendar", strings.NewReader(form.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostReservationCalender).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservation-calendar?y=2060&m=3" {
+		t.Errorf("AdminPostReservationCalender handler returned %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(blocked, blocked.AddDate(0, 0, 1), 1)
+	if !available {
+		t.Error("unticked block was not removed")
+	}
+	available, _ = Repo.DB.SearchAvailabilityByDatesByRoomID(free, free.AddDate(0, 0, 1), 2)
+	if available {
+		t.Error("ticked day was not blocked")
+	}
+
+	// a block of four nights, shown on april's calendar while may is open in another tab
+	arrival, _ := time.Parse(layout, "2060-04-10")
+	_, err = Repo.DB.InsertBlocks([]models.RoomRestriction{{RoomID: 1, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 4)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, month := range []string{"4", "5"} {
+		req, _ = http.NewRequest("GET", "/admin/reservation-calendar?y=2060&m="+month, nil)
+		req = req.WithContext(ctx)
+		http.HandlerFunc(Repo.AdminReservationCalender).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// unticking one day frees that day only
+	form = url.Values{}
+	form.Add("y", "2060")
+	form.Add("m", "04")
+	for _, day := range []string{"10", "11", "13"} {
+		form.Add("remove_block_1_2060-04-"+day, "1")
+	}
+	req, _ = http.NewRequest("POST", "/admin/reservation-calendar", strings.NewReader(form.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	http.HandlerFunc(Repo.AdminPostReservationCalender).ServeHTTP(httptest.NewRecorder(), req)
+
+	for d := 0; d < 4; d++ {
+		night := arrival.AddDate(0, 0, d)
+		available, _ = Repo.DB.SearchAvailabilityByDatesByRoomID(night, night.AddDate(0, 0, 1), 1)
+		if available != (d == 2) {
+			t.Errorf("night of %s: available %t, wanted %t", night.Format(layout), available, d == 2)
+		}
+	}
+
+	// a block over the end of june, saved unchanged from july's calendar
+	arrival, _ = time.Parse(layout, "2060-06-28")
+	_, err = Repo.DB.InsertBlocks([]models.RoomRestriction{{RoomID: 1, StartDate: arrival, EndDate: arrival.AddDate(0, 0, 5)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ = http.NewRequest("GET", "/admin/reservation-calendar?y=2060&m=7", nil)
+	req = req.WithContext(ctx)
+	http.HandlerFunc(Repo.AdminReservationCalender).ServeHTTP(httptest.NewRecorder(), req)
+
+	form = url.Values{}
+	form.Add("y", "2060")
+	form.Add("m", "07")
+	form.Add("remove_block_1_2060-07-1", "1")
+	form.Add("remove_block_1_2060-07-2", "1")
+	req, _ = http.NewRequest("POST", "/admin/reservation-calendar", strings.NewReader(form.Encode()))
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	http.HandlerFunc(Repo.AdminPostReservationCalender).ServeHTTP(httptest.NewRecorder(), req)
+
+	// june's nights aren't on july's calendar, they stay blocked
+	for d := 0; d < 5; d++ {
+		night := arrival.AddDate(0, 0, d)
+		available, _ = Repo.DB.SearchAvailabilityByDatesByRoomID(night, night.AddDate(0, 0, 1), 1)
+		if available {
+			t.Errorf("night of %s was freed by saving july", night.Format(layout))
+		}
+	}
+}
+
+func TestRepository_AdminPostBlocks(t *testing.T) {
+	layout := "2006-01-02"
+	arrival, _ := time.Parse(layout, "2061-05-04")
+	departure, _ := time.Parse(layout, "2061-05-06")
+
+	_, err := Repo.DB.BookReservation(models.Reservation{
+		FirstName: "Jane",
+		LastName:  "Guest",
+		StartDate: arrival,
+		EndDate:   departure,
+		RoomID:    1,
+	}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postBlocks := func(form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/blocks", strings.NewReader(form.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostBlocks).ServeHTTP(rr, req)
+		return rr
+	}
+
+	form := url.Values{}
+	form.Add("room", "1")
+	form.Add("room", "2")
+	form.Add("start_date", "2061-05-01")
+	form.Add("end_date", "2061-05-10")
+	form.Add("pattern", "range")
+	form.Add("action", "preview")
+
+	// the preview names the guest in the way and saves nothing
+	rr := postBlocks(form)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Jane Guest") {
+		t.Errorf("preview returned %d and does not show the conflicting reservation", rr.Code)
+	}
+	available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(arrival.AddDate(0, 0, -2), arrival, 1)
+	if !available {
+		t.Error("preview saved blocks")
+	}
+
+	form.Set("action", "save")
+	rr = postBlocks(form)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/admin/reservation-calendar?y=2061&m=5" {
+		t.Errorf("save returned %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	for _, roomID := range []int{1, 2} {
+		restrictions, _ := Repo.DB.GetReservationForRoomByDate(roomID, arrival.AddDate(0, 0, -3), departure.AddDate(0, 0, 4))
+		blocked := 0
+		for _, rr := range restrictions {
+			if rr.RestrictionID == models.RestrictionOwnerBlock {
+				blocked++
+			}
+		}
+		// room 1 gets a block before and after the reservation, room 2 one for the whole range
+		want := map[int]int{1: 2, 2: 1}[roomID]
+		if blocked != want {
+			t.Errorf("room %d has %d blocks, wanted %d", roomID, blocked, want)
+		}
+	}
+
+	// invalid rule re-renders the form
+	form.Set("pattern", "weekly")
+	rr = postBlocks(form)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "choose at least one weekday") {
+		t.Errorf("invalid rule returned %d", rr.Code)
+	}
+}
+
+func TestRepository_AdminAPIKeys(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/api-keys", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 1)
+
+	postKey := func(form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(form.Encode()))
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostAPIKey).ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := postKey(url.Values{"name": {"no scopes"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Choose at least one scope") {
+		t.Errorf("key without scopes returned %d", rr.Code)
+	}
+	rr = postKey(url.Values{"name": {"bad scope"}, "scope": {"everything"}})
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Unknown scope") {
+		t.Errorf("key with an unknown scope returned %d", rr.Code)
+	}
+
+	rr = postKey(url.Values{"name": {"channel manager"}, "scope": {models.ScopeReadBlocks, models.ScopeWriteBlocks}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminPostAPIKey returned %d", rr.Code)
+	}
+	key := session.GetString(ctx, "new_api_key")
+	prefix, ok := apikeys.Prefix(key)
+	if !ok {
+		t.Fatalf("no new key in the session, got %q", key)
+	}
+	k, err := Repo.DB.GetAPIKeyByPrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Name != "channel manager" || k.UserID != 1 || !k.HasScope(models.ScopeWriteBlocks) || k.HasScope(models.ScopeReadReservations) {
+		t.Errorf("unexpected key %+v", k)
+	}
+	if k.Hash == key || !apikeys.Check(key, k.Hash) {
+		t.Error("the key is not stored as its hash")
+	}
+
+	// the key is shown once
+	for i, shown := range []bool{true, false} {
+		req, _ = http.NewRequest("GET", "/admin/api-keys", nil)
+		req = req.WithContext(ctx)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminAPIKeys).ServeHTTP(rr, req)
+		if strings.Contains(rr.Body.String(), key) != shown {
+			t.Errorf("visit %d: key shown is %v, wanted %v", i+1, !shown, shown)
+		}
+	}
+
+	req, _ = http.NewRequest("POST", fmt.Sprintf("/admin/api-keys/%d/revoke", k.ID), nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", strconv.Itoa(k.ID))
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminRevokeAPIKey).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("AdminRevokeAPIKey returned %d", rr.Code)
+	}
+	k, _ = Repo.DB.GetAPIKeyByPrefix(prefix)
+	if !k.Revoked() {
+		t.Error("key was not revoked")
+	}
+}
+
+func TestRepository_RoomCalendar(t *testing.T) {
+	layout := "2006-01-02"
+	arrival := time.Now().AddDate(0, 2, 0)
+	arrival, _ = time.Parse(layout, arrival.Format(layout))
+	departure := arrival.AddDate(0, 0, 3)
+
+	reservationID, err := Repo.DB.BookReservation(models.Reservation{
+		FirstName: "Grace",
+		LastName:  "Hopper",
+		StartDate: arrival,
+		EndDate:   departure,
+		RoomID:    2,
+	}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Repo.DB.InsertBlockForRoom(2, departure)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// booked on another site and imported from its feed
+	_, err = Repo.DB.InsertExternalBlock(models.RoomRestriction{
+		StartDate:    departure.AddDate(0, 0, 5),
+		EndDate:      departure.AddDate(0, 0, 7),
+		RoomID:       2,
+		ICalSourceID: 99,
+		ICalUID:      "elsewhere@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the admin creates the feed url
+	req, _ := http.NewRequest("POST", "/admin/calendar-feeds/2", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("id", "2")
+	req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostCalendarFeed).ServeHTTP(rr, req)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminPostCalendarFeed returned %d", rr.Code)
+	}
+	room, _ := Repo.DB.GetRoomByID(2)
+	if len(room.ICalToken) != 32 {
+		t.Fatalf("room got token %q", room.ICalToken)
+	}
+
+	mux := chi.NewRouter()
+	mux.Get("/calendar/{token}.ics", Repo.RoomCalendar)
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr = get("/calendar/" + room.ICalToken + ".ics")
+	if rr.Code != http.StatusOK || !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/calendar") {
+		t.Fatalf("feed returned %d with %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	feed := rr.Body.String()
+	for _, want := range []string{
+		"SUMMARY:Reserved: Grace Hopper\r\n",
+		"DTSTART;VALUE=DATE:" + arrival.Format("20060102") + "\r\nDTEND;VALUE=DATE:" + departure.Format("20060102") + "\r\n",
+		fmt.Sprintf("/admin/reservation/cal/%d\r\n", reservationID),
+		"SUMMARY:Blocked by owner\r\nCATEGORIES:Owner Block\r\n",
+		"DTSTART;VALUE=DATE:" + departure.Format("20060102") + "\r\nDTEND;VALUE=DATE:" + departure.AddDate(0, 0, 1).Format("20060102") + "\r\n",
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed is missing %q:\n%s", want, feed)
+		}
+	}
+	if strings.Contains(feed, departure.AddDate(0, 0, 5).Format("20060102")) {
+		t.Errorf("the block imported from another site is in the feed:\n%s", feed)
+	}
+
+	if rr = get("/calendar/wrong.ics"); rr.Code != http.StatusNotFound {
+		t.Errorf("wrong token returned %d", rr.Code)
+	}
+
+	// a new url replaces the old one
+	old := room.ICalToken
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminPostCalendarFeed).ServeHTTP(rr, req)
+	if rr = get("/calendar/" + old + ".ics"); rr.Code != http.StatusNotFound {
+		t.Errorf("old token returned %d", rr.Code)
+	}
+}
+
+func TestRepository_AdminICalSources(t *testing.T) {
+	feed := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\nUID:elsewhere-1\r\nDTSTART;VALUE=DATE:20620701\r\nDTEND;VALUE=DATE:20620705\r\nEND:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	ctx := getCtx(httptest.NewRequest("GET", "/", nil))
+	postSource := func(fields map[string]string, file string) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		if file != "" {
+			fw, _ := mw.CreateFormFile("calendar", "room.ics")
+			fw.Write([]byte(file))
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/ical-sources", &body)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminPostICalSource).ServeHTTP(rr, req)
+		return rr
+	}
+
+	var tests = []struct {
+		name    string
+		fields  map[string]string
+		file    string
+		message string
+	}{
+		{"no calendar", map[string]string{"room": "1", "name": "Other"}, "", "Give the url of the calendar"},
+		{"bad url", map[string]string{"room": "1", "name": "Other", "url": "ftp://example.com/x.ics"}, "", "Invalid url"},
+		{"not a calendar", map[string]string{"room": "1", "name": "Other"}, "<html></html>", "Can&#39;t read the file"},
+		{"unknown room", map[string]string{"room": "99", "name": "Other", "url": server.URL}, "", "Unknown room"},
+	}
+	for _, e := range tests {
+		rr := postSource(e.fields, e.file)
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), e.message) {
+			t.Errorf("%s: got %d without %q", e.name, rr.Code, e.message)
+		}
+	}
+
+	// from a url, imported right away
+	rr := postSource(map[string]string{"room": "1", "name": "Other site", "url": server.URL + "/room.ics"}, "")
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("AdminPostICalSource returned %d", rr.Code)
+	}
+	if flash := session.PopString(ctx, "flash"); !strings.Contains(flash, "1 added") {
+		t.Errorf("unexpected flash %q", flash)
+	}
+	arrival, _ := time.Parse("2006-01-02", "2062-07-02")
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(arrival, arrival.AddDate(0, 0, 1), 1); available {
+		t.Error("room 1 is still available while booked on the other site")
+	}
+
+	// the same calendar uploaded for room 2
+	rr = postSource(map[string]string{"room": "2", "name": "Upload"}, feed)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("upload returned %d", rr.Code)
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(arrival, arrival.AddDate(0, 0, 1), 2); available {
+		t.Error("room 2 is still available after the upload")
+	}
+
+	// the list shows both, removing one frees its room
+	sources, _ := Repo.DB.AllICalSources()
+	req, _ := http.NewRequest("GET", "/admin/ical-sources", nil)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminICalSources).ServeHTTP(rr, req.WithContext(ctx))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Other site") || !strings.Contains(rr.Body.String(), "uploaded file") {
+		t.Errorf("AdminICalSources returned %d", rr.Code)
+	}
+
+	for _, src := range sources {
+		if src.RoomID != 2 {
+			continue
+		}
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(src.ID))
+		req, _ = http.NewRequest("POST", "/admin/ical-sources/x/delete", nil)
+		rr = httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminDeleteICalSource).ServeHTTP(rr, req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx)))
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(arrival, arrival.AddDate(0, 0, 1), 2); !available {
+		t.Error("room 2 is still blocked after removing its calendar")
+	}
+}
+
+func TestRepository_GuestReservation(t *testing.T) {
+	layout := "2006-01-02"
+	date := func(s string) time.Time {
+		d, _ := time.Parse(layout, s)
+		return d
+	}
+
+	// other tests may have queued mail already
+	before, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+
+	reservation := models.Reservation{
+		FirstName: "Ada",
+		LastName:  "Lovelace",
+		Email:     "ada@example.com",
+		StartDate: date("2073-03-10"),
+		EndDate:   date("2073-03-13"),
+		RoomID:    1,
+	}
+	var err error
+	reservation.ID, err = Repo.DB.BookReservation(reservation, 0, func(res models.Reservation) ([]models.MailData, error) {
+		return Repo.reservationMails(res, models.Quote{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// someone else has the nights after
+	_, err = Repo.DB.BookReservation(models.Reservation{StartDate: date("2073-03-15"), EndDate: date("2073-03-17"), RoomID: 1}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the confirmation carries the link
+	queued, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+	link := Repo.guestLink(reservation)
+	if guest := queued[len(before)].Mail; !strings.Contains(guest.Content, link) || !strings.Contains(guest.PlainContent, link) {
+		t.Fatalf("confirmation mail is missing the link %s", link)
+	}
+	token := strings.TrimPrefix(link, app.BaseURL+"/my-reservation/")
+
+	mux := chi.NewRouter()
+	mux.Use(SessionLoad)
+	mux.Get("/my-reservation/{token}", Repo.GuestReservation)
+	mux.Post("/my-reservation/{token}/dates", Repo.GuestChangeDates)
+	mux.Post("/my-reservation/{token}/cancel", Repo.GuestCancel)
+	do := func(method, url string, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		return rr
+	}
+	dates := func(start, end string) url.Values {
+		return url.Values{"start_date": {start}, "end_date": {end}}
+	}
+
+	rr := do("GET", "/my-reservation/"+token, nil)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "2073-03-13") {
+		t.Errorf("guest page returned %d", rr.Code)
+	}
+	if rr = do("GET", "/my-reservation/"+token+"x", nil); rr.Code != http.StatusNotFound {
+		t.Errorf("tampered link returned %d, wanted %d", rr.Code, http.StatusNotFound)
+	}
+
+	// into the other reservation, or invalid dates: the page again with the error
+	for _, form := range []url.Values{
+		dates("2073-03-12", "2073-03-16"),
+		dates("2073-03-12", "2073-03-12"),
+		dates("2073-03-12", "soon"),
+		dates("2020-03-12", "2020-03-14"),
+	} {
+		if rr = do("POST", "/my-reservation/"+token+"/dates", form); rr.Code != http.StatusOK {
+			t.Errorf("changing to %v returned %d, wanted %d", form, rr.Code, http.StatusOK)
+		}
+	}
+
+	// a night later, overlapping the current stay
+	rr = do("POST", "/my-reservation/"+token+"/dates", dates("2073-03-11", "2073-03-14"))
+	moved := reservation
+	moved.StartDate, moved.EndDate = date("2073-03-11"), date("2073-03-14")
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/my-reservation/"+Repo.guestToken(moved) {
+		t.Fatalf("changing dates returned %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	res, _ := Repo.DB.GetReservationByID(reservation.ID)
+	if !res.StartDate.Equal(moved.StartDate) || !res.EndDate.Equal(moved.EndDate) {
+		t.Errorf("reservation is from %s to %s after the change", res.StartDate.Format(layout), res.EndDate.Format(layout))
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(date("2073-03-10"), date("2073-03-11"), 1); !available {
+		t.Error("the night given up is still blocked")
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(date("2073-03-13"), date("2073-03-14"), 1); available {
+		t.Error("the night added is not blocked")
+	}
+
+	// cancelling frees the room, the link then shows the cancelled reservation
+	rr = do("POST", "/my-reservation/"+token+"/cancel", nil)
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/my-reservation/"+token {
+		t.Errorf("cancelling returned %d to %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(moved.StartDate, moved.EndDate, 1); !available {
+		t.Error("room is still blocked after the cancellation")
+	}
+	if rr = do("GET", "/my-reservation/"+token, nil); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "This reservation is cancelled") {
+		t.Errorf("link of a cancelled reservation returned %d", rr.Code)
+	}
+	rr = do("POST", "/my-reservation/"+token+"/dates", dates("2073-03-20", "2073-03-22"))
+	if res, _ := Repo.DB.GetReservationByID(reservation.ID); rr.Code != http.StatusSeeOther || !res.EndDate.Equal(moved.EndDate) {
+		t.Errorf("changing a cancelled reservation returned %d", rr.Code)
+	}
+
+	// guest and hoster heard about the change and the cancellation
+	queued, _ = Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+	queued = queued[len(before)+2:]
+	if len(queued) != 4 || queued[0].Mail.Subject != "Reservation Changed" || queued[2].Mail.Subject != "Reservation Cancelled" {
+		t.Fatalf("expected 4 mails about the change and the cancellation, got %d", len(queued))
+	}
+	if !strings.Contains(queued[1].Mail.PlainContent, "moved their reservation") {
+		t.Errorf("hoster mail doesn't tell the change:\n%s", queued[1].Mail.PlainContent)
+	}
+}
+
+func TestRepository_AdminReservationStatus(t *testing.T) {
+	layout := "2006-01-02"
+	arrival, _ := time.Parse(layout, "2074-05-01")
+	id, err := Repo.DB.BookReservation(models.Reservation{
+		FirstName: "Alan",
+		LastName:  "Turing",
+		StartDate: arrival,
+		EndDate:   arrival.AddDate(0, 0, 2),
+		RoomID:    2,
+	}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the page offers the next statuses
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/reservation/all/%d", id), nil)
+	req.RequestURI = req.URL.Path
+	req = req.WithContext(getCtx(req))
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.AdminShowReservation).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Mark as confirmed") || !strings.Contains(rr.Body.String(), "Cancel Reservation") {
+		t.Errorf("reservation page returned %d without the status buttons", rr.Code)
+	}
+
+	setStatus := func(id int, status string) *httptest.ResponseRecorder {
+		form := url.Values{"status": {status}}
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/admin/reservation/all/%d/status", id), strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("src", "all")
+		rctx.URLParams.Add("id", strconv.Itoa(id))
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.AdminReservationStatus).ServeHTTP(rr, req)
+		return rr
+	}
+
+	var tests = []struct {
+		status   string
+		location string // where the admin ends up, the list or back on the reservation
+		expected string // the status of the reservation afterwards
+	}{
+		{models.StatusCheckedIn, fmt.Sprintf("/admin/reservation/all/%d", id), models.StatusPending},
+		{models.StatusConfirmed, "/admin/reservation-all", models.StatusConfirmed},
+		{"paid", fmt.Sprintf("/admin/reservation/all/%d", id), models.StatusConfirmed},
+		{models.StatusCancelled, "/admin/reservation-all", models.StatusCancelled},
+		{models.StatusConfirmed, fmt.Sprintf("/admin/reservation/all/%d", id), models.StatusCancelled},
+	}
+	for _, e := range tests {
+		rr := setStatus(id, e.status)
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != e.location {
+			t.Errorf("%s: returned %d to %q, wanted %s", e.status, rr.Code, rr.Header().Get("Location"), e.location)
+		}
+		res, _ := Repo.DB.GetReservationByID(id)
+		if res.Status != e.expected {
+			t.Errorf("%s: reservation is %s, wanted %s", e.status, res.Status, e.expected)
+		}
+	}
+
+	// the row is kept, the room isn't
+	if available, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(arrival, arrival.AddDate(0, 0, 2), 2); !available {
+		t.Error("room is still blocked after the cancellation")
+	}
+	cancelled, _ := Repo.DB.AllReservation(models.StatusCancelled)
+	found := false
+	for _, res := range cancelled {
+		found = found || res.ID == id
+		if res.Status != models.StatusCancelled {
+			t.Errorf("filter on cancelled returned a %s reservation", res.Status)
+		}
+	}
+	if !found {
+		t.Error("cancelled reservation is missing from the cancelled list")
+	}
+
+	if rr := setStatus(99999, models.StatusConfirmed); rr.Code != http.StatusNotFound {
+		t.Errorf("unknown reservation returned %d", rr.Code)
+	}
+}
+
+func TestRepository_AdminRates(t *testing.T) {
+	layout := "2006-01-02"
+	date := func(s string) time.Time {
+		d, _ := time.Parse(layout, s)
+		return d
+	}
+	room, _ := Repo.DB.GetRoomByID(2)
+	defer Repo.DB.UpdateRoomRates(room.ID, room.NightlyRate, room.WeekendRate)
+
+	post := func(handler http.HandlerFunc, target string, id string, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// the room costs the same every night
+	rr := post(Repo.AdminPostRoomRates, "/admin/rates/2", "2", url.Values{"nightly_rate": {"150"}, "weekend_rate": {""}})
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("saving the rates returned %d", rr.Code)
+	}
+	rr = post(Repo.AdminPostRoomRates, "/admin/rates/2", "2", url.Values{"nightly_rate": {"lots"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("invalid rates returned %d", rr.Code)
+	}
+	if room, _ := Repo.DB.GetRoomByID(2); room.NightlyRate != 15000 || room.WeekendRate != 0 {
+		t.Errorf("expected rates of 15000 and 0, got %d and %d", room.NightlyRate, room.WeekendRate)
+	}
+
+	// a season ending before it starts is rejected
+	season := url.Values{
+		"room":         {"2"},
+		"name":         {"Peak"},
+		"first_night":  {"2075-07-02"},
+		"last_night":   {"2075-07-01"},
+		"nightly_rate": {"200.50"},
+	}
+	rr = post(Repo.AdminPostSeasonalRate, "/admin/rates/seasons", "", season)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The last night can&#39;t be before the first") {
+		t.Errorf("invalid season returned %d", rr.Code)
+	}
+	season.Set("first_night", "2075-07-01")
+	season.Set("last_night", "2075-07-02")
+	rr = post(Repo.AdminPostSeasonalRate, "/admin/rates/seasons", "", season)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("adding the season returned %d", rr.Code)
+	}
+
+	// two of three nights are in the season
+	form := url.Values{"first_name": {"Grace"}, "last_name": {"Hopper"}, "email": {"grace@example.com"}}
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{StartDate: date("2075-06-30"), EndDate: date("2075-07-03"), RoomID: 2})
+	before, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req.WithContext(ctx))
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("PostReservation returned %d", rr.Code)
+	}
+
+	booked, ok := session.Get(ctx, "reservation").(models.Reservation)
+	res, err := Repo.DB.GetReservationByID(booked.ID)
+	if !ok || err != nil || res.Price != 55100 {
+		t.Errorf("expected a price of 55100, got %d, %v", res.Price, err)
+	}
+	queued, _ := Repo.DB.PendingMail(time.Now().Add(time.Minute), 100)
+	if guest := queued[len(before)].Mail; !strings.Contains(guest.Content, "$551.00") || !strings.Contains(guest.PlainContent, "Peak") {
+		t.Error("confirmation mail is missing the price")
+	}
+
+	// removing the season brings back the room's rate
+	seasons, _ := Repo.DB.AllSeasonalRates()
+	for _, s := range seasons {
+		post(Repo.AdminDeleteSeasonalRate, "/admin/rates/seasons/x/delete", strconv.Itoa(s.ID), nil)
+	}
+	quote, _ := pricing.New(Repo.DB).Quote(2, date("2075-06-30"), date("2075-07-03"))
+	if quote.Total != 45000 {
+		t.Errorf("expected 45000 without the season, got %d", quote.Total)
+	}
+}
+
+func TestRepository_AdminRooms(t *testing.T) {
+	post := func(handler http.HandlerFunc, id string, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+id, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		if id != "new" {
+			rctx.URLParams.Add("id", id)
+		}
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	roomIDs := func() []int {
+		rooms, _ := Repo.DB.AllRooms()
+		var ids []int
+		for _, room := range rooms {
+			ids = append(ids, room.ID)
+		}
+		return ids
+	}
+
+	// the slug is made from the name
+	form := url.Values{
+		"name":      {"Colonel's Cabin"},
+		"capacity":  {"3"},
+		"amenities": {"Fireplace\r\n\r\n Garden view "},
+	}
+	rr := post(Repo.AdminPostRoom, "new", form)
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("creating the room returned %d", rr.Code)
+	}
+	room, err := Repo.DB.GetRoomBySlug("colonels-cabin")
+	if err != nil || room.Capacity != 3 || strings.Join(room.Amenities, "|") != "Fireplace|Garden view" {
+		t.Fatalf("room was not saved as posted: %+v, %v", room, err)
+	}
+	if ids := roomIDs(); ids[len(ids)-1] != room.ID {
+		t.Errorf("new room is not last: %v", ids)
+	}
+	defer Repo.DB.SetRoomArchived(room.ID, true)
+
+	var invalid = []struct {
+		name  string
+		field string
+		value string
+	}{
+		{"taken slug", "slug", "majors-suite"},
+		{"bad slug", "slug", "Colonel's Cabin"},
+		{"no guests", "capacity", "0"},
+		{"no name", "name", ""},
+	}
+	for _, e := range invalid {
+		form := url.Values{"name": {"Cabin"}, "slug": {"cabin"}, "capacity": {"2"}}
+		form.Set(e.field, e.value)
+		rr := post(Repo.AdminPostRoom, strconv.Itoa(room.ID), form)
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: returned %d, expected the form again", e.name, rr.Code)
+		}
+	}
+	if saved, _ := Repo.DB.GetRoomByID(room.ID); saved.Slug != "colonels-cabin" {
+		t.Errorf("an invalid form changed the room to %+v", saved)
+	}
+
+	// moving it up puts it before the room that was before it
+	before := roomIDs()
+	post(Repo.AdminMoveRoom, strconv.Itoa(room.ID), url.Values{"direction": {"up"}})
+	after := roomIDs()
+	if n := len(after); after[n-2] != room.ID || after[n-1] != before[n-2] {
+		t.Errorf("expected the room one place up, %v became %v", before, after)
+	}
+
+	// archived, it is gone from the site but can be restored
+	post(Repo.AdminArchiveRoom, strconv.Itoa(room.ID), nil)
+	req, _ := http.NewRequest("GET", "/rooms/colonels-cabin", nil)
+	rr = httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("archived room page returned %d", rr.Code)
+	}
+	layout := "2006-01-02"
+	start, _ := time.Parse(layout, "2076-01-01")
+	available, _ := Repo.DB.SearchAvailabilityForAllRooms(start, start.AddDate(0, 0, 1), 1)
+	for _, r := range available {
+		if r.ID == room.ID {
+			t.Error("archived room is still available")
+		}
+	}
+	post(Repo.AdminRestoreRoom, strconv.Itoa(room.ID), nil)
+	if restored, _ := Repo.DB.GetRoomByID(room.ID); restored.Archived() {
+		t.Error("room is still archived after restoring it")
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	var tests = map[string]string{
+		"Major's Suite":        "majors-suite",
+		"  The  Blue -- Room ": "the-blue-room",
+		"Room 101":             "room-101",
+	}
+	for name, expected := range tests {
+		if got := slugify(name); got != expected {
+			t.Errorf("%q: expected %q, got %q", name, expected, got)
+		}
+	}
+}
+
+func TestRepository_AdminRoomPhotos(t *testing.T) {
+	request := func(method, target, id, photo string, body io.Reader, contentType string) *http.Request {
+		req, _ := http.NewRequest(method, target, body)
+		req.Header.Set("Content-Type", contentType)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		if photo != "" {
+			rctx.URLParams.Add("photo", photo)
+		}
+		return req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+	}
+	upload := func(files map[string][]byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, data := range files {
+			fw, _ := mw.CreateFormFile("photos", name)
+			fw.Write(data)
+		}
+		mw.Close()
+		rr := httptest.NewRecorder()
+		Repo.AdminPostRoomPhotos(rr, request("POST", "/admin/rooms/2/photos", "2", "", &body, mw.FormDataContentType()))
+		return rr
+	}
+	photoPNG := func() []byte {
+		var buf bytes.Buffer
+		png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1600, 1200)))
+		return buf.Bytes()
+	}
+
+	// the text file is turned down, the photo is kept
+	rr := upload(map[string][]byte{"room.png": photoPNG(), "notes.txt": []byte("not a photo")})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("upload returned %d", rr.Code)
+	}
+	upload(map[string][]byte{"second.png": photoPNG()})
+	roomPhotos, _ := Repo.DB.RoomPhotos(2)
+	if len(roomPhotos) != 2 {
+		t.Fatalf("expected 2 photos, got %d", len(roomPhotos))
+	}
+	first, second := roomPhotos[0], roomPhotos[1]
+	for _, name := range []string{first.Original, first.Medium, first.Thumbnail} {
+		if _, err := os.Stat(filepath.Join(app.Storage.(*storage.Local).Dir, name)); err != nil {
+			t.Errorf("%s was not stored: %v", name, err)
+		}
+	}
+
+	// the second photo moved up is the cover
+	rr = httptest.NewRecorder()
+	form := url.Values{"direction": {"up"}}
+	Repo.AdminMoveRoomPhoto(rr, request("POST", "/admin/rooms/2/photos/x/move", "2", strconv.Itoa(second.ID),
+		strings.NewReader(form.Encode()), "application/x-www-form-urlencoded"))
+	covers, _ := Repo.DB.CoverPhotos()
+	if covers[2].ID != second.ID {
+		t.Errorf("expected photo %d to be the cover, got %d", second.ID, covers[2].ID)
+	}
+
+	req, _ := http.NewRequest("GET", "/rooms/majors-suite", nil)
+	rr = httptest.NewRecorder()
+	getRoutes().ServeHTTP(rr, req)
+	if !strings.Contains(rr.Body.String(), app.Storage.URL(first.Medium)) {
+		t.Error("room page doesn't show the photo")
+	}
+
+	// photos can't be deleted through another room
+	rr = httptest.NewRecorder()
+	Repo.AdminDeleteRoomPhoto(rr, request("POST", "/admin/rooms/1/photos/x/delete", "1", strconv.Itoa(first.ID), nil, ""))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("deleting a photo of another room returned %d", rr.Code)
+	}
+
+	for _, p := range roomPhotos {
+		rr = httptest.NewRecorder()
+		Repo.AdminDeleteRoomPhoto(rr, request("POST", "/admin/rooms/2/photos/x/delete", "2", strconv.Itoa(p.ID), nil, ""))
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("deleting photo %d returned %d", p.ID, rr.Code)
+		}
+	}
+	if roomPhotos, _ := Repo.DB.RoomPhotos(2); len(roomPhotos) != 0 {
+		t.Errorf("expected the photos to be deleted, %d left", len(roomPhotos))
+	}
+	if _, err := os.Stat(filepath.Join(app.Storage.(*storage.Local).Dir, first.Original)); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be deleted, got %v", err)
+	}
+}
+
+func TestRepository_StayRules(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	defer Repo.DB.UpdateRoomStayRules(1, models.DefaultStayRules)
+	defer Repo.DB.UpdateRoomStayRules(2, models.DefaultStayRules)
+
+	post := func(handler http.HandlerFunc, target string, id string, form url.Values) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", id)
+		req = req.WithContext(context.WithValue(getCtx(req), chi.RouteCtxKey, rctx))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// arrivals on saturday only, for a week at least
+	rules := url.Values{"min_nights": {"7"}, "max_nights": {"14"}, "arrival_days": {"6"}, "lead_days": {"0"}, "window_days": {""}}
+	for _, id := range []string{"1", "2"} {
+		rr := post(Repo.AdminPostRoomStayRules, "/admin/stay-rules/"+id, id, rules)
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("saving the rules of room %s returned %d", id, rr.Code)
+		}
+	}
+	room, _ := Repo.DB.GetRoomByID(1)
+	if room.Rules != (models.StayRules{MinNights: 7, MaxNights: 14, ArrivalDays: 1 << time.Saturday}) {
+		t.Errorf("unexpected rules %+v", room.Rules)
+	}
+
+	var invalid = []url.Values{
+		{"min_nights": {"0"}, "arrival_days": {"6"}},
+		{"min_nights": {"7"}, "max_nights": {"3"}, "arrival_days": {"6"}},
+		{"min_nights": {"1"}, "lead_days": {"30"}, "window_days": {"7"}, "arrival_days": {"6"}},
+		{"min_nights": {"1"}, "arrival_days": {"7"}},
+		{"min_nights": {"1"}},
+	}
+	for _, form := range invalid {
+		post(Repo.AdminPostRoomStayRules, "/admin/stay-rules/1", "1", form)
+	}
+	if r, _ := Repo.DB.GetRoomByID(1); r.Rules != room.Rules {
+		t.Errorf("invalid rules were saved: %+v", r.Rules)
+	}
+
+	// christmas day is closed to departures in the suite
+	closed := url.Values{"room": {"2"}, "first_date": {"2077-12-25"}, "last_date": {"2077-12-25"}, "note": {"Christmas"}}
+	rr := post(Repo.AdminPostClosedDate, "/admin/stay-rules/closed-dates", "", closed)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Close the days to arrivals, departures or both") {
+		t.Errorf("closed dates without arrival or departure returned %d", rr.Code)
+	}
+	closed.Set("closed_to_departure", "1")
+	rr = post(Repo.AdminPostClosedDate, "/admin/stay-rules/closed-dates", "", closed)
+	if rr.Code != http.StatusSeeOther {
+		t.Errorf("adding closed dates returned %d", rr.Code)
+	}
+	all, _ := Repo.DB.AllClosedDates()
+	if len(all) != 1 || !all[0].ClosedToDeparture || all[0].ClosedToArrival || all[0].Room.RoomName != "Master" {
+		t.Fatalf("unexpected closed dates %+v", all)
+	}
+	defer Repo.DB.DeleteClosedDate(all[0].ID)
+
+	// 2077-12-18 is a saturday
+	search := func(start, end string) *httptest.ResponseRecorder {
+		return post(Repo.PostAvailability, "/search-availability", "", url.Values{"start": {start}, "end": {end}})
+	}
+	rr = search("2077-12-17", "2077-12-24")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Quarters: Arrival is only possible on Saturday") ||
+		!strings.Contains(rr.Body.String(), "Master: Arrival is only possible on Saturday") {
+		t.Errorf("arriving on a friday returned %d", rr.Code)
+	}
+	rr = search("2077-12-18", "2077-12-25")
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "Quarters") || strings.Contains(rr.Body.String(), "Master") {
+		t.Errorf("expected only the quarters when leaving on christmas, got %d", rr.Code)
+	}
+
+	available := func(start, end, roomID string) jsonResponse {
+		rr := post(Repo.AvailabilityJSON, "/search-availability-json", "", url.Values{"start": {start}, "end": {end}, "room_id": {roomID}})
+		var j jsonResponse
+		json.Unmarshal(rr.Body.Bytes(), &j)
+		return j
+	}
+	if j := available("2077-12-18", "2077-12-20", "1"); j.OK || j.Message != "The minimum stay is 7 nights" {
+		t.Errorf("expected a two night stay to break the minimum, got %+v", j)
+	}
+	if j := available("2077-12-18", "2077-12-25", "1"); !j.OK || j.Message != "" {
+		t.Errorf("expected a week to be fine, got %+v", j)
+	}
+
+	book := func(id, start, end string) *httptest.ResponseRecorder {
+		form := url.Values{"id": {id}, "s": {start}, "e": {end}}
+		req, _ := http.NewRequest("POST", "/book-room", strings.NewReader(form.Encode()))
+		req = req.WithContext(getCtx(req))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(Repo.BookRoom).ServeHTTP(rr, req)
+		return rr
+	}
+	if rr := book("2", "2077-12-18", "2077-12-25"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/rooms/majors-suite" {
+		t.Errorf("booking a departure on christmas went to %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+	if rr := book("2", "2077-12-18", "2077-12-26"); rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/make-reservation" {
+		t.Errorf("booking a fine stay went to %d %q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// the rules are checked again when the reservation is made
+	form := url.Values{"first_name": {"Grace"}, "last_name": {"Hopper"}, "email": {"grace@example.com"}}
+	req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	ctx := getCtx(req)
+	session.Put(ctx, "reservation", models.Reservation{StartDate: date("2077-12-18"), EndDate: date("2077-12-21"), RoomID: 1})
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(Repo.PostReservation).ServeHTTP(rr, req.WithContext(ctx))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "The minimum stay is 7 nights") {
+		t.Errorf("a stay that's too short returned %d", rr.Code)
+	}
+}
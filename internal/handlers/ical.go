@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/tsawler/bookings-app/internal/helpers"
+	"github.com/tsawler/bookings-app/internal/ical"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/render"
+)
+
+// how far back and ahead the calendar feeds go
+const (
+	feedPast   = -1 // years
+	feedFuture = 2  // years
+)
+
+// RoomCalendar serves the reservations and owner blocks of a room as an iCalendar feed,
+// the token in the url is the only thing protecting it
+func (m *Repository) RoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomByICalToken(chi.URLParam(r, "token"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	restrictions, err := m.DB.GetReservationForRoomByDate(room.ID, today.AddDate(feedPast, 0, 0), today.AddDate(feedFuture, 0, 0))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	cal := ical.Calendar{
+		ProdID: "-//Bookings//Room Calendar//EN",
+		Name:   room.RoomName,
+	}
+	for _, rr := range restrictions {
+		switch rr.RestrictionID {
+		case models.RestrictionHold:
+			// gone in minutes, long before the other sites read the feed again
+			continue
+		case models.RestrictionExternal:
+			// imported from another site, sending it back would make that site block its own booking
+			continue
+		}
+		cal.Events = append(cal.Events, m.calendarEvent(rr))
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="room-%d.ics"`, room.ID))
+	err = ical.Write(w, cal)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// calendarEvent turns a room restriction into an all-day event, the uid stays the same as long as the restriction exists
+func (m *Repository) calendarEvent(rr models.RoomRestriction) ical.Event {
+	e := ical.Event{
+		UID:   fmt.Sprintf("room-restriction-%d@bookings", rr.ID),
+		Start: rr.StartDate,
+		End:   rr.EndDate,
+		Stamp: rr.UpdatedAt,
+	}
+
+	switch rr.RestrictionID {
+	case models.RestrictionReservation:
+		e.Summary = fmt.Sprintf("Reserved: %s %s", rr.Reservation.FirstName, rr.Reservation.LastName)
+		e.Categories = "Reservation"
+		e.URL = fmt.Sprintf("%s/admin/reservation/cal/%d", m.App.BaseURL, rr.ReservationID)
+	case models.RestrictionOwnerBlock:
+		e.Summary = "Blocked by owner"
+		e.Categories = "Owner Block"
+	default:
+		e.Summary = "Unavailable"
+	}
+	return e
+}
+
+// AdminCalendarFeeds shows the calendar feed url of each room
+func (m *Repository) AdminCalendarFeeds(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	stringMap := make(map[string]string)
+	stringMap["base_url"] = m.App.BaseURL
+
+	render.Template(w, r, "admin-calendar-feeds.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+	})
+}
+
+// AdminPostCalendarFeed gives a room a new feed url, the old one stops working
+func (m *Repository) AdminPostCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, http.StatusNotFound)
+		return
+	}
+
+	token, err := newFeedToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	err = m.DB.UpdateRoomICalToken(roomID, token)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "New calendar feed url created")
+	http.Redirect(w, r, "/admin/calendar-feeds", http.StatusSeeOther)
+}
+
+// newFeedToken returns a random token, long enough that feed urls can't be guessed
+func newFeedToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,177 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tsawler/bookings-app/api"
+)
+
+// the Go type behind each schema of api/openapi.json
+var openAPISchemas = map[string]interface{}{
+	"AvailabilityJSONResponse": jsonResponse{},
+	"Error":                    apiErrorResponse{},
+	"ErrorBody":                apiError{},
+	"Room":                     apiRoom{},
+	"RoomsResponse":            apiRoomsResponse{},
+	"AvailabilityResponse":     apiAvailabilityResponse{},
+	"Reservation":              apiReservation{},
+	"ReservationResponse":      apiReservationResponse{},
+	"ReservationRequest":       apiReservationRequest{},
+	"Block":                    apiBlock{},
+	"BlocksResponse":           apiBlocksResponse{},
+	"BlockResponse":            apiBlockResponse{},
+	"BlockRequest":             apiBlockRequest{},
+}
+
+type openAPIDoc struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas map[string]struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		} `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPIMedia struct {
+	Schema struct {
+		Ref string `json:"$ref"`
+	} `json:"schema"`
+	Example json.RawMessage `json:"example"`
+}
+
+type openAPIOperation struct {
+	RequestBody struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"requestBody"`
+	Responses map[string]struct {
+		Content map[string]openAPIMedia `json:"content"`
+	} `json:"responses"`
+}
+
+func loadOpenAPI(t *testing.T) openAPIDoc {
+	t.Helper()
+
+	var doc openAPIDoc
+	if err := json.Unmarshal(api.OpenAPI, &doc); err != nil {
+		t.Fatal("can't parse openapi.json:", err)
+	}
+	return doc
+}
+
+// jsonFields returns the json names of the fields of v
+func jsonFields(v interface{}) []string {
+	var names []string
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestOpenAPI_Schemas(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	for name, schema := range doc.Components.Schemas {
+		v, ok := openAPISchemas[name]
+		if !ok {
+			t.Errorf("schema %s has no Go type in openAPISchemas", name)
+			continue
+		}
+
+		var properties []string
+		for p := range schema.Properties {
+			properties = append(properties, p)
+		}
+		sort.Strings(properties)
+
+		if fields := jsonFields(v); !reflect.DeepEqual(properties, fields) {
+			t.Errorf("schema %s has properties %v, the Go type has %v", name, properties, fields)
+		}
+	}
+}
+
+func TestOpenAPI_Examples(t *testing.T) {
+	doc := loadOpenAPI(t)
+
+	for path, item := range doc.Paths {
+		for method, raw := range item {
+			if method == "parameters" {
+				continue
+			}
+			var op openAPIOperation
+			if err := json.Unmarshal(raw, &op); err != nil {
+				t.Fatalf("%s %s: %v", method, path, err)
+			}
+
+			media := map[string]openAPIMedia{"request": op.RequestBody.Content["application/json"]}
+			for status, resp := range op.Responses {
+				media[status] = resp.Content["application/json"]
+			}
+
+			for where, m := range media {
+				if m.Schema.Ref == "" {
+					continue
+				}
+				name := strings.TrimPrefix(m.Schema.Ref, "#/components/schemas/")
+				checkOpenAPIExample(t, method+" "+path+" "+where, name, m.Example)
+			}
+		}
+	}
+}
+
+// checkOpenAPIExample decodes the example into the Go type of the schema,
+// it must have no unknown fields and must not miss any either
+func checkOpenAPIExample(t *testing.T, where, schema string, example json.RawMessage) {
+	t.Helper()
+
+	v, ok := openAPISchemas[schema]
+	if !ok {
+		t.Errorf("%s: schema %s has no Go type", where, schema)
+		return
+	}
+	if len(example) == 0 {
+		t.Errorf("%s: no example", where)
+		return
+	}
+
+	ptr := reflect.New(reflect.TypeOf(v))
+	dec := json.NewDecoder(bytes.NewReader(example))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(ptr.Interface()); err != nil {
+		t.Errorf("%s: example doesn't fit %T: %v", where, v, err)
+		return
+	}
+
+	// what the handlers would send for the example
+	out, _ := json.Marshal(ptr.Interface())
+	var want, got interface{}
+	json.Unmarshal(example, &want)
+	json.Unmarshal(out, &got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("%s: example is\n%s\nbut %T encodes as\n%s", where, example, v, out)
+	}
+}
+
+func TestRepository_OpenAPI(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Repo.OpenAPI).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("OpenAPI handler returned %d with %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if !json.Valid(rr.Body.Bytes()) {
+		t.Error("OpenAPI handler did not return json")
+	}
+}
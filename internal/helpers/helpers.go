@@ -1,11 +1,13 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime/debug"
 
 	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
 )
 
 var app *config.AppConfig
@@ -28,6 +30,25 @@ func ServerError(w http.ResponseWriter, err error) {
 
 }
 
+// IsAuthenticated tells if the request comes from a logged in user or carries a valid api key
 func IsAuthenticated(r *http.Request) bool {
+	if _, ok := APIKey(r); ok {
+		return true
+	}
 	return app.Session.Exists(r.Context(), "user_id")
+}
+
+type contextKey string
+
+const apiKeyContextKey contextKey = "api_key"
+
+// WithAPIKey returns r carrying the api key it was authenticated with
+func WithAPIKey(r *http.Request, k models.APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, k))
+}
+
+// APIKey returns the api key the request was authenticated with, ok is false for session users
+func APIKey(r *http.Request) (models.APIKey, bool) {
+	k, ok := r.Context().Value(apiKeyContextKey).(models.APIKey)
+	return k, ok
 }
\ No newline at end of file
@@ -0,0 +1,67 @@
+// Package holds sweeps away the holds guests got on a room while filling in the reservation form
+// and then left without booking, so the room can be booked again
+package holds
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// Sweeper deletes the expired holds
+type Sweeper struct {
+	DB       repository.DatabaseRepo
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Interval time.Duration // how often expired holds are looked for, a hold can outlive its expiry by this much
+
+	// now returns the time, the tests move it
+	now func() time.Time
+}
+
+// New creates a sweeper that sweeps every interval
+func New(db repository.DatabaseRepo, interval time.Duration, infoLog, errorLog *log.Logger) *Sweeper {
+	return &Sweeper{
+		DB:       db,
+		InfoLog:  infoLog,
+		ErrorLog: errorLog,
+		Interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run sweeps every Interval until ctx is cancelled. The returned channel is closed when it is done
+func (s *Sweeper) Run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.Sweep()
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// Sweep deletes the holds that have expired, returns how many
+func (s *Sweeper) Sweep() int {
+	n, err := s.DB.DeleteExpiredHolds(s.now())
+	if err != nil {
+		s.ErrorLog.Println("holds:", err)
+		return 0
+	}
+	if n > 0 {
+		s.InfoLog.Printf("holds: %d expired", n)
+	}
+	return n
+}
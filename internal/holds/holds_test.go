@@ -0,0 +1,122 @@
+package holds
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func newSweeper(now time.Time) *Sweeper {
+	quiet := log.New(io.Discard, "", 0)
+	s := New(dbrepo.NewMemoryRepo(&config.AppConfig{}), time.Minute, quiet, quiet)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func hold(t *testing.T, db repository.DatabaseRepo, roomID int, start, end string, expiresAt time.Time) int {
+	t.Helper()
+	id, err := db.InsertHold(models.RoomRestriction{RoomID: roomID, StartDate: date(start), EndDate: date(end), ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestSweep(t *testing.T) {
+	now := time.Date(2078, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newSweeper(now)
+
+	hold(t, s.DB, 1, "2078-06-01", "2078-06-03", now.Add(-time.Minute))
+	hold(t, s.DB, 2, "2078-06-01", "2078-06-03", now.Add(10*time.Minute))
+
+	// a held room can't be held or booked by someone else
+	_, err := s.DB.InsertHold(models.RoomRestriction{RoomID: 1, StartDate: date("2078-06-02"), EndDate: date("2078-06-04"), ExpiresAt: now})
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the room to be unavailable, got %v", err)
+	}
+	_, err = s.DB.BookReservation(models.Reservation{RoomID: 1, StartDate: date("2078-06-01"), EndDate: date("2078-06-03")}, 0, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the room to be unavailable, got %v", err)
+	}
+
+	if n := s.Sweep(); n != 1 {
+		t.Errorf("expected 1 hold swept, got %d", n)
+	}
+	available, _ := s.DB.SearchAvailabilityByDatesByRoomID(date("2078-06-01"), date("2078-06-03"), 1)
+	if !available {
+		t.Error("expected room 1 to be free once its hold expired")
+	}
+	available, _ = s.DB.SearchAvailabilityByDatesByRoomID(date("2078-06-01"), date("2078-06-03"), 2)
+	if available {
+		t.Error("expected room 2 to stay held")
+	}
+	if n := s.Sweep(); n != 0 {
+		t.Errorf("expected nothing left to sweep, got %d", n)
+	}
+}
+
+func TestBookReservation_Hold(t *testing.T) {
+	now := time.Date(2078, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newSweeper(now)
+	id := hold(t, s.DB, 1, "2078-07-01", "2078-07-04", now.Add(time.Minute))
+
+	// the guest holding the room books it, the hold becomes the reservation
+	res := models.Reservation{FirstName: "Held", RoomID: 1, StartDate: date("2078-07-01"), EndDate: date("2078-07-04")}
+	resID, err := s.DB.BookReservation(res, id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restrictions, err := s.DB.GetReservationForRoomByDate(1, date("2078-07-01"), date("2078-07-05"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 1 || restrictions[0].ID != id || restrictions[0].RestrictionID != models.RestrictionReservation ||
+		restrictions[0].ReservationID != resID || !restrictions[0].ExpiresAt.IsZero() {
+		t.Errorf("expected the hold to be the restriction of the reservation, got %+v", restrictions)
+	}
+
+	// the reservation doesn't expire
+	s.now = func() time.Time { return now.Add(time.Hour) }
+	if n := s.Sweep(); n != 0 {
+		t.Errorf("expected nothing swept, got %d", n)
+	}
+
+	// a hold on other dates doesn't help
+	id = hold(t, s.DB, 1, "2078-07-10", "2078-07-12", now.Add(time.Minute))
+	_, err = s.DB.BookReservation(models.Reservation{RoomID: 1, StartDate: date("2078-07-11"), EndDate: date("2078-07-13")}, id, nil)
+	if !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected the room to be unavailable, got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	now := time.Date(2078, 5, 1, 12, 0, 0, 0, time.UTC)
+	s := newSweeper(now)
+	hold(t, s.DB, 1, "2078-08-01", "2078-08-03", now)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	select {
+	case <-s.Run(ctx):
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the sweeper to stop")
+	}
+
+	available, _ := s.DB.SearchAvailabilityByDatesByRoomID(date("2078-08-01"), date("2078-08-03"), 1)
+	if !available {
+		t.Error("expected the hold to be swept before stopping")
+	}
+}
@@ -0,0 +1,127 @@
+// Package ical writes iCalendar (RFC 5545) feeds, so reservations and blocks show up in Google or Apple Calendar,
+// and reads the feeds of other booking sites
+package ical
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dateLayout  = "20060102"
+	stampLayout = "20060102T150405Z"
+
+	// lines longer than this many octets are folded
+	maxLineLength = 75
+)
+
+// Event is an all-day event from Start to End. End is exclusive, like the end_date of room_restrictions:
+// a stay from the 1st to the 4th ends on the 4th and covers the nights of the 1st, 2nd and 3rd
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Categories  string
+	URL         string
+	Status      string // TENTATIVE, CONFIRMED or CANCELLED, empty if not given
+	Start       time.Time
+	End         time.Time
+	Stamp       time.Time // when the event last changed
+
+	// set on the changed occurrences of a recurring event, they share the uid of the event
+	RecurrenceID string
+}
+
+// Key tells the events of a calendar apart, the uid alone doesn't for changed occurrences
+func (e Event) Key() string {
+	if e.RecurrenceID == "" {
+		return e.UID
+	}
+	return e.UID + "/" + e.RecurrenceID
+}
+
+// Calendar is a feed of events
+type Calendar struct {
+	ProdID string
+	Name   string
+	Events []Event
+}
+
+// Write writes c to w as a VCALENDAR
+func Write(w io.Writer, c Calendar) error {
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", e.UID)
+		line("DTSTAMP", e.Stamp.UTC().Format(stampLayout))
+		// floating dates, an all-day event is the same days in every time zone
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		line("SUMMARY", escape(e.Summary))
+		if e.Description != "" {
+			line("DESCRIPTION", escape(e.Description))
+		}
+		if e.Categories != "" {
+			line("CATEGORIES", escape(e.Categories))
+		}
+		if e.URL != "" {
+			line("URL", e.URL)
+		}
+		if e.Status != "" {
+			line("STATUS", e.Status)
+		}
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// writeLine writes a content line ending in CRLF, folded so no line is longer than 75 octets.
+// a folded line goes on after a CRLF and a space, and a utf-8 character is never split
+func writeLine(w *bufio.Writer, s string) {
+	limit := maxLineLength
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		w.WriteString(s[:cut])
+		w.WriteString("\r\n ")
+		s = s[cut:]
+		// the leading space counts too
+		limit = maxLineLength - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+var textEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	";", `\;`,
+	",", `\,`,
+	"\r\n", `\n`,
+	"\n", `\n`,
+)
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return textEscaper.Replace(s)
+}
@@ -0,0 +1,73 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	err := Write(&buf, Calendar{
+		ProdID: "-//Bookings//Test//EN",
+		Name:   "Quarters",
+		Events: []Event{{
+			UID:         "1@bookings",
+			Summary:     "Reserved: Smith, John; 2 nights",
+			Description: "line one\nline two",
+			Start:       date("2030-06-01"),
+			End:         date("2030-06-04"),
+			Stamp:       time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600)),
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\n",
+		"X-WR-CALNAME:Quarters\r\n",
+		"UID:1@bookings\r\n",
+		"DTSTAMP:20300102T020405Z\r\n",
+		// the end date is the departure day, the night before is the last one
+		"DTSTART;VALUE=DATE:20300601\r\nDTEND;VALUE=DATE:20300604\r\n",
+		`SUMMARY:Reserved: Smith\, John\; 2 nights` + "\r\n",
+		`DESCRIPTION:line one\nline two` + "\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in\n%s", want, out)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(out, "\r\n", ""), "\n") {
+		t.Error("found a line ending without CR")
+	}
+}
+
+func TestWrite_Folding(t *testing.T) {
+	summary := strings.Repeat("é", 100)
+
+	var buf bytes.Buffer
+	Write(&buf, Calendar{Events: []Event{{Summary: summary}}})
+
+	var unfolded string
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLineLength {
+			t.Errorf("line of %d octets: %q", len(line), line)
+		}
+		if !strings.HasPrefix(line, " ") {
+			unfolded += "\n"
+		}
+		unfolded += strings.TrimPrefix(line, " ")
+	}
+	if !strings.Contains(unfolded, "\nSUMMARY:"+summary+"\n") {
+		t.Errorf("summary did not survive folding:\n%s", unfolded)
+	}
+}
@@ -0,0 +1,285 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the longest line we accept, after unfolding
+const maxParsedLine = 1 << 20
+
+// ErrNotCalendar is returned by Parse when the input has no VCALENDAR
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// property is a parsed content line, e.g. DTSTART;VALUE=DATE:20300601
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Parse reads the events of a calendar. Only what is needed to block a room is kept, and every event becomes
+// whole days: Start is the first day, End the day after the last one. A timed event that ends after midnight
+// covers the day it ends on too. Recurrence rules are not expanded, a recurring event counts once.
+// Events without a UID or DTSTART are skipped, a date that can't be read fails the whole calendar.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []Event
+	var stack []string // the components we are in
+	var props []property
+	seenCalendar := false
+
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, ok := parseProperty(line)
+		if !ok {
+			return nil, fmt.Errorf("line %d: invalid content line %q", n+1, line)
+		}
+
+		switch p.name {
+		case "BEGIN":
+			name := strings.ToUpper(p.value)
+			if name == "VCALENDAR" {
+				seenCalendar = true
+			}
+			if name == "VEVENT" && len(stack) == 1 && stack[0] == "VCALENDAR" {
+				props = nil
+			}
+			stack = append(stack, name)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1] != strings.ToUpper(p.value) {
+				return nil, fmt.Errorf("line %d: END:%s without BEGIN", n+1, p.value)
+			}
+			stack = stack[:len(stack)-1]
+			if strings.ToUpper(p.value) == "VEVENT" && len(stack) == 1 {
+				e, ok, err := eventFrom(props)
+				if err != nil {
+					return nil, fmt.Errorf("event ending on line %d: %w", n+1, err)
+				}
+				if ok {
+					events = append(events, e)
+				}
+			}
+		default:
+			// properties of an event, not of its alarms
+			if len(stack) == 2 && stack[1] == "VEVENT" {
+				props = append(props, p)
+			}
+		}
+	}
+
+	if !seenCalendar {
+		return nil, ErrNotCalendar
+	}
+	if len(stack) != 0 {
+		return nil, fmt.Errorf("%s is not closed", stack[len(stack)-1])
+	}
+	return events, nil
+}
+
+// unfold reads the content lines, joining folded lines again. LF alone is accepted as line ending too
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxParsedLine)
+
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseProperty splits name;param=value;param="quoted:value":value
+func parseProperty(line string) (property, bool) {
+	p := property{params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return p, false
+	}
+	p.name = strings.ToUpper(line[:i])
+
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return p, false
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return p, false
+			}
+			value = line[1 : end+1]
+			line = line[end+2:]
+		} else {
+			end := strings.IndexAny(line, ";:")
+			if end < 0 {
+				return p, false
+			}
+			value = line[:end]
+			line = line[end:]
+		}
+		p.params[name] = value
+
+		i = 0
+		if line == "" {
+			return p, false
+		}
+	}
+	if line[i] != ':' {
+		return p, false
+	}
+	p.value = line[i+1:]
+	return p, true
+}
+
+// eventFrom builds the event from its properties, ok is false for events we skip
+func eventFrom(props []property) (e Event, ok bool, err error) {
+	var start, end *property
+	var duration string
+
+	for i, p := range props {
+		switch p.name {
+		case "UID":
+			e.UID = p.value
+		case "SUMMARY":
+			e.Summary = unescape(p.value)
+		case "DESCRIPTION":
+			e.Description = unescape(p.value)
+		case "STATUS":
+			e.Status = strings.ToUpper(p.value)
+		case "RECURRENCE-ID":
+			e.RecurrenceID = p.value
+		case "DTSTAMP", "LAST-MODIFIED":
+			if t, err := time.Parse(stampLayout, p.value); err == nil && t.After(e.Stamp) {
+				e.Stamp = t
+			}
+		case "DTSTART":
+			start = &props[i]
+		case "DTEND":
+			end = &props[i]
+		case "DURATION":
+			duration = p.value
+		}
+	}
+	if e.UID == "" || start == nil {
+		return e, false, nil
+	}
+
+	startTime, allDay, err := parseTime(*start)
+	if err != nil {
+		return e, false, err
+	}
+	e.Start = day(startTime)
+
+	switch {
+	case end != nil:
+		endTime, _, err := parseTime(*end)
+		if err != nil {
+			return e, false, err
+		}
+		e.End = day(endTime)
+		if !allDay && hasTimeOfDay(endTime) {
+			e.End = e.End.AddDate(0, 0, 1)
+		}
+	case duration != "":
+		d, days, err := parseDuration(duration)
+		if err != nil {
+			return e, false, err
+		}
+		endTime := startTime.AddDate(0, 0, days).Add(d)
+		e.End = day(endTime)
+		if hasTimeOfDay(endTime) {
+			e.End = e.End.AddDate(0, 0, 1)
+		}
+	}
+	// no end, or an end that isn't after the start: it's the one day
+	if !e.End.After(e.Start) {
+		e.End = e.Start.AddDate(0, 0, 1)
+	}
+	return e, true, nil
+}
+
+// parseTime reads a DATE or DATE-TIME value, allDay is true for a DATE
+func parseTime(p property) (t time.Time, allDay bool, err error) {
+	if strings.ToUpper(p.params["VALUE"]) == "DATE" || len(p.value) == len(dateLayout) {
+		t, err = time.Parse(dateLayout, p.value)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(p.value, "Z") {
+		t, err = time.Parse(stampLayout, p.value)
+		return t, false, err
+	}
+
+	// local time, in the time zone of TZID if we know it
+	loc := time.UTC
+	if tzid := p.params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err = time.ParseInLocation(strings.TrimSuffix(stampLayout, "Z"), p.value, loc)
+	return t, false, err
+}
+
+var durationPattern = regexp.MustCompile(`^[+]?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseDuration reads a DURATION like P3D or PT36H into whole days and the rest
+func parseDuration(s string) (time.Duration, int, error) {
+	m := durationPattern.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil || s == "P" {
+		return 0, 0, fmt.Errorf("invalid duration %q", s)
+	}
+	n := func(i int) int {
+		v, _ := strconv.Atoi(m[i])
+		return v
+	}
+	days := 7*n(1) + n(2)
+	d := time.Duration(n(3))*time.Hour + time.Duration(n(4))*time.Minute + time.Duration(n(5))*time.Second
+	return d, days, nil
+}
+
+// day returns the date of t at midnight UTC, how dates are stored in room_restrictions
+func day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func hasTimeOfDay(t time.Time) bool {
+	return t.Hour() != 0 || t.Minute() != 0 || t.Second() != 0
+}
+
+var textUnescaper = strings.NewReplacer(
+	`\\`, `\`,
+	`\;`, ";",
+	`\,`, ",",
+	`\n`, "\n",
+	`\N`, "\n",
+)
+
+// unescape undoes escape
+func unescape(s string) string {
+	return textUnescaper.Replace(s)
+}
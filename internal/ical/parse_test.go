@@ -0,0 +1,117 @@
+package ical
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const bookingSiteFeed = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Other Site//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:abc@other\r\n" +
+	"DTSTAMP:20300101T120000Z\r\n" +
+	"DTSTART;VALUE=DATE:20300601\r\n" +
+	"DTEND;VALUE=DATE:20300604\r\n" +
+	"SUMMARY:Reserved\\, Smith\r\n" +
+	"DESCRIPTION:a long description that was folded\r\n" +
+	"  by the other site\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"DESCRIPTION:alarm\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:timed@other\r\n" +
+	"DTSTART;TZID=\"Europe/Berlin\":20300610T150000\r\n" +
+	"DTEND;TZID=\"Europe/Berlin\":20300612T110000\r\n" +
+	"SUMMARY:Not available\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:duration@other\r\n" +
+	"DTSTART:20300620\r\n" +
+	"DURATION:P1W\r\n" +
+	"STATUS:CANCELLED\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:single@other\r\n" +
+	"DTSTART:20300701T100000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"SUMMARY:no uid, skipped\r\n" +
+	"DTSTART:20300701\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(bookingSiteFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("got %d events, wanted 4: %+v", len(events), events)
+	}
+
+	var tests = []struct {
+		uid    string
+		start  string
+		end    string
+		status string
+	}{
+		{"abc@other", "2030-06-01", "2030-06-04", ""},
+		// leaves at 11 on the 12th, so the 12th is taken too
+		{"timed@other", "2030-06-10", "2030-06-13", ""},
+		{"duration@other", "2030-06-20", "2030-06-27", "CANCELLED"},
+		// no end: the one day
+		{"single@other", "2030-07-01", "2030-07-02", ""},
+	}
+	for i, e := range tests {
+		got := events[i]
+		if got.UID != e.uid || got.Start.Format("2006-01-02") != e.start || got.End.Format("2006-01-02") != e.end || got.Status != e.status {
+			t.Errorf("event %d is %s %s to %s %s, wanted %s %s to %s %s", i, got.UID, got.Start.Format("2006-01-02"),
+				got.End.Format("2006-01-02"), got.Status, e.uid, e.start, e.end, e.status)
+		}
+	}
+
+	first := events[0]
+	if first.Summary != "Reserved, Smith" || first.Description != "a long description that was folded by the other site" {
+		t.Errorf("unexpected text %q %q", first.Summary, first.Description)
+	}
+	if first.Stamp.Format(stampLayout) != "20300101T120000Z" {
+		t.Errorf("unexpected stamp %s", first.Stamp)
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	in := Event{UID: "1@bookings", Summary: strings.Repeat("long, summary; ", 10), Start: date("2030-06-01"), End: date("2030-06-04")}
+	Write(&buf, Calendar{ProdID: "-//Test//EN", Events: []Event{in}})
+
+	events, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Summary != in.Summary || !events[0].Start.Equal(in.Start) || !events[0].End.Equal(in.End) {
+		t.Errorf("got %+v back", events)
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	var tests = []struct {
+		name  string
+		input string
+	}{
+		{"html", "<html><body>Not found</body></html>"},
+		{"empty", ""},
+		{"not closed", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART:20300101\n"},
+		{"bad date", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART:2030-01-01\nEND:VEVENT\nEND:VCALENDAR\n"},
+		{"bad duration", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:1\nDTSTART:20300101\nDURATION:3 days\nEND:VEVENT\nEND:VCALENDAR\n"},
+	}
+
+	for _, e := range tests {
+		if _, err := Parse(strings.NewReader(e.input)); err == nil {
+			t.Errorf("%s: no error", e.name)
+		}
+	}
+}
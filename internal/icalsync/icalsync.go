@@ -0,0 +1,231 @@
+// Package icalsync imports the calendars of other booking sites as external blocks,
+// so a room booked elsewhere can't be booked here too
+package icalsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/ical"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// the largest calendar we download
+const maxCalendarSize = 5 << 20
+
+// Syncer reconciles the external blocks of each source with the events of its calendar
+type Syncer struct {
+	DB       repository.DatabaseRepo
+	Client   *http.Client
+	InfoLog  *log.Logger
+	ErrorLog *log.Logger
+	Interval time.Duration // how often every source is synced
+
+	// now returns today, the tests move it
+	now func() time.Time
+}
+
+// Result says what a sync changed, Conflicts lists the events that overlap a reservation or block of ours
+type Result struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Conflicts []string
+}
+
+func (r Result) String() string {
+	s := fmt.Sprintf("%d added, %d updated, %d removed", r.Added, r.Updated, r.Removed)
+	if len(r.Conflicts) > 0 {
+		s += fmt.Sprintf(", %d conflicting", len(r.Conflicts))
+	}
+	return s
+}
+
+// New creates a syncer that syncs every interval
+func New(db repository.DatabaseRepo, interval time.Duration, infoLog, errorLog *log.Logger) *Syncer {
+	return &Syncer{
+		DB:       db,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		InfoLog:  infoLog,
+		ErrorLog: errorLog,
+		Interval: interval,
+		now:      time.Now,
+	}
+}
+
+// Run syncs all sources every Interval until ctx is cancelled. The returned channel is closed when it is done
+func (s *Syncer) Run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+
+		for {
+			s.SyncAll(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// SyncAll syncs every source, a source that fails doesn't stop the others
+func (s *Syncer) SyncAll(ctx context.Context) {
+	sources, err := s.DB.AllICalSources()
+	if err != nil {
+		s.ErrorLog.Println("ical sync:", err)
+		return
+	}
+
+	for _, src := range sources {
+		if ctx.Err() != nil {
+			return
+		}
+		result, err := s.Sync(ctx, src)
+		if err != nil {
+			s.ErrorLog.Printf("ical sync: %s of %s: %s", src.Name, src.Room.RoomName, err)
+			continue
+		}
+		s.InfoLog.Printf("ical sync: %s of %s: %s", src.Name, src.Room.RoomName, result)
+	}
+}
+
+// Sync reads the calendar of src and adds, moves and removes its external blocks to match the events.
+// Events are matched to blocks by uid. Past events are left alone, feeds tend to drop them.
+// The outcome is recorded on the source. Nothing is changed if the calendar can't be read
+func (s *Syncer) Sync(ctx context.Context, src models.ICalSource) (Result, error) {
+	result, err := s.sync(ctx, src)
+
+	lastError := ""
+	switch {
+	case err != nil:
+		lastError = err.Error()
+	case len(result.Conflicts) > 0:
+		lastError = "not imported, the room is taken: " + strings.Join(result.Conflicts, "; ")
+	}
+	if dbErr := s.DB.UpdateICalSourceSync(src.ID, time.Now(), lastError); dbErr != nil && err == nil {
+		err = dbErr
+	}
+	return result, err
+}
+
+func (s *Syncer) sync(ctx context.Context, src models.ICalSource) (Result, error) {
+	var result Result
+
+	events, err := s.events(ctx, src)
+	if err != nil {
+		return result, err
+	}
+
+	t := s.now()
+	today := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+
+	wanted := make(map[string]ical.Event)
+	for _, e := range events {
+		if e.Status == "CANCELLED" || !e.End.After(today) {
+			continue
+		}
+		wanted[e.Key()] = e
+	}
+
+	blocks, err := s.DB.ExternalBlocksForSource(src.ID)
+	if err != nil {
+		return result, err
+	}
+	existing := make(map[string]models.RoomRestriction)
+	for _, b := range blocks {
+		existing[b.ICalUID] = b
+	}
+
+	// removals first, they may make room for the moved and new events
+	for uid, b := range existing {
+		if _, ok := wanted[uid]; ok || !b.EndDate.After(today) {
+			continue
+		}
+		if err := s.DB.DeleteExternalBlock(b.ID); err != nil {
+			return result, err
+		}
+		result.Removed++
+	}
+
+	for uid, e := range wanted {
+		b, ok := existing[uid]
+		if !ok {
+			continue
+		}
+		if b.StartDate.Equal(e.Start) && b.EndDate.Equal(e.End) {
+			continue
+		}
+		err := s.DB.UpdateExternalBlock(b.ID, e.Start, e.End)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			result.Conflicts = append(result.Conflicts, conflict(e))
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	for uid, e := range wanted {
+		if _, ok := existing[uid]; ok {
+			continue
+		}
+		_, err := s.DB.InsertExternalBlock(models.RoomRestriction{
+			StartDate:    e.Start,
+			EndDate:      e.End,
+			RoomID:       src.RoomID,
+			ICalSourceID: src.ID,
+			ICalUID:      uid,
+		})
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			result.Conflicts = append(result.Conflicts, conflict(e))
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Added++
+	}
+
+	return result, nil
+}
+
+// events reads the calendar of src, from its url or else the uploaded content
+func (s *Syncer) events(ctx context.Context, src models.ICalSource) ([]ical.Event, error) {
+	if src.URL == "" {
+		return ical.Parse(strings.NewReader(src.Content))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", src.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", src.URL, resp.Status)
+	}
+	return ical.Parse(io.LimitReader(resp.Body, maxCalendarSize))
+}
+
+func conflict(e ical.Event) string {
+	return fmt.Sprintf("%s from %s to %s", e.UID, e.Start.Format("2006-01-02"), e.End.Format("2006-01-02"))
+}
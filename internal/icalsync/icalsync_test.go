@@ -0,0 +1,181 @@
+package icalsync
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+func date(s string) time.Time {
+	t, _ := time.Parse("2006-01-02", s)
+	return t
+}
+
+func calendar(events ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"
+}
+
+func event(uid, start, end string, extra ...string) string {
+	return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART;VALUE=DATE:" + start + "\r\nDTEND;VALUE=DATE:" + end + "\r\n" +
+		strings.Join(extra, "") + "END:VEVENT\r\n"
+}
+
+// feed is a calendar served by a test server, its content can be changed between syncs
+type feed struct {
+	mu     sync.Mutex
+	body   string
+	status int
+}
+
+func (f *feed) set(status int, body string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status, f.body = status, body
+}
+
+func (f *feed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "text/calendar")
+	w.WriteHeader(f.status)
+	io.WriteString(w, f.body)
+}
+
+func newSyncer() *Syncer {
+	quiet := log.New(io.Discard, "", 0)
+	s := New(dbrepo.NewMemoryRepo(&config.AppConfig{}), time.Minute, quiet, quiet)
+	s.now = func() time.Time { return date("2040-01-10") }
+	return s
+}
+
+// blocks returns the external blocks of the source as uid: start-end
+func blocks(t *testing.T, s *Syncer, sourceID int) map[string]string {
+	t.Helper()
+	bs, err := s.DB.ExternalBlocksForSource(sourceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]string)
+	for _, b := range bs {
+		m[b.ICalUID] = b.StartDate.Format("0102") + "-" + b.EndDate.Format("0102")
+	}
+	return m
+}
+
+func TestSync_URL(t *testing.T) {
+	f := &feed{}
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	s := newSyncer()
+	id, _ := s.DB.InsertICalSource(models.ICalSource{RoomID: 1, Name: "Other site", URL: server.URL + "/room.ics"})
+	src, _ := s.DB.GetICalSourceByID(id)
+
+	// a reservation of ours, the other site doesn't know about it yet
+	_, err := s.DB.BookReservation(models.Reservation{FirstName: "Ours", StartDate: date("2040-03-01"), EndDate: date("2040-03-05"), RoomID: 1}, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.set(http.StatusOK, calendar(
+		event("a", "20400201", "20400204"),
+		event("b", "20400210", "20400212"),
+		event("cancelled", "20400220", "20400222", "STATUS:CANCELLED\r\n"),
+		event("past", "20391201", "20391205"),
+	))
+	result, err := s.Sync(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 2 || len(result.Conflicts) != 0 {
+		t.Errorf("first sync: %s", result)
+	}
+	if got := blocks(t, s, id); len(got) != 2 || got["a"] != "0201-0204" || got["b"] != "0210-0212" {
+		t.Errorf("first sync made %v", got)
+	}
+	available, _ := s.DB.SearchAvailabilityByDatesByRoomID(date("2040-02-02"), date("2040-02-03"), 1)
+	if available {
+		t.Error("room is still available during an external event")
+	}
+
+	// a moved, b gone, c double books our reservation
+	f.set(http.StatusOK, calendar(
+		event("a", "20400202", "20400206"),
+		event("c", "20400303", "20400304"),
+	))
+	result, err = s.Sync(context.Background(), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Added != 0 || result.Updated != 1 || result.Removed != 1 || len(result.Conflicts) != 1 {
+		t.Errorf("second sync: %s", result)
+	}
+	if got := blocks(t, s, id); len(got) != 1 || got["a"] != "0202-0206" {
+		t.Errorf("second sync left %v", got)
+	}
+	src, _ = s.DB.GetICalSourceByID(id)
+	if !strings.Contains(src.LastError, "c from 2040-03-03") || src.LastSyncedAt.IsZero() {
+		t.Errorf("conflict not recorded: %q at %s", src.LastError, src.LastSyncedAt)
+	}
+
+	// a failing feed changes nothing
+	f.set(http.StatusInternalServerError, "")
+	_, err = s.Sync(context.Background(), src)
+	if err == nil {
+		t.Error("no error for a failing feed")
+	}
+	if got := blocks(t, s, id); len(got) != 1 {
+		t.Errorf("failing feed left %v", got)
+	}
+	src, _ = s.DB.GetICalSourceByID(id)
+	if !strings.Contains(src.LastError, "500") {
+		t.Errorf("error not recorded: %q", src.LastError)
+	}
+
+	// so does a page that isn't a calendar
+	f.set(http.StatusOK, "<html>maintenance</html>")
+	if _, err = s.Sync(context.Background(), src); err == nil {
+		t.Error("no error for a feed that isn't a calendar")
+	}
+	if got := blocks(t, s, id); len(got) != 1 {
+		t.Errorf("broken feed left %v", got)
+	}
+
+	// deleting the source removes its blocks
+	s.DB.DeleteICalSource(id)
+	available, _ = s.DB.SearchAvailabilityByDatesByRoomID(date("2040-02-02"), date("2040-02-03"), 1)
+	if !available {
+		t.Error("blocks of a deleted source are still there")
+	}
+}
+
+func TestSync_Upload(t *testing.T) {
+	s := newSyncer()
+	id, _ := s.DB.InsertICalSource(models.ICalSource{
+		RoomID:  2,
+		Name:    "Uploaded",
+		Content: calendar(event("x", "20400501", "20400503")),
+	})
+
+	s.SyncAll(context.Background())
+	if got := blocks(t, s, id); got["x"] != "0501-0503" {
+		t.Errorf("upload made %v", got)
+	}
+
+	// syncing again changes nothing
+	src, _ := s.DB.GetICalSourceByID(id)
+	result, err := s.Sync(context.Background(), src)
+	if err != nil || result.Added+result.Updated+result.Removed != 0 {
+		t.Errorf("second sync: %s %v", result, err)
+	}
+}
@@ -0,0 +1,68 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// File drops every message as an .eml file into a directory, handy when there is no smtp server
+type File struct {
+	Dir   string
+	count uint64
+}
+
+// NewFile creates a mailer writing to dir, the directory is created if needed
+func NewFile(dir string) (*File, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &File{Dir: dir}, nil
+}
+
+// Send writes m to a new file named after the time it was sent
+func (f *File) Send(m models.MailData) error {
+	now := time.Now()
+	n := atomic.AddUint64(&f.count, 1)
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", m.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	if m.PlainContent == "" {
+		fmt.Fprintf(&buf, "Content-Type: text/html; charset=UTF-8\r\n")
+		fmt.Fprintf(&buf, "\r\n%s\r\n", m.Content)
+	} else {
+		// plain text first, mail clients show the last part they understand
+		mw := multipart.NewWriter(&buf)
+		fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mw.Boundary())
+		for _, part := range []struct{ contentType, body string }{
+			{"text/plain; charset=UTF-8", m.PlainContent},
+			{"text/html; charset=UTF-8", m.Content},
+		} {
+			w, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s\r\n", part.body)
+		}
+		if err := mw.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := fmt.Sprintf("%s-%d.eml", now.Format("20060102-150405.000000000"), n)
+	return ioutil.WriteFile(filepath.Join(f.Dir, name), buf.Bytes(), 0644)
+}
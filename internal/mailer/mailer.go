@@ -0,0 +1,49 @@
+// Package mailer delivers email through a configurable transport: smtp, a directory of .eml files or memory,
+// messages arrive already rendered by render.Email
+package mailer
+
+import (
+	"fmt"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// Mailer sends one message
+type Mailer interface {
+	Send(m models.MailData) error
+}
+
+// MailerFunc lets an ordinary function be used as a Mailer
+type MailerFunc func(m models.MailData) error
+
+// Send calls f(m)
+func (f MailerFunc) Send(m models.MailData) error {
+	return f(m)
+}
+
+// the transports New knows about
+const (
+	SMTPTransport   = "smtp"
+	FileTransport   = "file"
+	MemoryTransport = "memory"
+)
+
+// Options configures the transports, only the fields of the chosen one are used
+type Options struct {
+	Host string // smtp
+	Port int    // smtp
+	Dir  string // file
+}
+
+// New creates the mailer for transport
+func New(transport string, opts Options) (Mailer, error) {
+	switch transport {
+	case SMTPTransport:
+		return NewSMTP(opts.Host, opts.Port), nil
+	case FileTransport:
+		return NewFile(opts.Dir)
+	case MemoryTransport:
+		return NewRecorder(), nil
+	}
+	return nil, fmt.Errorf("unknown mail transport %q", transport)
+}
@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+func TestNew(t *testing.T) {
+	var tests = []struct {
+		transport string
+		valid     bool
+	}{
+		{SMTPTransport, true},
+		{FileTransport, true},
+		{MemoryTransport, true},
+		{"pigeon", false},
+	}
+
+	for _, e := range tests {
+		_, err := New(e.transport, Options{Host: "localhost", Port: 1025, Dir: t.TempDir()})
+		if (err == nil) != e.valid {
+			t.Errorf("%s: unexpected error %v", e.transport, err)
+		}
+	}
+}
+
+func TestFile_Send(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Send(models.MailData{
+		To:      "guest@example.com",
+		From:    "admin@admin.com",
+		Subject: "Reservation Confirmation",
+		Content: "<strong>Hello</strong>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	for _, want := range []string{"To: guest@example.com\r\n", "Subject: Reservation Confirmation\r\n", "<strong>Hello</strong>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("eml file does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestFile_SendAlternative(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = f.Send(models.MailData{
+		To:           "guest@example.com",
+		From:         "admin@admin.com",
+		Subject:      "Reservation Confirmation",
+		Content:      "<strong>Hello</strong>",
+		PlainContent: "Hello",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected 1 .eml file, got %d", len(files))
+	}
+	data, _ := ioutil.ReadFile(files[0])
+	for _, want := range []string{"Content-Type: multipart/alternative; boundary=", "Content-Type: text/plain", "Content-Type: text/html", "<strong>Hello</strong>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("eml file does not contain %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder()
+	r.Send(models.MailData{To: "a@example.com"})
+	r.Send(models.MailData{To: "b@example.com"})
+
+	if msgs := r.Messages(); len(msgs) != 2 || msgs[1].To != "b@example.com" {
+		t.Errorf("unexpected recorded messages %v", msgs)
+	}
+	r.Reset()
+	if len(r.Messages()) != 0 {
+		t.Error("messages left after reset")
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"sync"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+// Recorder keeps the messages in memory instead of sending them, so tests can look at them
+type Recorder struct {
+	mu       sync.Mutex
+	messages []models.MailData
+}
+
+// NewRecorder creates an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Send records m
+func (r *Recorder) Send(m models.MailData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = append(r.messages, m)
+	return nil
+}
+
+// Messages returns the messages recorded so far
+func (r *Recorder) Messages() []models.MailData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]models.MailData(nil), r.messages...)
+}
+
+// Reset forgets the recorded messages
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.messages = nil
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	mail "github.com/xhit/go-simple-mail/v2"
+)
+
+// SMTP sends through an smtp server, e.g. MailHog on localhost:1025 in development
+type SMTP struct {
+	Host string
+	Port int
+}
+
+// NewSMTP creates a mailer for the smtp server at host:port
+func NewSMTP(host string, port int) *SMTP {
+	return &SMTP{
+		Host: host,
+		Port: port,
+	}
+}
+
+// Send connects to the server and sends m
+func (s *SMTP) Send(m models.MailData) error {
+	server := mail.NewSMTPClient()
+	server.Host = s.Host
+	server.Port = s.Port
+	server.KeepAlive = false
+	server.ConnectTimeout = 10 * time.Second
+	server.SendTimeout = 10 * time.Second
+
+	client, err := server.Connect()
+	if err != nil {
+		return err
+	}
+
+	email := mail.NewMSG()
+	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
+	if m.PlainContent != "" {
+		email.SetBody(mail.TextPlain, m.PlainContent)
+		email.AddAlternative(mail.TextHTML, m.Content)
+	} else {
+		email.SetBody(mail.TextHTML, m.Content)
+	}
+
+	return email.Send(client)
+}
@@ -0,0 +1,252 @@
+// Package migrate applies sql migrations and keeps track of them in the schema_migrations table
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// migration files are named <version>_<name>.<dialect>.<up|down>.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(\w+)\.(up|down)\.sql$`)
+
+// Migration is one version of the schema
+type Migration struct {
+	Version string
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status tells if a migration has been applied
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator runs the migrations of one dialect against a database
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New loads the migrations for dialect (postgres, sqlite) from fsys
+func New(db *sql.DB, fsys fs.FS, dialect string) (*Migrator, error) {
+	migrations, err := Load(fsys, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		DB:         db,
+		Migrations: migrations,
+	}, nil
+}
+
+// Load reads the migrations for dialect from fsys, ordered by version
+func Load(fsys fs.FS, dialect string) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[string]*Migration)
+	for _, f := range files {
+		parts := fileName.FindStringSubmatch(f)
+		if parts == nil || parts[3] != dialect {
+			continue
+		}
+		stmt, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+
+		version := parts[1]
+		mg, ok := byVersion[version]
+		if !ok {
+			mg = &Migration{Version: version, Name: parts[2]}
+			byVersion[version] = mg
+		}
+		if parts[4] == "up" {
+			mg.Up = string(stmt)
+		} else {
+			mg.Down = string(stmt)
+		}
+	}
+
+	var migrations []Migration
+	for _, mg := range byVersion {
+		if mg.Up == "" {
+			return nil, fmt.Errorf("migration %s_%s has no up file", mg.Version, mg.Name)
+		}
+		migrations = append(migrations, *mg)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies every migration that has not been applied yet, and returns the ones it ran
+func (m *Migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, mg := range m.Migrations {
+		if _, ok := applied[mg.Version]; ok {
+			continue
+		}
+		err := m.run(mg.Up, `insert into schema_migrations (version, applied_at) values ($1, $2)`, mg.Version, time.Now())
+		if err != nil {
+			return ran, fmt.Errorf("migrating up %s_%s: %w", mg.Version, mg.Name, err)
+		}
+		ran = append(ran, mg)
+	}
+	return ran, nil
+}
+
+// Down rolls back the last n applied migrations, and returns the ones it rolled back
+func (m *Migrator) Down(n int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for i := len(m.Migrations) - 1; i >= 0 && len(ran) < n; i-- {
+		mg := m.Migrations[i]
+		if _, ok := applied[mg.Version]; !ok {
+			continue
+		}
+		if mg.Down == "" {
+			return ran, fmt.Errorf("migration %s_%s has no down file", mg.Version, mg.Name)
+		}
+		err := m.run(mg.Down, `delete from schema_migrations where version = $1`, mg.Version)
+		if err != nil {
+			return ran, fmt.Errorf("migrating down %s_%s: %w", mg.Version, mg.Name, err)
+		}
+		ran = append(ran, mg)
+	}
+	return ran, nil
+}
+
+// Status lists every migration and whether it has been applied
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []Status
+	for _, mg := range m.Migrations {
+		appliedAt, ok := applied[mg.Version]
+		status = append(status, Status{
+			Migration: mg,
+			Applied:   ok,
+			AppliedAt: appliedAt,
+		})
+	}
+	return status, nil
+}
+
+// run executes a migration and records it in schema_migrations in one transaction
+func (m *Migrator) run(stmt, track string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// rollback is a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, stmt); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, track, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applied creates schema_migrations if needed and returns the applied versions with the time they ran
+func (m *Migrator) applied() (map[string]time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `create table if not exists schema_migrations (
+		version varchar(14) primary key,
+		applied_at timestamp not null
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.adoptSoda(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]time.Time)
+	for rows.Next() {
+		var version string
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// adoptSoda copies the versions from soda's schema_migration table the first time we run against
+// a database that was migrated with soda, so those migrations are not applied twice
+func (m *Migrator) adoptSoda(ctx context.Context) error {
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(*) from schema_migrations`).Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select version from schema_migration`)
+	if err != nil {
+		// no soda table, nothing to adopt
+		return nil
+	}
+	defer rows.Close()
+
+	var versions []string
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		versions = append(versions, version)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, version := range versions {
+		_, err := m.DB.ExecContext(ctx, `insert into schema_migrations (version, applied_at) values ($1, $2)`, version, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
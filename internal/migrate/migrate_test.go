@@ -0,0 +1,123 @@
+package migrate
+
+import (
+	"database/sql"
+	"testing"
+	"testing/fstest"
+
+	"github.com/tsawler/bookings-app/migrations"
+	_ "modernc.org/sqlite"
+)
+
+func openDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection to :memory: is a new database
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+var testFS = fstest.MapFS{
+	"20210101000000_create_things.sqlite.up.sql":        {Data: []byte("create table things (id integer primary key);")},
+	"20210101000000_create_things.sqlite.down.sql":      {Data: []byte("drop table things;")},
+	"20210102000000_add_name.sqlite.up.sql":             {Data: []byte("alter table things add column name text;")},
+	"20210102000000_add_name.sqlite.down.sql":           {Data: []byte("alter table things drop column name;")},
+	"20210101000000_create_things.postgres.up.sql":      {Data: []byte("this is not for sqlite")},
+	"20210103000000_other_dialect_only.postgres.up.sql": {Data: []byte("this is not for sqlite")},
+	"readme.md": {Data: []byte("not a migration")},
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(testFS, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("expected 2 sqlite migrations, got %d", len(migrations))
+	}
+	if migrations[0].Version != "20210101000000" || migrations[1].Name != "add_name" {
+		t.Errorf("migrations loaded in the wrong order: %+v", migrations)
+	}
+}
+
+func TestMigrator_UpDownStatus(t *testing.T) {
+	db := openDB(t)
+	m, err := New(db, testFS, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ran, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 2 {
+		t.Errorf("expected 2 migrations to run, got %d", len(ran))
+	}
+	if _, err := db.Exec("insert into things (name) values ('a')"); err != nil {
+		t.Errorf("schema was not migrated: %s", err)
+	}
+
+	ran, err = m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected nothing to run the second time, got %d", len(ran))
+	}
+
+	ran, err = m.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 1 || ran[0].Name != "add_name" {
+		t.Errorf("expected add_name to be rolled back, got %+v", ran)
+	}
+
+	status, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status[0].Applied || status[1].Applied {
+		t.Errorf("wrong status after rolling back one migration: %+v", status)
+	}
+}
+
+func TestMigrator_AdoptSoda(t *testing.T) {
+	db := openDB(t)
+	_, err := db.Exec(`create table schema_migration (version varchar(14) not null);
+		insert into schema_migration (version) values ('20210101000000');
+		create table things (id integer primary key);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ := New(db, testFS, "sqlite")
+	ran, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 1 || ran[0].Version != "20210102000000" {
+		t.Errorf("expected only the migration soda did not run, got %+v", ran)
+	}
+}
+
+func TestEmbeddedSQLiteMigrations(t *testing.T) {
+	db := openDB(t)
+	m, err := New(db, migrations.FS, "sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Down(len(m.Migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+// EmailData is the data for one kind of email, it knows which template renders it
+type EmailData interface {
+	// EmailTemplate is the template name without extension, e.g. "reservation-confirmation"
+	// is rendered from reservation-confirmation.email.tmpl and reservation-confirmation.text.tmpl
+	EmailTemplate() string
+}
+
+// ReservationConfirmationEmail is sent to the guest after a reservation is made
+type ReservationConfirmationEmail struct {
+	Reservation Reservation
+	Link        string // where the guest can view, change or cancel the reservation
+	Quote       Quote  // the price, night by night
+}
+
+// EmailTemplate returns the template name
+func (ReservationConfirmationEmail) EmailTemplate() string {
+	return "reservation-confirmation"
+}
+
+// ReservationNotificationEmail tells the owner a room was booked
+type ReservationNotificationEmail struct {
+	Reservation Reservation
+}
+
+// EmailTemplate returns the template name
+func (ReservationNotificationEmail) EmailTemplate() string {
+	return "reservation-notification"
+}
+
+// ReservationChangedEmail tells the guest the new dates of their reservation
+type ReservationChangedEmail struct {
+	Reservation Reservation
+	Link        string // a new link, the old one expires with the old dates
+	Quote       Quote  // the price for the new dates
+}
+
+// EmailTemplate returns the template name
+func (ReservationChangedEmail) EmailTemplate() string {
+	return "reservation-changed"
+}
+
+// ReservationCancelledEmail confirms to the guest that their reservation is cancelled
+type ReservationCancelledEmail struct {
+	Reservation Reservation
+}
+
+// EmailTemplate returns the template name
+func (ReservationCancelledEmail) EmailTemplate() string {
+	return "reservation-cancelled"
+}
+
+// GuestChangeNotificationEmail tells the owner a guest changed or cancelled their reservation
+type GuestChangeNotificationEmail struct {
+	Reservation Reservation
+	Previous    Reservation // the reservation before the change
+	Cancelled   bool
+}
+
+// EmailTemplate returns the template name
+func (GuestChangeNotificationEmail) EmailTemplate() string {
+	return "guest-change-notification"
+}
@@ -18,12 +18,108 @@ type User struct {
 
 // Room is the room model
 type Room struct {
+	ID          int
+	RoomName    string
+	Slug        string // the room page is /rooms/<slug>
+	Description string
+	Capacity    int // how many guests can stay
+	Amenities   []string
+	SortOrder   int       // rooms are listed by this, then by name
+	ArchivedAt  time.Time // zero unless the room is archived, then it can't be found or booked
+	ICalToken   string    // secret part of the calendar feed url, empty if the room has no feed
+	// rates in cents, a WeekendRate of 0 means the NightlyRate applies on weekends too
+	NightlyRate int
+	WeekendRate int
+	Rules       StayRules
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// Archived tells if the room is taken off the site
+func (r Room) Archived() bool {
+	return !r.ArchivedAt.IsZero()
+}
+
+// AllDays is the ArrivalDays of a room guests can arrive at on any day of the week
+const AllDays = 1<<7 - 1
+
+// StayRules limit the stays that can be booked in a room, the stayrules package checks them. 0 means no limit
+type StayRules struct {
+	MinNights   int // at least 1
+	MaxNights   int
+	ArrivalDays int // a bit for every time.Weekday guests can arrive on, 1<<time.Sunday and so on
+	LeadDays    int // how many days before arrival a stay has to be booked
+	WindowDays  int // how many days ahead of arrival a stay can be booked
+}
+
+// DefaultStayRules let every stay be booked, rooms start out with them
+var DefaultStayRules = StayRules{MinNights: 1, ArrivalDays: AllDays}
+
+// ArrivesOn tells if guests can arrive on day
+func (s StayRules) ArrivesOn(day time.Weekday) bool {
+	return s.ArrivalDays&(1<<day) != 0
+}
+
+// ClosedDate closes the days from FirstDate up to and including LastDate of a room to arrivals, departures or both
+type ClosedDate struct {
+	ID                int
+	RoomID            int
+	FirstDate         time.Time
+	LastDate          time.Time
+	ClosedToArrival   bool
+	ClosedToDeparture bool
+	Note              string // why, only shown in the admin
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Room              Room
+}
+
+// Covers tells if day is one of the closed days
+func (c ClosedDate) Covers(day time.Time) bool {
+	return !day.Before(c.FirstDate) && !day.After(c.LastDate)
+}
+
+// RoomPhoto is an uploaded photo of a room. Original, Medium and Thumbnail are the names
+// of its files in the upload storage
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	Original  string
+	Medium    string
+	Thumbnail string
+	SortOrder int // the first photo is the one shown with the room in lists
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// SeasonalRate overrides the rates of a room from FirstNight up to and including LastNight
+type SeasonalRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	FirstNight  time.Time
+	LastNight   time.Time
+	NightlyRate int
+	WeekendRate int // 0 means the NightlyRate of the season applies on weekends too
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// NightPrice is what one night of a stay costs
+type NightPrice struct {
+	Date    time.Time
+	Rate    int    // in cents
+	Weekend bool   // a friday or saturday night
+	Season  string // the name of the seasonal rate used, empty for the room's own rates
+}
+
+// Quote is the price of a stay, night by night
+type Quote struct {
+	Nights []NightPrice
+	Total  int // in cents
+}
+
 // Restrictions is the restriction model
 type Restriction struct {
 	ID              int
@@ -45,17 +141,76 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Processed int
+	Status    string
+	Price     int // the total in cents, as quoted when booked
+	Adults    int // at least one, together with Children no more than the Capacity of the room
+	Children  int
+}
+
+// Guests is the number of people staying
+func (r Reservation) Guests() int {
+	return r.Adults + r.Children
+}
+
+// the statuses of a reservation, from booked to gone home. Transitions lists the allowed changes
+const (
+	StatusPending    = "pending" // booked, not looked at by the owner yet
+	StatusConfirmed  = "confirmed"
+	StatusCheckedIn  = "checked-in"
+	StatusCheckedOut = "checked-out"
+	StatusCancelled  = "cancelled" // the room is free again, the reservation is kept for the history
+	StatusNoShow     = "no-show"
+)
+
+// Statuses is every status, in the order of the lifecycle
+var Statuses = []string{StatusPending, StatusConfirmed, StatusCheckedIn, StatusCheckedOut, StatusCancelled, StatusNoShow}
+
+// Transitions maps a status to the statuses it can change to, checked-out, cancelled and no-show are final
+var Transitions = map[string][]string{
+	StatusPending:   {StatusConfirmed, StatusCancelled},
+	StatusConfirmed: {StatusCheckedIn, StatusCancelled, StatusNoShow},
+	StatusCheckedIn: {StatusCheckedOut},
+}
+
+// CanTransition tells if a reservation can go from status from to status to
+func CanTransition(from, to string) bool {
+	for _, s := range Transitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Active tells if the reservation still holds its room and can be changed or cancelled
+func (r Reservation) Active() bool {
+	return r.Status == StatusPending || r.Status == StatusConfirmed
+}
+
+// NextStatuses returns the statuses the reservation can change to
+func (r Reservation) NextStatuses() []string {
+	return Transitions[r.Status]
 }
 
 // RoomRestrictions is the room restriction model
+// the rows of the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionExternal    = 3 // imported from the calendar of another booking site
+	RestrictionHold        = 4 // keeps the room for a guest filling in the reservation form, until ExpiresAt
+)
+
 type RoomRestriction struct {
 	ID            int
 	StartDate     time.Time
 	EndDate       time.Time
 	RoomID        int
-	ReservationID int
+	ReservationID int // 0 when the restriction is not a reservation, e.g. an owner block
 	RestrictionID int
+	ICalSourceID  int    // the source an external block was imported from
+	ICalUID       string // the uid of its event in the source
+	ExpiresAt     time.Time // when a hold ends, zero for the others
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -63,11 +218,97 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// ICalSource is the calendar of a room on another booking site, read from URL or else from
+// the uploaded Content. its events are imported as external blocks
+type ICalSource struct {
+	ID           int
+	RoomID       int
+	Name         string
+	URL          string
+	Content      string
+	LastSyncedAt time.Time
+	LastError    string // what went wrong in the last sync, empty if it went well
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Room         Room
+}
+
 //hold email message
 type MailData struct {
 	To string
 	From string
 	Subject string
 	Content string //HTML format
-	Template string
-}
\ No newline at end of file
+	PlainContent string //plain text alternative, may be empty
+}
+
+// the states of a message in the mail outbox
+const (
+	OutboxPending = "pending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// OutboxMessage is an email waiting in (or done with) the mail outbox
+type OutboxMessage struct {
+	ID            int
+	Mail          MailData
+	Status        string
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// the scopes an api key can have
+const (
+	ScopeReadReservations  = "read:reservations"
+	ScopeWriteReservations = "write:reservations"
+	ScopeReadBlocks        = "read:blocks"
+	ScopeWriteBlocks       = "write:blocks"
+)
+
+// APIKeyScopes lists every scope, in the order the admin page shows them
+var APIKeyScopes = []string{ScopeReadReservations, ScopeWriteReservations, ScopeReadBlocks, ScopeWriteBlocks}
+
+// ValidScope tells if scope is one of APIKeyScopes
+func ValidScope(scope string) bool {
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKey lets a script or partner system act for a user, only the hash of the key is stored
+type APIKey struct {
+	ID         int
+	UserID     int
+	Name       string
+	Prefix     string // the start of the key, shown in the admin and used to find it
+	Hash       string
+	Scopes     []string
+	LastUsedAt time.Time // zero if never used
+	RevokedAt  time.Time // zero if still valid
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
+// HasScope tells if the key may do scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked tells if the key was revoked
+func (k APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
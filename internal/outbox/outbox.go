@@ -0,0 +1,124 @@
+// Package outbox sends the mail queued in the database, retrying failed messages with exponential backoff
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/mailer"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// Worker polls the outbox and hands the due messages to the mailer
+type Worker struct {
+	DB          repository.DatabaseRepo
+	Mailer      mailer.Mailer
+	InfoLog     *log.Logger
+	ErrorLog    *log.Logger
+	Interval    time.Duration // how often to look for due messages
+	BatchSize   int           // messages per look
+	MaxAttempts int           // a message is marked failed after this many attempts
+	BaseDelay   time.Duration // wait after the first failure, doubled after each one
+	MaxDelay    time.Duration // the longest wait between two attempts
+}
+
+// New creates a worker with the default schedule: retries after 30s, 1m, 2m, ... up to an hour, 8 attempts in total
+func New(db repository.DatabaseRepo, m mailer.Mailer, infoLog, errorLog *log.Logger) *Worker {
+	return &Worker{
+		DB:          db,
+		Mailer:      m,
+		InfoLog:     infoLog,
+		ErrorLog:    errorLog,
+		Interval:    5 * time.Second,
+		BatchSize:   20,
+		MaxAttempts: 8,
+		BaseDelay:   30 * time.Second,
+		MaxDelay:    time.Hour,
+	}
+}
+
+// Run sends the due messages every Interval until ctx is cancelled, then makes one last pass
+// so nothing that is due is left behind. The returned channel is closed when it is done.
+func (w *Worker) Run(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(w.Interval)
+		defer ticker.Stop()
+
+		for {
+			w.sendDue()
+			select {
+			case <-ctx.Done():
+				w.sendDue()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// sendDue sends batches until no due message is left
+func (w *Worker) sendDue() {
+	for {
+		sent, err := w.SendPending(time.Now().UTC())
+		if err != nil {
+			w.ErrorLog.Println("outbox:", err)
+			return
+		}
+		if sent < w.BatchSize {
+			return
+		}
+	}
+}
+
+// SendPending tries every message due at now (in UTC, like the stored next_attempt_at)
+// and records the outcome. It returns how many messages it tried.
+func (w *Worker) SendPending(now time.Time) (int, error) {
+	messages, err := w.DB.PendingMail(now, w.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, msg := range messages {
+		msg.Attempts++
+		err := w.Mailer.Send(msg.Mail)
+		switch {
+		case err == nil:
+			msg.Status = models.OutboxSent
+			msg.SentAt = now
+			msg.LastError = ""
+			w.InfoLog.Printf("outbox: message %d sent to %s", msg.ID, msg.Mail.To)
+		case msg.Attempts >= w.MaxAttempts:
+			msg.Status = models.OutboxFailed
+			msg.LastError = err.Error()
+			w.ErrorLog.Printf("outbox: giving up on message %d to %s after %d attempts: %s", msg.ID, msg.Mail.To, msg.Attempts, err)
+		default:
+			msg.LastError = err.Error()
+			msg.NextAttemptAt = now.Add(w.Backoff(msg.Attempts))
+			w.ErrorLog.Printf("outbox: message %d to %s failed, retrying at %s: %s", msg.ID, msg.Mail.To, msg.NextAttemptAt.Format(time.RFC3339), err)
+		}
+
+		err = w.DB.UpdateOutboxMessage(msg)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(messages), nil
+}
+
+// Backoff returns how long to wait after the given number of failed attempts
+func (w *Worker) Backoff(attempts int) time.Duration {
+	delay := w.BaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= w.MaxDelay {
+			return w.MaxDelay
+		}
+	}
+	return delay
+}
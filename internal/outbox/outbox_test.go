@@ -0,0 +1,94 @@
+package outbox
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/mailer"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+func newWorker(m mailer.Mailer) *Worker {
+	discard := log.New(io.Discard, "", 0)
+	return New(dbrepo.NewMemoryRepo(&config.AppConfig{}), m, discard, discard)
+}
+
+func TestWorker_Backoff(t *testing.T) {
+	w := newWorker(nil)
+
+	var tests = []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+
+	for _, e := range tests {
+		if got := w.Backoff(e.attempts); got != e.expected {
+			t.Errorf("backoff after %d attempts: expected %s, got %s", e.attempts, e.expected, got)
+		}
+	}
+}
+
+func TestWorker_SendPending(t *testing.T) {
+	recorder := mailer.NewRecorder()
+	fail := true
+	w := newWorker(mailer.MailerFunc(func(m models.MailData) error {
+		if fail {
+			return errors.New("smtp is down")
+		}
+		return recorder.Send(m)
+	}))
+	w.DB.QueueMail(models.MailData{To: "guest@example.com", Subject: "Reservation Confirmation"})
+
+	now := time.Now().UTC()
+	n, err := w.SendPending(now)
+	if err != nil || n != 1 {
+		t.Fatalf("expected 1 message tried, got %d (%v)", n, err)
+	}
+
+	// not due again until the backoff has passed
+	n, _ = w.SendPending(now.Add(10 * time.Second))
+	if n != 0 {
+		t.Errorf("message retried before its backoff, %d tried", n)
+	}
+
+	fail = false
+	n, _ = w.SendPending(now.Add(w.BaseDelay))
+	sent := recorder.Messages()
+	if n != 1 || len(sent) != 1 || sent[0].To != "guest@example.com" {
+		t.Fatalf("expected the message to be sent on retry, tried %d, sent %v", n, sent)
+	}
+
+	n, _ = w.SendPending(now.Add(time.Hour))
+	if n != 0 {
+		t.Errorf("sent message was tried again")
+	}
+}
+
+func TestWorker_GivesUp(t *testing.T) {
+	w := newWorker(mailer.MailerFunc(func(m models.MailData) error {
+		return errors.New("mailbox does not exist")
+	}))
+	w.MaxAttempts = 2
+	w.DB.QueueMail(models.MailData{To: "nobody@example.com"})
+
+	now := time.Now().UTC()
+	w.SendPending(now)
+	w.SendPending(now.Add(time.Hour))
+
+	n, _ := w.SendPending(now.Add(48 * time.Hour))
+	if n != 0 {
+		t.Errorf("failed message is still pending")
+	}
+}
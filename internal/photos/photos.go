@@ -0,0 +1,181 @@
+// Package photos checks uploaded room photos and makes the smaller versions of them the site shows
+package photos
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // registers the decoders of the accepted types
+	"io"
+	"net/http"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/storage"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxSize is the largest upload accepted, in bytes
+const MaxSize = 10 << 20
+
+// maxPixels keeps a small file that claims to be a huge image from using up the memory
+const maxPixels = 50_000_000
+
+var (
+	ErrTooLarge        = errors.New("photo is larger than 10 MB")
+	ErrUnsupportedType = errors.New("photo is not a JPEG, PNG or WebP image")
+	ErrInvalidImage    = errors.New("photo can't be read")
+)
+
+// the types accepted, by the content type sniffed from the upload, and the extension they are stored with
+var extensions = map[string]string{
+	"image/jpeg": "jpg",
+	"image/png":  "png",
+	"image/webp": "webp",
+}
+
+// Variant is a smaller version of a photo, it fits in Width by Height
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+}
+
+var (
+	Thumbnail = Variant{Name: "thumbnail", Width: 320, Height: 240}
+	Medium    = Variant{Name: "medium", Width: 1024, Height: 768}
+)
+
+// Service keeps room photos and their variants in a storage
+type Service struct {
+	Storage storage.Storage
+}
+
+// New creates a photo service
+func New(s storage.Storage) *Service {
+	return &Service{Storage: s}
+}
+
+// Save checks the photo r reads, stores it with its variants and returns the photo for roomID,
+// to be inserted in the database. Files it stored are removed again if it fails
+func (s *Service) Save(roomID int, r io.Reader) (models.RoomPhoto, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxSize+1))
+	if err != nil {
+		return models.RoomPhoto{}, err
+	}
+	if len(data) > MaxSize {
+		return models.RoomPhoto{}, ErrTooLarge
+	}
+	ext, ok := extensions[http.DetectContentType(data)]
+	if !ok {
+		return models.RoomPhoto{}, ErrUnsupportedType
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return models.RoomPhoto{}, ErrInvalidImage
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return models.RoomPhoto{}, ErrTooLarge
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return models.RoomPhoto{}, ErrInvalidImage
+	}
+
+	medium, err := encode(Resize(img, Medium))
+	if err != nil {
+		return models.RoomPhoto{}, err
+	}
+	thumbnail, err := encode(Resize(img, Thumbnail))
+	if err != nil {
+		return models.RoomPhoto{}, err
+	}
+
+	base, err := randomName()
+	if err != nil {
+		return models.RoomPhoto{}, err
+	}
+	base = fmt.Sprintf("rooms/%d/%s", roomID, base)
+	p := models.RoomPhoto{
+		RoomID:    roomID,
+		Original:  base + "." + ext,
+		Medium:    base + "-" + Medium.Name + ".jpg",
+		Thumbnail: base + "-" + Thumbnail.Name + ".jpg",
+	}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{p.Original, data},
+		{p.Medium, medium},
+		{p.Thumbnail, thumbnail},
+	}
+	for i, f := range files {
+		err = s.Storage.Save(f.name, bytes.NewReader(f.data))
+		if err != nil {
+			for _, saved := range files[:i] {
+				s.Storage.Delete(saved.name)
+			}
+			return models.RoomPhoto{}, err
+		}
+	}
+	return p, nil
+}
+
+// Delete removes the files of a photo, all it can and returns the first error
+func (s *Service) Delete(p models.RoomPhoto) error {
+	var first error
+	for _, name := range []string{p.Original, p.Medium, p.Thumbnail} {
+		if err := s.Storage.Delete(name); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Resize scales img down to fit in v keeping its proportions, smaller images keep their size.
+// Transparent parts become white, the variants are jpegs
+func Resize(img image.Image, v Variant) image.Image {
+	b := img.Bounds()
+	w, h := Fit(b.Dx(), b.Dy(), v.Width, v.Height)
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// Fit returns the size of a w by h image scaled down to fit in maxW by maxH
+func Fit(w, h, maxW, maxH int) (int, int) {
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	// compare w/maxW with h/maxH without rounding
+	if w*maxH >= h*maxW {
+		return maxW, max(1, h*maxW/w)
+	}
+	return max(1, w*maxH/h), maxH
+}
+
+func encode(img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	return buf.Bytes(), err
+}
+
+// randomName keeps uploads from overwriting each other and their urls from being guessed
+func randomName() (string, error) {
+	b := make([]byte, 12)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
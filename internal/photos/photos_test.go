@@ -0,0 +1,109 @@
+package photos
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tsawler/bookings-app/internal/storage"
+)
+
+func pngOf(w, h int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		img.Set(x, 0, color.NRGBA{R: 200, A: 255})
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestFit(t *testing.T) {
+	var tests = []struct {
+		w, h, maxW, maxH int
+		ew, eh           int
+	}{
+		{4000, 3000, 1024, 768, 1024, 768},
+		{4000, 2000, 1024, 768, 1024, 512},
+		{2000, 4000, 1024, 768, 384, 768},
+		{800, 600, 1024, 768, 800, 600},
+		{5000, 1, 320, 240, 320, 1},
+	}
+	for _, e := range tests {
+		w, h := Fit(e.w, e.h, e.maxW, e.maxH)
+		if w != e.ew || h != e.eh {
+			t.Errorf("%dx%d in %dx%d: expected %dx%d, got %dx%d", e.w, e.h, e.maxW, e.maxH, e.ew, e.eh, w, h)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	dir := t.TempDir()
+	s := New(storage.NewLocal(dir, "/uploads"))
+
+	p, err := s.Save(3, bytes.NewReader(pngOf(2000, 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.RoomID != 3 || !strings.HasPrefix(p.Original, "rooms/3/") || !strings.HasSuffix(p.Original, ".png") {
+		t.Errorf("unexpected photo %+v", p)
+	}
+
+	sizes := map[string][2]int{
+		p.Medium:    {1024, 512},
+		p.Thumbnail: {320, 160},
+	}
+	for name, size := range sizes {
+		f, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := jpeg.DecodeConfig(f)
+		f.Close()
+		if err != nil || cfg.Width != size[0] || cfg.Height != size[1] {
+			t.Errorf("%s: expected a %dx%d jpeg, got %dx%d, %v", name, size[0], size[1], cfg.Width, cfg.Height, err)
+		}
+	}
+
+	err = s.Delete(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := os.ReadDir(filepath.Join(dir, "rooms", "3"))
+	if len(entries) != 0 {
+		t.Errorf("expected the files to be deleted, %d left", len(entries))
+	}
+}
+
+func TestSave_Invalid(t *testing.T) {
+	dir := t.TempDir()
+	s := New(storage.NewLocal(dir, "/uploads"))
+
+	var tests = []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"text", []byte("not a photo at all"), ErrUnsupportedType},
+		{"gif", []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;"), ErrUnsupportedType},
+		{"truncated png", pngOf(10, 10)[:40], ErrInvalidImage},
+		{"too large", append(pngOf(10, 10), make([]byte, MaxSize)...), ErrTooLarge},
+	}
+	for _, e := range tests {
+		_, err := s.Save(1, bytes.NewReader(e.data))
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "rooms")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected nothing to be stored, got %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+// Package pricing works out what a stay costs from the rates of its room. Amounts are in cents
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// ErrInvalidAmount is returned by ParseAmount for anything that isn't a positive amount of dollars and cents
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Service quotes stays with the rates in the database
+type Service struct {
+	DB repository.DatabaseRepo
+}
+
+// New creates a pricing service
+func New(db repository.DatabaseRepo) *Service {
+	return &Service{DB: db}
+}
+
+// Quote returns the price of a stay in room roomID from start to end, end is the day of departure
+func (s *Service) Quote(roomID int, start, end time.Time) (models.Quote, error) {
+	room, err := s.DB.GetRoomByID(roomID)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	seasons, err := s.DB.SeasonalRatesForRoom(roomID, start, end)
+	if err != nil {
+		return models.Quote{}, err
+	}
+	return Calculate(room, seasons, start, end), nil
+}
+
+// Calculate prices every night from start up to end. A night in a season uses the rates of the season,
+// where seasons overlap the one starting last wins. Friday and saturday nights use the weekend rate if there is one
+func Calculate(room models.Room, seasons []models.SeasonalRate, start, end time.Time) models.Quote {
+	var q models.Quote
+	for night := start; night.Before(end); night = night.AddDate(0, 0, 1) {
+		p := models.NightPrice{
+			Date:    night,
+			Weekend: night.Weekday() == time.Friday || night.Weekday() == time.Saturday,
+		}
+
+		nightly, weekend := room.NightlyRate, room.WeekendRate
+		if s, ok := seasonFor(seasons, night); ok {
+			nightly, weekend = s.NightlyRate, s.WeekendRate
+			p.Season = s.Name
+		}
+		p.Rate = nightly
+		if p.Weekend && weekend > 0 {
+			p.Rate = weekend
+		}
+
+		q.Nights = append(q.Nights, p)
+		q.Total += p.Rate
+	}
+	return q
+}
+
+// seasonFor returns the season night falls in, the latest starting one (or the newest of those) if there are more
+func seasonFor(seasons []models.SeasonalRate, night time.Time) (models.SeasonalRate, bool) {
+	var found models.SeasonalRate
+	ok := false
+	for _, s := range seasons {
+		if night.Before(s.FirstNight) || night.After(s.LastNight) {
+			continue
+		}
+		if !ok || s.FirstNight.After(found.FirstNight) || (s.FirstNight.Equal(found.FirstNight) && s.ID > found.ID) {
+			found, ok = s, true
+		}
+	}
+	return found, ok
+}
+
+// FormatAmount shows cents as dollars, 123456 is "$1,234.56"
+func FormatAmount(cents int) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	dollars := strconv.Itoa(cents / 100)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+	return fmt.Sprintf("%s$%s.%02d", sign, dollars, cents%100)
+}
+
+// ParseAmount reads dollars as typed in a form, like "89", "89.5" or "$1,089.50", into cents
+func ParseAmount(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	s = strings.ReplaceAll(s, ",", "")
+	dollars, fraction, hasFraction := strings.Cut(s, ".")
+	if dollars == "" && fraction == "" {
+		return 0, ErrInvalidAmount
+	}
+	if dollars == "" {
+		dollars = "0"
+	}
+	if hasFraction && (fraction == "" || len(fraction) > 2) {
+		return 0, ErrInvalidAmount
+	}
+	for len(fraction) < 2 {
+		fraction += "0"
+	}
+
+	d, err := strconv.Atoi(dollars)
+	if err != nil || d < 0 || strings.HasPrefix(dollars, "+") {
+		return 0, ErrInvalidAmount
+	}
+	c, err := strconv.Atoi(fraction)
+	if err != nil || c < 0 || strings.HasPrefix(fraction, "+") {
+		return 0, ErrInvalidAmount
+	}
+	// a million dollars a night is surely a typo
+	if d >= 1000000 {
+		return 0, ErrInvalidAmount
+	}
+	return d*100 + c, nil
+}
@@ -0,0 +1,119 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestCalculate(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000, WeekendRate: 12000}
+	seasons := []models.SeasonalRate{
+		{ID: 1, Name: "Summer", FirstNight: date("2050-07-01"), LastNight: date("2050-08-31"), NightlyRate: 15000},
+		{ID: 2, Name: "Festival", FirstNight: date("2050-07-14"), LastNight: date("2050-07-15"), NightlyRate: 20000, WeekendRate: 25000},
+	}
+
+	var tests = []struct {
+		name    string
+		start   string
+		end     string
+		rates   []int
+		seasons []string
+	}{
+		// 2050-06-27 is a monday
+		{"weekdays", "2050-06-27", "2050-06-29", []int{10000, 10000}, []string{"", ""}},
+		{"over the weekend", "2050-06-23", "2050-06-27", []int{10000, 12000, 12000, 10000}, []string{"", "", "", ""}},
+		{"into the summer", "2050-06-29", "2050-07-02", []int{10000, 10000, 15000}, []string{"", "", "Summer"}},
+		{"festival in the summer", "2050-07-13", "2050-07-17", []int{15000, 20000, 25000, 15000}, []string{"Summer", "Festival", "Festival", "Summer"}},
+		{"last night of the summer", "2050-08-31", "2050-09-02", []int{15000, 10000}, []string{"Summer", ""}},
+		{"no nights", "2050-06-27", "2050-06-27", nil, nil},
+	}
+
+	for _, e := range tests {
+		q := Calculate(room, seasons, date(e.start), date(e.end))
+		if len(q.Nights) != len(e.rates) {
+			t.Errorf("%s: expected %d nights, got %d", e.name, len(e.rates), len(q.Nights))
+			continue
+		}
+		total := 0
+		for i, n := range q.Nights {
+			if n.Rate != e.rates[i] || n.Season != e.seasons[i] {
+				t.Errorf("%s: night %d: expected %d (%q), got %d (%q)", e.name, i, e.rates[i], e.seasons[i], n.Rate, n.Season)
+			}
+			total += e.rates[i]
+		}
+		if q.Total != total {
+			t.Errorf("%s: expected a total of %d, got %d", e.name, total, q.Total)
+		}
+	}
+}
+
+func TestCalculate_NoWeekendRate(t *testing.T) {
+	room := models.Room{ID: 1, NightlyRate: 10000}
+	// friday and saturday
+	q := Calculate(room, nil, date("2050-06-24"), date("2050-06-26"))
+	if q.Total != 20000 || !q.Nights[0].Weekend || !q.Nights[1].Weekend {
+		t.Errorf("expected two weekend nights at the nightly rate, got %+v", q)
+	}
+}
+
+func TestSeasonFor_Tie(t *testing.T) {
+	seasons := []models.SeasonalRate{
+		{ID: 3, Name: "Newer", FirstNight: date("2050-07-01"), LastNight: date("2050-07-10")},
+		{ID: 2, Name: "Older", FirstNight: date("2050-07-01"), LastNight: date("2050-07-20")},
+	}
+	s, ok := seasonFor(seasons, date("2050-07-05"))
+	if !ok || s.Name != "Newer" {
+		t.Errorf("expected the newer season to win, got %q", s.Name)
+	}
+	s, ok = seasonFor(seasons, date("2050-07-15"))
+	if !ok || s.Name != "Older" {
+		t.Errorf("expected the older season after the newer one ends, got %q", s.Name)
+	}
+}
+
+func TestFormatAmount(t *testing.T) {
+	var tests = map[int]string{
+		0:         "$0.00",
+		5:         "$0.05",
+		8900:      "$89.00",
+		123456:    "$1,234.56",
+		100000000: "$1,000,000.00",
+		-250:      "-$2.50",
+	}
+	for cents, expected := range tests {
+		if got := FormatAmount(cents); got != expected {
+			t.Errorf("%d: expected %s, got %s", cents, expected, got)
+		}
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	var valid = map[string]int{
+		"89":        8900,
+		"89.5":      8950,
+		"89.50":     8950,
+		"$1,089.50": 108950,
+		" 0 ":       0,
+		".99":       99,
+	}
+	for s, expected := range valid {
+		got, err := ParseAmount(s)
+		if err != nil || got != expected {
+			t.Errorf("%q: expected %d, got %d, %v", s, expected, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "$", "abc", "-5", "89.", "89.555", "89.-5", "+89", "1000000"} {
+		if _, err := ParseAmount(s); !errors.Is(err, ErrInvalidAmount) {
+			t.Errorf("%q: expected ErrInvalidAmount, got %v", s, err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"path/filepath"
+	texttemplate "text/template"
+
+	"github.com/tsawler/bookings-app/internal/models"
+)
+
+var pathToEmailTemplates = "./email-template"
+
+// the text templates get the same helpers as the html ones
+var textFunctions = texttemplate.FuncMap(functions)
+
+// Email renders the html and plain text versions of an email
+func Email(data models.EmailData) (string, string, error) {
+	var htmlCache map[string]*template.Template
+	var textCache map[string]*texttemplate.Template
+
+	if app.UseCache {
+		htmlCache, textCache = app.EmailTemplateCache, app.EmailTextTemplateCache
+	} else {
+		var err error
+		htmlCache, textCache, err = CreateEmailTemplateCache()
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	name := data.EmailTemplate()
+
+	t, ok := htmlCache[name+".email.tmpl"]
+	if !ok {
+		return "", "", fmt.Errorf("email template %s not found", name)
+	}
+	html := new(bytes.Buffer)
+	if err := t.Execute(html, data); err != nil {
+		return "", "", err
+	}
+
+	// the plain text version is optional
+	var text string
+	if tt, ok := textCache[name+".text.tmpl"]; ok {
+		buf := new(bytes.Buffer)
+		if err := tt.Execute(buf, data); err != nil {
+			return "", "", err
+		}
+		text = buf.String()
+	}
+
+	return html.String(), text, nil
+}
+
+// CreateEmailTemplateCache creates the email template caches, html emails are *.email.tmpl
+// using the *.layout.tmpl files and their plain text alternatives *.text.tmpl
+func CreateEmailTemplateCache() (map[string]*template.Template, map[string]*texttemplate.Template, error) {
+
+	htmlCache := map[string]*template.Template{}
+	textCache := map[string]*texttemplate.Template{}
+
+	emails, err := filepath.Glob(filepath.Join(pathToEmailTemplates, "*.email.tmpl"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	layouts, err := filepath.Glob(filepath.Join(pathToEmailTemplates, "*.layout.tmpl"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	for _, email := range emails {
+		name := filepath.Base(email)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(email)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+
+		if len(layouts) > 0 {
+			ts, err = ts.ParseFiles(layouts...)
+			if err != nil {
+				return htmlCache, textCache, err
+			}
+		}
+
+		htmlCache[name] = ts
+	}
+
+	texts, err := filepath.Glob(filepath.Join(pathToEmailTemplates, "*.text.tmpl"))
+	if err != nil {
+		return htmlCache, textCache, err
+	}
+
+	for _, text := range texts {
+		name := filepath.Base(text)
+		ts, err := texttemplate.New(name).Funcs(textFunctions).ParseFiles(text)
+		if err != nil {
+			return htmlCache, textCache, err
+		}
+		textCache[name] = ts
+	}
+
+	return htmlCache, textCache, nil
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/justinas/nosurf"
 	"github.com/tsawler/bookings-app/internal/config"
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/pricing"
 )
 
 var functions = template.FuncMap{
@@ -19,6 +20,8 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate": Iterate,
 	"add":Add,
+	"money": pricing.FormatAmount,
+	"upload": Upload,
 }
 
 var app *config.AppConfig
@@ -50,6 +53,11 @@ func Iterate(count int) []int {
 func Add(a, b int) int {
 	return a + b
 }
+
+// Upload is the url of an uploaded file, like a room photo
+func Upload(name string) string {
+	return app.Storage.URL(name)
+}
 // AddDefaultData adds data for all templates
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
@@ -1,20 +1,196 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgconn"
 
 	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/models"
 	"github.com/tsawler/bookings-app/internal/repository"
 )
 
+// postgres error codes we translate into repository errors
+const (
+	pgSerializationFailure = "40001"
+	pgExclusionViolation   = "23P01"
+	pgUniqueViolation      = "23505"
+)
+
 type postgresDBRepo struct {
 	App *config.AppConfig
-	DB *sql.DB
+	DB  *sql.DB
 }
-//create a new db
+
+// create a new db
 func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
 	return &postgresDBRepo{
 		App: a,
-		DB: conn,
+		DB:  conn,
+	}
+}
+
+// sqliteDBRepo runs the postgres queries against sqlite, they only use sql that both databases understand
+type sqliteDBRepo struct {
+	*postgresDBRepo
+}
+
+// create a new sqlite db
+func NewSQLiteRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+	return &sqliteDBRepo{
+		postgresDBRepo: &postgresDBRepo{
+			App: a,
+			DB:  conn,
+		},
+	}
+}
+
+// memoryDBRepo keeps everything in memory, used by the tests and for running the app without a database
+type memoryDBRepo struct {
+	App              *config.AppConfig
+	mu               sync.Mutex
+	sequences        map[string]int
+	users            []models.User
+	rooms            []models.Room
+	restrictions     []models.Restriction
+	reservations     []models.Reservation
+	roomRestrictions []models.RoomRestriction
+	outbox           []models.OutboxMessage
+	apiKeys          []models.APIKey
+	icalSources      []models.ICalSource
+	seasonalRates    []models.SeasonalRate
+	roomPhotos       []models.RoomPhoto
+	closedDates      []models.ClosedDate
+}
+
+// create a new in-memory db, seeded with the same rooms and restrictions as the migrations
+func NewMemoryRepo(a *config.AppConfig) repository.DatabaseRepo {
+	m := &memoryDBRepo{
+		App:       a,
+		sequences: make(map[string]int),
+	}
+	m.seed()
+	return m
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx, so the same statement can run in or out of a transaction
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// how many times withTx runs a transaction that keeps failing to serialize
+const maxTxAttempts = 3
+
+// withTx runs fn inside a serializable transaction, commits if fn succeeds and rolls back on any error.
+// A serialization failure only means another transaction got in the way, fn runs again in a new
+// transaction, so it must not keep state from an earlier attempt
+func (m *postgresDBRepo) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	var err error
+	for attempt := 1; attempt <= maxTxAttempts; attempt++ {
+		err = m.runTx(ctx, fn)
+		if !isSerializationFailure(err) || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (m *postgresDBRepo) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := m.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		return err
 	}
-}
\ No newline at end of file
+	// rollback is a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == pgSerializationFailure
+}
+
+// translateRestrictionErr turns a violation of the overlap constraint (postgres) or trigger (sqlite)
+// into repository.ErrRoomUnavailable. Serialization failures are retried by withTx, one that is left
+// is not a sign the room is taken and stays as it is
+func translateRestrictionErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	// raised by the sqlite trigger of the same name
+	if strings.Contains(err.Error(), "room_restrictions_no_overlap") {
+		return repository.ErrRoomUnavailable
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if pgErr.Code == pgExclusionViolation {
+			return repository.ErrRoomUnavailable
+		}
+	}
+	return err
+}
+
+// blockPieces returns what is left of an owner block once days are taken out of it, one block
+// for every run of nights still blocked
+func blockPieces(block models.RoomRestriction, days []time.Time) []models.RoomRestriction {
+	removed := make(map[string]bool)
+	for _, d := range days {
+		removed[d.Format("2006-01-02")] = true
+	}
+
+	var pieces []models.RoomRestriction
+	piece := func(start, end time.Time) {
+		pieces = append(pieces, models.RoomRestriction{
+			StartDate:     start,
+			EndDate:       end,
+			RoomID:        block.RoomID,
+			RestrictionID: models.RestrictionOwnerBlock,
+		})
+	}
+	var start time.Time
+	for d := block.StartDate; d.Before(block.EndDate); d = d.AddDate(0, 0, 1) {
+		if !removed[d.Format("2006-01-02")] {
+			if start.IsZero() {
+				start = d
+			}
+			continue
+		}
+		if !start.IsZero() {
+			piece(start, d)
+			start = time.Time{}
+		}
+	}
+	if !start.IsZero() {
+		piece(start, block.EndDate)
+	}
+	return pieces
+}
+
+// translateSlugErr turns a violation of the unique index on rooms.slug into repository.ErrSlugTaken
+func translateSlugErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	// sqlite says "UNIQUE constraint failed: rooms.slug"
+	if strings.Contains(err.Error(), "rooms.slug") {
+		return repository.ErrSlugTaken
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == pgUniqueViolation && pgErr.ConstraintName == "rooms_slug_idx" {
+		return repository.ErrSlugTaken
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package dbrepo
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+func TestTranslateRestrictionErr(t *testing.T) {
+	var tests = []struct {
+		name        string
+		err         error
+		unavailable bool
+	}{
+		{"exclusion", &pgconn.PgError{Code: pgExclusionViolation, ConstraintName: "room_restrictions_no_overlap"}, true},
+		{"wrapped exclusion", fmt.Errorf("insert: %w", &pgconn.PgError{Code: pgExclusionViolation}), true},
+		{"sqlite trigger", errors.New("room_restrictions_no_overlap"), true},
+		{"serialization failure", &pgconn.PgError{Code: pgSerializationFailure}, false},
+		{"unique", &pgconn.PgError{Code: pgUniqueViolation}, false},
+		{"other", errors.New("connection refused"), false},
+	}
+
+	for _, e := range tests {
+		err := translateRestrictionErr(e.err)
+		if errors.Is(err, repository.ErrRoomUnavailable) != e.unavailable {
+			t.Errorf("%s: got %v", e.name, err)
+		}
+	}
+	if translateRestrictionErr(nil) != nil {
+		t.Error("expected nil for nil")
+	}
+}
+
+func TestIsSerializationFailure(t *testing.T) {
+	if !isSerializationFailure(fmt.Errorf("commit: %w", &pgconn.PgError{Code: pgSerializationFailure})) {
+		t.Error("expected a serialization failure")
+	}
+	if isSerializationFailure(&pgconn.PgError{Code: pgExclusionViolation}) || isSerializationFailure(nil) {
+		t.Error("expected no serialization failure")
+	}
+}
@@ -0,0 +1,1342 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// seed adds the rooms and restrictions from the seed migrations, plus an admin user for logging in
+func (m *memoryDBRepo) seed() {
+	now := time.Now()
+
+	m.rooms = append(m.rooms,
+		models.Room{
+			ID:          m.nextID("rooms"),
+			RoomName:    "Quarters",
+			Slug:        "generals-quarters",
+			Description: "Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.",
+			Capacity:    2,
+			Amenities:   []string{"Ocean view", "Queen size bed", "Private bathroom", "Free Wi-Fi"},
+			SortOrder:   1,
+			NightlyRate: 8900,
+			WeekendRate: 9900,
+			Rules:       models.DefaultStayRules,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+		models.Room{
+			ID:          m.nextID("rooms"),
+			RoomName:    "Master",
+			Slug:        "majors-suite",
+			Description: "A suite with room for the whole family, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.",
+			Capacity:    4,
+			Amenities:   []string{"Ocean view", "King size bed and sofa bed", "Kitchenette", "Free Wi-Fi"},
+			SortOrder:   2,
+			NightlyRate: 12900,
+			WeekendRate: 14900,
+			Rules:       models.DefaultStayRules,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		},
+	)
+
+	m.restrictions = append(m.restrictions,
+		models.Restriction{ID: 1, RestrictionName: "Reservation", CreatedAt: now, UpdatedAt: now},
+		models.Restriction{ID: 2, RestrictionName: "Owner Block", CreatedAt: now, UpdatedAt: now},
+		models.Restriction{ID: 3, RestrictionName: "External", CreatedAt: now, UpdatedAt: now},
+		models.Restriction{ID: 4, RestrictionName: "Hold", CreatedAt: now, UpdatedAt: now},
+	)
+
+	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.DefaultCost)
+	m.users = append(m.users, models.User{
+		ID:          m.nextID("users"),
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       "admin@admin.com",
+		Password:    string(hashedPassword),
+		AccessLevel: 3,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+}
+
+// nextID works like the serial id column of table
+func (m *memoryDBRepo) nextID(table string) int {
+	m.sequences[table]++
+	return m.sequences[table]
+}
+
+// overlaps is the same check as "$1 < end_date and $2 > start_date" in the postgres queries
+func overlaps(start, end time.Time, rr models.RoomRestriction) bool {
+	return start.Before(rr.EndDate) && end.After(rr.StartDate)
+}
+
+func (m *memoryDBRepo) AllUsers() bool {
+	return true
+}
+
+// insert a reservation into memory
+func (m *memoryDBRepo) InsertReservation(res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.insertReservation(res), nil
+}
+
+func (m *memoryDBRepo) insertReservation(res models.Reservation) int {
+	res.ID = m.nextID("reservations")
+	res.Status = models.StatusPending // the column default
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	m.reservations = append(m.reservations, res)
+	return res.ID
+}
+
+// insert a room restriction into memory, rejecting overlaps like the exclusion constraint does
+func (m *memoryDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.insertRoomRestriction(res)
+	return err
+}
+
+func (m *memoryDBRepo) insertRoomRestriction(res models.RoomRestriction) (int, error) {
+	if !m.available(res.StartDate, res.EndDate, res.RoomID) {
+		return 0, repository.ErrRoomUnavailable
+	}
+	res.ID = m.nextID("room_restrictions")
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	m.roomRestrictions = append(m.roomRestrictions, res)
+	return res.ID, nil
+}
+
+// book a room: check availability, insert the reservation, its room restriction and its mail under one lock.
+// a hold of the guest on the room and dates becomes the restriction of the reservation
+func (m *memoryDBRepo) BookReservation(res models.Reservation, holdID int, mail func(res models.Reservation) ([]models.MailData, error)) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	held := -1
+	for i, rr := range m.roomRestrictions {
+		if holdID > 0 && rr.ID == holdID && rr.RestrictionID == models.RestrictionHold && rr.RoomID == res.RoomID &&
+			rr.StartDate.Equal(res.StartDate) && rr.EndDate.Equal(res.EndDate) {
+			held = i
+		}
+	}
+	if held < 0 && !m.available(res.StartDate, res.EndDate, res.RoomID) {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	// build the mail before changing anything, so a failure leaves nothing behind like a rollback
+	var mails []models.MailData
+	if mail != nil {
+		res.ID = m.sequences["reservations"] + 1
+		var err error
+		mails, err = mail(res)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	newID := m.insertReservation(res)
+	if held >= 0 {
+		m.roomRestrictions[held].RestrictionID = models.RestrictionReservation
+		m.roomRestrictions[held].ReservationID = newID
+		m.roomRestrictions[held].ExpiresAt = time.Time{}
+		m.roomRestrictions[held].UpdatedAt = time.Now()
+	} else {
+		_, err := m.insertRoomRestriction(models.RoomRestriction{
+			StartDate:     res.StartDate,
+			EndDate:       res.EndDate,
+			RoomID:        res.RoomID,
+			ReservationID: newID,
+			RestrictionID: models.RestrictionReservation,
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	for _, msg := range mails {
+		m.queueMail(msg)
+	}
+	return newID, nil
+}
+
+// hold a room for a guest filling in the reservation form until hold.ExpiresAt
+func (m *memoryDBRepo) InsertHold(hold models.RoomRestriction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hold.RestrictionID = models.RestrictionHold
+	hold.ReservationID = 0
+	hold.ICalSourceID = 0
+	return m.insertRoomRestriction(hold)
+}
+
+// let go of a hold before it expires
+func (m *memoryDBRepo) DeleteHold(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteHolds(func(rr models.RoomRestriction) bool { return rr.ID == id })
+	return nil
+}
+
+// remove the holds that expired by now, returns how many there were
+func (m *memoryDBRepo) DeleteExpiredHolds(now time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteHolds(func(rr models.RoomRestriction) bool { return !rr.ExpiresAt.After(now) }), nil
+}
+
+// deleteHolds removes the holds matching remove, returns how many
+func (m *memoryDBRepo) deleteHolds(remove func(rr models.RoomRestriction) bool) int {
+	removed := 0
+	restrictions := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if rr.RestrictionID == models.RestrictionHold && remove(rr) {
+			removed++
+			continue
+		}
+		restrictions = append(restrictions, rr)
+	}
+	m.roomRestrictions = restrictions
+	return removed
+}
+
+// move a reservation and its room restriction to new dates and queue the mail about it
+func (m *memoryDBRepo) ChangeReservationDates(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	i := m.reservationIndex(res.ID)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+	if status := m.reservations[i].Status; status != models.StatusPending && status != models.StatusConfirmed {
+		return fmt.Errorf("%w: %s reservations can't be moved", repository.ErrInvalidTransition, status)
+	}
+	roomID := m.reservations[i].RoomID
+	for _, rr := range m.roomRestrictions {
+		if rr.ReservationID != res.ID && rr.RoomID == roomID && overlaps(res.StartDate, res.EndDate, rr) {
+			return repository.ErrRoomUnavailable
+		}
+	}
+
+	var mails []models.MailData
+	if mail != nil {
+		var err error
+		mails, err = mail(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	m.reservations[i].StartDate = res.StartDate
+	m.reservations[i].EndDate = res.EndDate
+	m.reservations[i].Price = res.Price
+	m.reservations[i].UpdatedAt = now
+	for j, rr := range m.roomRestrictions {
+		if rr.ReservationID == res.ID && rr.RestrictionID == models.RestrictionReservation {
+			m.roomRestrictions[j].StartDate = res.StartDate
+			m.roomRestrictions[j].EndDate = res.EndDate
+			m.roomRestrictions[j].UpdatedAt = now
+		}
+	}
+	for _, msg := range mails {
+		m.queueMail(msg)
+	}
+	return nil
+}
+
+// cancel a reservation, freeing its room, and queue the mail about it
+func (m *memoryDBRepo) CancelReservation(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkTransition(res.ID, models.StatusCancelled); err != nil {
+		return err
+	}
+
+	var mails []models.MailData
+	if mail != nil {
+		res.Status = models.StatusCancelled
+		var err error
+		mails, err = mail(res)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.updateReservationStatus(res.ID, models.StatusCancelled)
+	for _, msg := range mails {
+		m.queueMail(msg)
+	}
+	return nil
+}
+
+func (m *memoryDBRepo) reservationIndex(id int) int {
+	for i, res := range m.reservations {
+		if res.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *memoryDBRepo) available(start, end time.Time, roomID int) bool {
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && overlaps(start, end, rr) {
+			return false
+		}
+	}
+	return true
+}
+
+// search room availability for roomID
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.available(start, end, roomID), nil
+}
+
+// return the slice of avaiable rooms for given date
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int) ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterRooms(func(room models.Room) bool {
+		return !room.Archived() && room.Capacity >= guests && m.available(start, end, room.ID)
+	}), nil
+}
+
+func (m *memoryDBRepo) GetRoomByID(id int) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if room.ID == id {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+func (m *memoryDBRepo) GetuserByID(ID int) (models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.ID == ID {
+			return u, nil
+		}
+	}
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *memoryDBRepo) UpdateUser(u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.users {
+		if m.users[i].ID == u.ID {
+			m.users[i].FirstName = u.FirstName
+			m.users[i].LastName = u.LastName
+			m.users[i].Email = u.Email
+			m.users[i].AccessLevel = u.AccessLevel
+			m.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return nil
+}
+
+func (m *memoryDBRepo) Authenticate(email, password string) (int, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email != email {
+			continue
+		}
+		//compare the pw with the stored one
+		err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+		if err == bcrypt.ErrMismatchedHashAndPassword {
+			return 0, "", errors.New("incorrect password")
+		} else if err != nil {
+			return 0, "", err
+		}
+		return u.ID, u.Password, nil
+	}
+	return 0, "", sql.ErrNoRows
+}
+
+// withRoom fills in the room like the left join in the postgres queries
+func (m *memoryDBRepo) withRoom(res models.Reservation) models.Reservation {
+	for _, room := range m.rooms {
+		if room.ID == res.RoomID {
+			res.Room.ID = room.ID
+			res.Room.RoomName = room.RoomName
+			res.Room.Capacity = room.Capacity
+		}
+	}
+	return res
+}
+
+// filterReservations returns the reservations matching keep, ordered by start date
+func (m *memoryDBRepo) filterReservations(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, res := range m.reservations {
+		if keep(res) {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sort.SliceStable(reservations, func(i, j int) bool {
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+	return reservations
+}
+
+// admin: return all reservation, or those with status if it isn't empty
+func (m *memoryDBRepo) AllReservation(status string) ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterReservations(func(res models.Reservation) bool { return status == "" || res.Status == status }), nil
+}
+
+// admin: return new reservation, the owner hasn't confirmed them yet
+func (m *memoryDBRepo) AllNewReservation() ([]models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterReservations(func(res models.Reservation) bool { return res.Status == models.StatusPending }), nil
+}
+
+func (m *memoryDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, res := range m.reservations {
+		if res.ID == id {
+			return m.withRoom(res), nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// update reservation in the admin dashboard
+func (m *memoryDBRepo) UpdateReservation(u models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.reservations {
+		if m.reservations[i].ID == u.ID {
+			m.reservations[i].FirstName = u.FirstName
+			m.reservations[i].LastName = u.LastName
+			m.reservations[i].Email = u.Email
+			m.reservations[i].Phone = u.Phone
+			m.reservations[i].Adults = u.Adults
+			m.reservations[i].Children = u.Children
+			m.reservations[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// move a reservation along its lifecycle, cancelling frees its room
+func (m *memoryDBRepo) UpdateReservationStatus(id int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkTransition(id, status); err != nil {
+		return err
+	}
+	m.updateReservationStatus(id, status)
+	return nil
+}
+
+func (m *memoryDBRepo) checkTransition(id int, status string) error {
+	i := m.reservationIndex(id)
+	if i < 0 {
+		return sql.ErrNoRows
+	}
+	if current := m.reservations[i].Status; !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s to %s", repository.ErrInvalidTransition, current, status)
+	}
+	return nil
+}
+
+// updateReservationStatus sets the status, a cancelled reservation keeps its row but not its room restriction
+func (m *memoryDBRepo) updateReservationStatus(id int, status string) {
+	i := m.reservationIndex(id)
+	m.reservations[i].Status = status
+	m.reservations[i].UpdatedAt = time.Now()
+
+	if status != models.StatusCancelled {
+		return
+	}
+	restrictions := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if rr.ReservationID != id {
+			restrictions = append(restrictions, rr)
+		}
+	}
+	m.roomRestrictions = restrictions
+}
+
+// the rooms on the site, in their order. archived ones are left out, see ArchivedRooms
+func (m *memoryDBRepo) AllRooms() ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterRooms(func(room models.Room) bool { return !room.Archived() }), nil
+}
+
+// filterRooms returns the rooms matching keep, ordered like the postgres queries
+func (m *memoryDBRepo) filterRooms(keep func(models.Room) bool) []models.Room {
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if keep(room) {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.SliceStable(rooms, func(i, j int) bool {
+		if rooms[i].SortOrder != rooms[j].SortOrder {
+			return rooms[i].SortOrder < rooms[j].SortOrder
+		}
+		return rooms[i].RoomName < rooms[j].RoomName
+	})
+	return rooms
+}
+
+// the archived rooms, most recently archived first
+func (m *memoryDBRepo) ArchivedRooms() ([]models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rooms := m.filterRooms(models.Room.Archived)
+	sort.SliceStable(rooms, func(i, j int) bool {
+		return rooms[i].ArchivedAt.After(rooms[j].ArchivedAt)
+	})
+	return rooms, nil
+}
+
+// find a room by the slug of its page, archived rooms too
+func (m *memoryDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// slugTaken is the unique index on rooms.slug
+func (m *memoryDBRepo) slugTaken(slug string, id int) bool {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// add a room at the end of the list, returns its id
+func (m *memoryDBRepo) InsertRoom(room models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(room.Slug, 0) {
+		return 0, repository.ErrSlugTaken
+	}
+	room.ID = m.nextID("rooms")
+	room.SortOrder = 1
+	for _, r := range m.rooms {
+		if r.SortOrder >= room.SortOrder {
+			room.SortOrder = r.SortOrder + 1
+		}
+	}
+	room.ArchivedAt = time.Time{}
+	room.Rules = models.DefaultStayRules // like the column defaults, they are set with UpdateRoomStayRules
+	room.CreatedAt = time.Now()
+	room.UpdatedAt = time.Now()
+	m.rooms = append(m.rooms, room)
+	return room.ID, nil
+}
+
+// save the details of a room, rates and calendar feeds have their own methods
+func (m *memoryDBRepo) UpdateRoom(room models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(room.Slug, room.ID) {
+		return repository.ErrSlugTaken
+	}
+	for i := range m.rooms {
+		if m.rooms[i].ID == room.ID {
+			m.rooms[i].RoomName = room.RoomName
+			m.rooms[i].Slug = room.Slug
+			m.rooms[i].Description = room.Description
+			m.rooms[i].Capacity = room.Capacity
+			m.rooms[i].Amenities = append([]string(nil), room.Amenities...)
+			m.rooms[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// take a room off the site, or put it back if archived is false
+func (m *memoryDBRepo) SetRoomArchived(id int, archived bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		if m.rooms[i].ID == id {
+			m.rooms[i].ArchivedAt = time.Time{}
+			if archived {
+				m.rooms[i].ArchivedAt = time.Now()
+			}
+			m.rooms[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// put the rooms in the order of ids, rooms not in ids keep their place after them
+func (m *memoryDBRepo) ReorderRooms(ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		m.rooms[i].SortOrder += len(ids)
+	}
+	for n, id := range ids {
+		for i := range m.rooms {
+			if m.rooms[i].ID == id {
+				m.rooms[i].SortOrder = n + 1
+				m.rooms[i].UpdatedAt = time.Now()
+			}
+		}
+	}
+	return nil
+}
+
+// the photos of a room in the order they are shown
+func (m *memoryDBRepo) RoomPhotos(roomID int) ([]models.RoomPhoto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var photos []models.RoomPhoto
+	for _, p := range m.roomPhotos {
+		if p.RoomID == roomID {
+			photos = append(photos, p)
+		}
+	}
+	sortRoomPhotos(photos)
+	return photos, nil
+}
+
+// the first photo of every room that has photos, by room id
+func (m *memoryDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	photos := append([]models.RoomPhoto(nil), m.roomPhotos...)
+	sortRoomPhotos(photos)
+	covers := make(map[int]models.RoomPhoto)
+	for _, p := range photos {
+		if _, ok := covers[p.RoomID]; !ok {
+			covers[p.RoomID] = p
+		}
+	}
+	return covers, nil
+}
+
+// sortRoomPhotos orders photos like "order by sort_order, id"
+func sortRoomPhotos(photos []models.RoomPhoto) {
+	sort.SliceStable(photos, func(i, j int) bool {
+		if photos[i].SortOrder != photos[j].SortOrder {
+			return photos[i].SortOrder < photos[j].SortOrder
+		}
+		return photos[i].ID < photos[j].ID
+	})
+}
+
+func (m *memoryDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, p := range m.roomPhotos {
+		if p.ID == id {
+			return p, nil
+		}
+	}
+	return models.RoomPhoto{}, sql.ErrNoRows
+}
+
+// add a photo after the other photos of its room, returns its id
+func (m *memoryDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p.SortOrder = 1
+	for _, other := range m.roomPhotos {
+		if other.RoomID == p.RoomID && other.SortOrder >= p.SortOrder {
+			p.SortOrder = other.SortOrder + 1
+		}
+	}
+	p.ID = m.nextID("room_photos")
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	m.roomPhotos = append(m.roomPhotos, p)
+	return p.ID, nil
+}
+
+// remove a photo from the database, its files are the caller's
+func (m *memoryDBRepo) DeleteRoomPhoto(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	photos := m.roomPhotos[:0]
+	for _, p := range m.roomPhotos {
+		if p.ID != id {
+			photos = append(photos, p)
+		}
+	}
+	m.roomPhotos = photos
+	return nil
+}
+
+// show the photos of a room in the order of ids, ids of other rooms are left alone
+func (m *memoryDBRepo) ReorderRoomPhotos(roomID int, ids []int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.roomPhotos {
+		if m.roomPhotos[i].RoomID == roomID {
+			m.roomPhotos[i].SortOrder += len(ids)
+		}
+	}
+	for n, id := range ids {
+		for i := range m.roomPhotos {
+			if m.roomPhotos[i].ID == id && m.roomPhotos[i].RoomID == roomID {
+				m.roomPhotos[i].SortOrder = n + 1
+				m.roomPhotos[i].UpdatedAt = time.Now()
+			}
+		}
+	}
+	return nil
+}
+
+func (m *memoryDBRepo) GetReservationForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var restriction []models.RoomRestriction
+	// same as "$1 < end_date and $2 >= start_date" in postgres
+	for _, rr := range m.roomRestrictions {
+		if rr.RoomID == roomID && start.Before(rr.EndDate) && !end.Before(rr.StartDate) {
+			found := models.RoomRestriction{
+				ID:            rr.ID,
+				ReservationID: rr.ReservationID,
+				RestrictionID: rr.RestrictionID,
+				RoomID:        rr.RoomID,
+				StartDate:     rr.StartDate,
+				EndDate:       rr.EndDate,
+				UpdatedAt:     rr.UpdatedAt,
+			}
+			for _, res := range m.reservations {
+				if rr.ReservationID != 0 && res.ID == rr.ReservationID {
+					found.Reservation = models.Reservation{ID: res.ID, FirstName: res.FirstName, LastName: res.LastName}
+				}
+			}
+			restriction = append(restriction, found)
+		}
+	}
+	sort.SliceStable(restriction, func(i, j int) bool {
+		return restriction[i].StartDate.Before(restriction[j].StartDate)
+	})
+	return restriction, nil
+}
+
+// find the room whose calendar feed has token
+func (m *memoryDBRepo) GetRoomByICalToken(token string) (models.Room, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, room := range m.rooms {
+		if token != "" && room.ICalToken == token {
+			return room, nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// set the token of a room's calendar feed, the old feed url stops working
+func (m *memoryDBRepo) UpdateRoomICalToken(roomID int, token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		if m.rooms[i].ID == roomID {
+			m.rooms[i].ICalToken = token
+			m.rooms[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// set the rates of a room, in cents
+func (m *memoryDBRepo) UpdateRoomRates(roomID, nightlyRate, weekendRate int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		if m.rooms[i].ID == roomID {
+			m.rooms[i].NightlyRate = nightlyRate
+			m.rooms[i].WeekendRate = weekendRate
+			m.rooms[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// set the stay rules of a room
+func (m *memoryDBRepo) UpdateRoomStayRules(roomID int, rules models.StayRules) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.rooms {
+		if m.rooms[i].ID == roomID {
+			m.rooms[i].Rules = rules
+			m.rooms[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// all seasonal rates, ordered like the postgres query
+func (m *memoryDBRepo) AllSeasonalRates() ([]models.SeasonalRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterSeasonalRates(func(models.SeasonalRate) bool { return true }, true), nil
+}
+
+// the seasonal rates of a room covering any night of a stay from start to end
+func (m *memoryDBRepo) SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterSeasonalRates(func(s models.SeasonalRate) bool {
+		return s.RoomID == roomID && s.FirstNight.Before(end) && !s.LastNight.Before(start)
+	}, false), nil
+}
+
+// filterSeasonalRates returns the seasons matching keep with their rooms, by first night
+// and, if byRoom, by room name first
+func (m *memoryDBRepo) filterSeasonalRates(keep func(models.SeasonalRate) bool, byRoom bool) []models.SeasonalRate {
+	var seasons []models.SeasonalRate
+	for _, s := range m.seasonalRates {
+		if !keep(s) {
+			continue
+		}
+		for _, room := range m.rooms {
+			if room.ID == s.RoomID {
+				s.Room.ID = room.ID
+				s.Room.RoomName = room.RoomName
+			}
+		}
+		seasons = append(seasons, s)
+	}
+	sort.SliceStable(seasons, func(i, j int) bool {
+		if byRoom && seasons[i].Room.RoomName != seasons[j].Room.RoomName {
+			return seasons[i].Room.RoomName < seasons[j].Room.RoomName
+		}
+		if !seasons[i].FirstNight.Equal(seasons[j].FirstNight) {
+			return seasons[i].FirstNight.Before(seasons[j].FirstNight)
+		}
+		return seasons[i].ID < seasons[j].ID
+	})
+	return seasons
+}
+
+func (m *memoryDBRepo) InsertSeasonalRate(s models.SeasonalRate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s.ID = m.nextID("seasonal_rates")
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = time.Now()
+	m.seasonalRates = append(m.seasonalRates, s)
+	return s.ID, nil
+}
+
+func (m *memoryDBRepo) DeleteSeasonalRate(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	seasons := m.seasonalRates[:0]
+	for _, s := range m.seasonalRates {
+		if s.ID != id {
+			seasons = append(seasons, s)
+		}
+	}
+	m.seasonalRates = seasons
+	return nil
+}
+
+// all closed dates, ordered like the postgres query
+func (m *memoryDBRepo) AllClosedDates() ([]models.ClosedDate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterClosedDates(func(models.ClosedDate) bool { return true }, true), nil
+}
+
+// the closed dates of a room covering any day from start up to and including end
+func (m *memoryDBRepo) ClosedDatesForRoom(roomID int, start, end time.Time) ([]models.ClosedDate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.filterClosedDates(func(c models.ClosedDate) bool {
+		return c.RoomID == roomID && !c.FirstDate.After(end) && !c.LastDate.Before(start)
+	}, false), nil
+}
+
+// filterClosedDates returns the closed dates matching keep with their rooms, by first date
+// and, if byRoom, by room name first
+func (m *memoryDBRepo) filterClosedDates(keep func(models.ClosedDate) bool, byRoom bool) []models.ClosedDate {
+	var closed []models.ClosedDate
+	for _, c := range m.closedDates {
+		if !keep(c) {
+			continue
+		}
+		for _, room := range m.rooms {
+			if room.ID == c.RoomID {
+				c.Room.ID = room.ID
+				c.Room.RoomName = room.RoomName
+			}
+		}
+		closed = append(closed, c)
+	}
+	sort.SliceStable(closed, func(i, j int) bool {
+		if byRoom && closed[i].Room.RoomName != closed[j].Room.RoomName {
+			return closed[i].Room.RoomName < closed[j].Room.RoomName
+		}
+		if !closed[i].FirstDate.Equal(closed[j].FirstDate) {
+			return closed[i].FirstDate.Before(closed[j].FirstDate)
+		}
+		return closed[i].ID < closed[j].ID
+	})
+	return closed
+}
+
+func (m *memoryDBRepo) InsertClosedDate(c models.ClosedDate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c.ID = m.nextID("closed_dates")
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	m.closedDates = append(m.closedDates, c)
+	return c.ID, nil
+}
+
+func (m *memoryDBRepo) DeleteClosedDate(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	closed := m.closedDates[:0]
+	for _, c := range m.closedDates {
+		if c.ID != id {
+			closed = append(closed, c)
+		}
+	}
+	m.closedDates = closed
+	return nil
+}
+
+// block a room for the owner for one day
+func (m *memoryDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.insertRoomRestriction(models.RoomRestriction{
+		StartDate:     startDate,
+		EndDate:       startDate.AddDate(0, 0, 1),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+	})
+	return err
+}
+
+// insert many owner blocks, all of them or none if one overlaps something. returns the new ids
+func (m *memoryDBRepo) InsertBlocks(blocks []models.RoomRestriction) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, b := range blocks {
+		if !m.available(b.StartDate, b.EndDate, b.RoomID) {
+			return nil, repository.ErrRoomUnavailable
+		}
+		// the blocks must not overlap each other either
+		for _, other := range blocks[:i] {
+			if other.RoomID == b.RoomID && overlaps(b.StartDate, b.EndDate, other) {
+				return nil, repository.ErrRoomUnavailable
+			}
+		}
+	}
+
+	var ids []int
+	for _, b := range blocks {
+		b.RestrictionID = models.RestrictionOwnerBlock
+		b.ReservationID = 0
+		id, err := m.insertRoomRestriction(b)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// remove an owner block, other restrictions with that id are left alone. returns how many blocks were removed, 0 or 1
+func (m *memoryDBRepo) DeleteBlockByID(id int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	removed := 0
+	restrictions := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if rr.ID == id && rr.RestrictionID == models.RestrictionOwnerBlock {
+			removed++
+			continue
+		}
+		restrictions = append(restrictions, rr)
+	}
+	m.roomRestrictions = restrictions
+	return removed, nil
+}
+
+// free days of an owner block, the nights left on either side of them stay blocked as blocks of their own
+func (m *memoryDBRepo) DeleteBlockDays(id int, days []time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rr := range m.roomRestrictions {
+		if rr.ID != id || rr.RestrictionID != models.RestrictionOwnerBlock {
+			continue
+		}
+		m.roomRestrictions = append(m.roomRestrictions[:i], m.roomRestrictions[i+1:]...)
+		for _, piece := range blockPieces(rr, days) {
+			if _, err := m.insertRoomRestriction(piece); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return nil
+}
+
+// add a calendar to import blocks from
+func (m *memoryDBRepo) InsertICalSource(src models.ICalSource) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	src.ID = m.nextID("ical_sources")
+	src.CreatedAt = time.Now()
+	src.UpdatedAt = time.Now()
+	src.Room = models.Room{}
+	m.icalSources = append(m.icalSources, src)
+	return src.ID, nil
+}
+
+// all calendars to import, with their rooms
+func (m *memoryDBRepo) AllICalSources() ([]models.ICalSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sources []models.ICalSource
+	for _, src := range m.icalSources {
+		sources = append(sources, m.sourceWithRoom(src))
+	}
+	return sources, nil
+}
+
+func (m *memoryDBRepo) GetICalSourceByID(id int) (models.ICalSource, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, src := range m.icalSources {
+		if src.ID == id {
+			return m.sourceWithRoom(src), nil
+		}
+	}
+	return models.ICalSource{}, sql.ErrNoRows
+}
+
+func (m *memoryDBRepo) sourceWithRoom(src models.ICalSource) models.ICalSource {
+	for _, room := range m.rooms {
+		if room.ID == src.RoomID {
+			src.Room = room
+		}
+	}
+	return src
+}
+
+// remove a calendar and the blocks imported from it
+func (m *memoryDBRepo) DeleteICalSource(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sources := m.icalSources[:0]
+	for _, src := range m.icalSources {
+		if src.ID != id {
+			sources = append(sources, src)
+		}
+	}
+	m.icalSources = sources
+
+	restrictions := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if rr.ICalSourceID != id {
+			restrictions = append(restrictions, rr)
+		}
+	}
+	m.roomRestrictions = restrictions
+	return nil
+}
+
+// remember how the last sync of a calendar went
+func (m *memoryDBRepo) UpdateICalSourceSync(id int, syncedAt time.Time, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.icalSources {
+		if m.icalSources[i].ID == id {
+			m.icalSources[i].LastSyncedAt = syncedAt
+			m.icalSources[i].LastError = lastError
+			m.icalSources[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// the blocks imported from a calendar
+func (m *memoryDBRepo) ExternalBlocksForSource(sourceID int) ([]models.RoomRestriction, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var blocks []models.RoomRestriction
+	for _, rr := range m.roomRestrictions {
+		if rr.ICalSourceID == sourceID && rr.RestrictionID == models.RestrictionExternal {
+			blocks = append(blocks, rr)
+		}
+	}
+	return blocks, nil
+}
+
+// add a block imported from a calendar
+func (m *memoryDBRepo) InsertExternalBlock(rr models.RoomRestriction) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.roomRestrictions {
+		if other.ICalSourceID == rr.ICalSourceID && other.ICalUID == rr.ICalUID {
+			return 0, errors.New("duplicate ical uid")
+		}
+	}
+	rr.RestrictionID = models.RestrictionExternal
+	return m.insertRoomRestriction(rr)
+}
+
+// move an imported block to the new dates of its event
+func (m *memoryDBRepo) UpdateExternalBlock(id int, start, end time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, rr := range m.roomRestrictions {
+		if rr.ID != id || rr.RestrictionID != models.RestrictionExternal {
+			continue
+		}
+		for _, other := range m.roomRestrictions {
+			if other.ID != id && other.RoomID == rr.RoomID && overlaps(start, end, other) {
+				return repository.ErrRoomUnavailable
+			}
+		}
+		m.roomRestrictions[i].StartDate = start
+		m.roomRestrictions[i].EndDate = end
+		m.roomRestrictions[i].UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+// remove an imported block, its event is gone
+func (m *memoryDBRepo) DeleteExternalBlock(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	restrictions := m.roomRestrictions[:0]
+	for _, rr := range m.roomRestrictions {
+		if rr.ID != id || rr.RestrictionID != models.RestrictionExternal {
+			restrictions = append(restrictions, rr)
+		}
+	}
+	m.roomRestrictions = restrictions
+	return nil
+}
+
+// put a message into the mail outbox, the outbox worker sends it
+func (m *memoryDBRepo) QueueMail(msg models.MailData) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.queueMail(msg)
+	return nil
+}
+
+func (m *memoryDBRepo) queueMail(msg models.MailData) {
+	now := time.Now()
+	m.outbox = append(m.outbox, models.OutboxMessage{
+		ID:            m.nextID("mail_outbox"),
+		Mail:          msg,
+		Status:        models.OutboxPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	})
+}
+
+// return the pending messages that are due at now, oldest first
+func (m *memoryDBRepo) PendingMail(now time.Time, limit int) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var messages []models.OutboxMessage
+	for _, msg := range m.outbox {
+		if msg.Status == models.OutboxPending && !msg.NextAttemptAt.After(now) {
+			messages = append(messages, msg)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].NextAttemptAt.Before(messages[j].NextAttemptAt)
+	})
+	if len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+// save the outcome of a send attempt
+func (m *memoryDBRepo) UpdateOutboxMessage(msg models.OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.outbox {
+		if m.outbox[i].ID == msg.ID {
+			m.outbox[i].Status = msg.Status
+			m.outbox[i].Attempts = msg.Attempts
+			m.outbox[i].LastError = msg.LastError
+			m.outbox[i].NextAttemptAt = msg.NextAttemptAt
+			m.outbox[i].SentAt = msg.SentAt
+			m.outbox[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// store a new api key
+func (m *memoryDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, other := range m.apiKeys {
+		if other.Prefix == k.Prefix {
+			return 0, errors.New("duplicate api key prefix")
+		}
+	}
+	k.ID = m.nextID("api_keys")
+	k.CreatedAt = time.Now()
+	k.UpdatedAt = time.Now()
+	k.User = models.User{}
+	m.apiKeys = append(m.apiKeys, k)
+	return k.ID, nil
+}
+
+// find the api key with prefix, together with its user
+func (m *memoryDBRepo) GetAPIKeyByPrefix(prefix string) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, k := range m.apiKeys {
+		if k.Prefix == prefix {
+			return m.withUser(k), nil
+		}
+	}
+	return models.APIKey{}, sql.ErrNoRows
+}
+
+// all api keys with their users, the newest first
+func (m *memoryDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var keys []models.APIKey
+	for i := len(m.apiKeys) - 1; i >= 0; i-- {
+		keys = append(keys, m.withUser(m.apiKeys[i]))
+	}
+	return keys, nil
+}
+
+func (m *memoryDBRepo) withUser(k models.APIKey) models.APIKey {
+	for _, u := range m.users {
+		if u.ID == k.UserID {
+			k.User = models.User{ID: u.ID, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email, AccessLevel: u.AccessLevel}
+		}
+	}
+	return k
+}
+
+// revoke an api key, it can't be used any more
+func (m *memoryDBRepo) RevokeAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID == id && !m.apiKeys[i].Revoked() {
+			m.apiKeys[i].RevokedAt = time.Now()
+			m.apiKeys[i].UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+// remember when an api key was used
+func (m *memoryDBRepo) TouchAPIKey(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID == id {
+			m.apiKeys[i].LastUsedAt = time.Now()
+		}
+	}
+	return nil
+}
@@ -2,11 +2,15 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -17,131 +21,235 @@ func (m *postgresDBRepo) AllUsers() bool {
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
-	var newID int
-	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, created_at, updated_at)
-	values ($1,$2,$3,$4,$5,$6,$7,$8,$9) returning id`
-
-	err := m.DB.QueryRowContext(ctx,stmt,
-		res.FirstName,
-		res.LastName,
-		res.Email,
-		res.Phone,
-		res.StartDate,
-		res.EndDate,
-		res.RoomID,
-		time.Now(),
-		time.Now(),
-	).Scan((&newID))
-
-	if err != nil {
-		return 0,err
-	}
 
-	return newID, nil
+	return insertReservation(ctx, m.DB, res)
 }
 
 //insert a room restriction into db
 func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
-	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, 
-		   created_at, updated_at, restriction_id)
-		   values($1,$2,$3,$4,$5,$6,$7)`
-	_, err := m.DB.ExecContext(ctx, stmt,
-			res.StartDate,
-			res.EndDate,
-			res.RoomID,
-			res.ReservationID,
-			time.Now(),
-			time.Now(),
-			res.RestrictionID,
-	)
 
-	if err != nil {
-		return err
-	}
-	return nil
+	_, err := insertRoomRestriction(ctx, m.DB, res)
+	return err
 }
-// search room availability for roomID
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) { 
+
+// book a room: re-check availability, insert the reservation, its room restriction and
+// the mail built by mail (may be nil) into the outbox, all in one transaction.
+// holdID is the hold the guest got for the room, 0 if none. If it is still there it becomes the restriction of the reservation
+func (m *postgresDBRepo) BookReservation(res models.Reservation, holdID int, mail func(res models.Reservation) ([]models.MailData, error)) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
-	//query for a certain room
-	query := `select count(id) from room_restrictions where 
-	room_id = $1 and
-	$2 < end_date and $3 > start_date;`
-	row := m.DB.QueryRowContext(ctx,query, roomID, start, end)
-	var numRows int
-	err := row.Scan(&numRows)
+
+	var newID int
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		held, err := heldFor(ctx, tx, holdID, res)
+		if err != nil {
+			return err
+		}
+		if !held {
+			available, err := searchAvailabilityByDatesByRoomID(ctx, tx, res.StartDate, res.EndDate, res.RoomID)
+			if err != nil {
+				return err
+			}
+			if !available {
+				return repository.ErrRoomUnavailable
+			}
+		}
+
+		newID, err = insertReservation(ctx, tx, res)
+		if err != nil {
+			return err
+		}
+
+		if held {
+			_, err = tx.ExecContext(ctx, `update room_restrictions set restriction_id = $1, reservation_id = $2, expires_at = null,
+				updated_at = $3 where id = $4`,
+				models.RestrictionReservation, newID, time.Now(), holdID)
+		} else {
+			_, err = insertRoomRestriction(ctx, tx, models.RoomRestriction{
+				StartDate:     res.StartDate,
+				EndDate:       res.EndDate,
+				RoomID:        res.RoomID,
+				ReservationID: newID,
+				RestrictionID: models.RestrictionReservation,
+			})
+		}
+		if err != nil || mail == nil {
+			return err
+		}
+
+		res.ID = newID
+		return queueMails(ctx, tx, res, mail)
+	})
 	if err != nil {
-		log.Println("err:", err)
-		return false, err
+		return 0, translateRestrictionErr(err)
+	}
+
+	return newID, nil
+}
+
+// heldFor tells if holdID is a hold on the room and dates of res. A hold that expired may still be there, nobody
+// else could take the room while it was
+func heldFor(ctx context.Context, q queryer, holdID int, res models.Reservation) (bool, error) {
+	if holdID == 0 {
+		return false, nil
 	}
-	if numRows != 0 {
+	var hold models.RoomRestriction
+	err := q.QueryRowContext(ctx, `select room_id, restriction_id, start_date, end_date from room_restrictions where id = $1`, holdID).Scan(
+		&hold.RoomID,
+		&hold.RestrictionID,
+		&hold.StartDate,
+		&hold.EndDate,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		// swept away
 		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
+	return hold.RestrictionID == models.RestrictionHold && hold.RoomID == res.RoomID &&
+		hold.StartDate.Equal(res.StartDate) && hold.EndDate.Equal(res.EndDate), nil
+}
+
+// hold a room for a guest filling in the reservation form until hold.ExpiresAt,
+// ErrRoomUnavailable if the room is taken or held by someone else
+func (m *postgresDBRepo) InsertHold(hold models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
 
-	return true, nil
+	hold.RestrictionID = models.RestrictionHold
+	hold.ReservationID = 0
+	hold.ICalSourceID = 0
+	return insertRoomRestriction(ctx, m.DB, hold)
 }
-// return the slice of avaiable rooms for given date
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+
+// let go of a hold before it expires
+func (m *postgresDBRepo) DeleteHold(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
-	var rooms []models.Room
-	query := `select r.id, r.room_name
-			from rooms r
-			where r.id not in
-			(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);`
-	
-	rows, err := m.DB.QueryContext(ctx,query, start, end)
-	
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2`, id, models.RestrictionHold)
+	return err
+}
+
+// remove the holds that expired by now, returns how many there were
+func (m *postgresDBRepo) DeleteExpiredHolds(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `delete from room_restrictions where restriction_id = $1 and expires_at <= $2`,
+		models.RestrictionHold, now)
 	if err != nil {
-		return rooms, err
+		return 0, err
 	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
 
-	defer rows.Close()
+// ChangeReservationDates moves a reservation and its room restriction to res.StartDate and res.EndDate,
+// with res.Price as the new price, and queues the mail about it, all in one transaction. Only pending and confirmed reservations move.
+// The restriction only overlaps itself, so the overlap constraint is all the availability check needed
+func (m *postgresDBRepo) ChangeReservationDates(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
 
-	for rows.Next(){
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		var status string
+		err := tx.QueryRowContext(ctx, `select status from reservations where id = $1`, res.ID).Scan(&status)
 		if err != nil {
-			return rooms, err
+			return err
 		}
-		rooms = append(rooms, room)
-	}
-	if err = rows.Err(); err != nil{
-		return rooms, err
-	}
-	return rooms, nil
+		// the others have no room restriction to move, or are over
+		if status != models.StatusPending && status != models.StatusConfirmed {
+			return fmt.Errorf("%w: %s reservations can't be moved", repository.ErrInvalidTransition, status)
+		}
+
+		now := time.Now()
+		// the price is quoted again for the new dates
+		_, err = tx.ExecContext(ctx, `update reservations set start_date = $1, end_date = $2, price = $3, updated_at = $4 where id = $5`,
+			res.StartDate, res.EndDate, res.Price, now, res.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3
+			where reservation_id = $4 and restriction_id = $5`,
+			res.StartDate, res.EndDate, now, res.ID, models.RestrictionReservation)
+		if err != nil || mail == nil {
+			return err
+		}
+
+		return queueMails(ctx, tx, res, mail)
+	})
+	return translateRestrictionErr(err)
 }
 
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	//connect to db
+// CancelReservation cancels a reservation, which frees its room, and queues the mail about it in one transaction
+func (m *postgresDBRepo) CancelReservation(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
 
-	var room models.Room
-
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
-	row := m.DB.QueryRowContext(ctx, query, id)
+	return m.withTx(ctx, func(tx *sql.Tx) error {
+		err := updateReservationStatus(ctx, tx, res.ID, models.StatusCancelled)
+		if err != nil || mail == nil {
+			return err
+		}
 
+		res.Status = models.StatusCancelled
+		return queueMails(ctx, tx, res, mail)
+	})
+}
 
-	err := row.Scan(
-		&room.ID,
-		&room.RoomName,
-		&room.CreatedAt,
-		&room.UpdatedAt,
-	)
+// queueMails puts the mails built for res into the outbox
+func queueMails(ctx context.Context, q queryer, res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error {
+	mails, err := mail(res)
+	if err != nil {
+		return err
+	}
+	for _, msg := range mails {
+		err = insertOutboxMessage(ctx, q, msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	
+// search room availability for roomID
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) { 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
 
+	available, err := searchAvailabilityByDatesByRoomID(ctx, m.DB, start, end, roomID)
 	if err != nil {
-		return room, err
+		log.Println("err:", err)
+		return false, err
 	}
-	return room, nil
+	return available, nil
+}
+
+// return the slice of avaiable rooms for given date, archived rooms are never available
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, guests int) ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomColumns + `
+			from rooms r
+			where r.archived_at is null and r.capacity >= $3 and r.id not in
+			(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)
+			order by r.sort_order, r.room_name`
+	return m.queryRooms(ctx, query, start, end, guests)
+}
+
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+	//connect to db
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.id = $1`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, id))
 }
 
 func (m *postgresDBRepo) GetuserByID(ID int) (models.User, error) {
@@ -213,82 +321,51 @@ func (m *postgresDBRepo) Authenticate(email, password string) (int, string, erro
 	return id, hashedPassWord, nil
 }
 
-//admin: return all reservation
-func (m *postgresDBRepo)  AllReservation() ([] models.Reservation, error) {
+//admin: return all reservation, or those with status if it isn't empty
+func (m *postgresDBRepo)  AllReservation(status string) ([] models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
 
-	var reservation [] models.Reservation
-
 	query := `
 	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-	r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,rm.id, rm.room_name
+	r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.price, r.adults, r.children, rm.id, rm.room_name, rm.capacity
 	from reservations r 
 	left join rooms rm on (r.room_id= rm.id)
+	where ($1 = '' or r.status = $1)
 	order by r.start_date asc
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
-
-	if err != nil {
-		return reservation, err
-	}
-	defer rows.Close()
-	// here the scan order shall be the same as query result
-	for rows.Next() {
-		 var i models.Reservation
-		 err := rows.Scan( 
-			 &i.ID,
-			 &i.FirstName,
-			 &i.LastName,
-			 &i.Email,
-			 &i.Phone,
-			 &i.StartDate,
-			 &i.EndDate,
-			 &i.RoomID,
-			 &i.CreatedAt,
-			 &i.UpdatedAt,
-			 &i.Processed,
-			 &i.Room.ID,
-			 &i.Room.RoomName,
-
-		 )
-
-		 if err != nil {
-			 return reservation, err
-		 }
-		 reservation = append(reservation,i)
-	}
-	if err := rows.Err(); err != nil {
-		return reservation, err
-	}
-	return reservation, nil
-
+	return m.queryReservations(ctx, query, status)
 }
 
-//admin: return new reservation
+//admin: return new reservation, the owner hasn't confirmed them yet
 func (m *postgresDBRepo)  AllNewReservation() ([] models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
 
-	var reservation [] models.Reservation
-
 	query := `
 	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-	r.end_date, r.room_id, r.created_at, r.updated_at, rm.id, rm.room_name
+	r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.price, r.adults, r.children, rm.id, rm.room_name, rm.capacity
 	from reservations r 
 	left join rooms rm on (r.room_id= rm.id)
-	where r.processed = 0
+	where r.status = $1
 	order by r.start_date asc
 	`
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	return m.queryReservations(ctx, query, models.StatusPending)
+}
+
+// queryReservations runs a query selecting the columns of AllReservation
+func (m *postgresDBRepo) queryReservations(ctx context.Context, query string, args ...interface{}) ([]models.Reservation, error) {
+	var reservation [] models.Reservation
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 
 	if err != nil {
 		return reservation, err
 	}
 	defer rows.Close()
-
+	// here the scan order shall be the same as query result
 	for rows.Next() {
 		 var i models.Reservation
 		 err := rows.Scan( 
@@ -302,8 +379,13 @@ func (m *postgresDBRepo)  AllNewReservation() ([] models.Reservation, error) {
 			 &i.RoomID,
 			 &i.CreatedAt,
 			 &i.UpdatedAt,
+			 &i.Status,
+			 &i.Price,
+			 &i.Adults,
+			 &i.Children,
 			 &i.Room.ID,
 			 &i.Room.RoomName,
+			 &i.Room.Capacity,
 
 		 )
 
@@ -327,7 +409,7 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 
 	query := `
 	select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-	r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
+	r.end_date, r.room_id, r.created_at, r.updated_at, r.status, r.price, r.adults, r.children, rm.id, rm.room_name, rm.capacity
 	from reservations r 
 	left join rooms rm on (r.room_id= rm.id)
 	where r.id = $1`
@@ -344,9 +426,13 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 			 &res.RoomID,
 			 &res.CreatedAt,
 			 &res.UpdatedAt,
-			 &res.Processed,
+			 &res.Status,
+			 &res.Price,
+			 &res.Adults,
+			 &res.Children,
 			 &res.Room.ID,
 			 &res.Room.RoomName,
+			 &res.Room.Capacity,
 	)
 	return res, err
 
@@ -357,87 +443,62 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	defer cancel()
 
 	query := `
-		update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at = $5 where id = $6
+		update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, adults = $5, children = $6,
+		updated_at = $7 where id = $8
 	`
 	_, err := m.DB.ExecContext(ctx, query, 
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.Phone,
+		u.Adults,
+		u.Children,
 		time.Now(),
 		u.ID,
 	)
 	return err
 }
 
-//delete reservation in admin dashboard
-func (m *postgresDBRepo) DeleteReservation(id int) error {
+// move a reservation along its lifecycle, cancelling frees its room
+func (m *postgresDBRepo) UpdateReservationStatus(id int, status string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
 
-	query := `
-	delete from reservations where id = $1`
-
-	_, err := m.DB.ExecContext(ctx, query, id)
+	return m.withTx(ctx, func(tx *sql.Tx) error {
+		return updateReservationStatus(ctx, tx, id, status)
+	})
+}
 
+// updateReservationStatus changes the status of reservation id if models.Transitions allows it.
+// a cancelled reservation gives up its room restriction, the row stays for the history
+func updateReservationStatus(ctx context.Context, q queryer, id int, status string) error {
+	var current string
+	err := q.QueryRowContext(ctx, `select status from reservations where id = $1`, id).Scan(&current)
 	if err != nil {
 		return err
 	}
+	if !models.CanTransition(current, status) {
+		return fmt.Errorf("%w: %s to %s", repository.ErrInvalidTransition, current, status)
+	}
 
-	return nil
-}
-
-//update new reservation to old reservation in the admin dashboard
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
-	defer cancel()
-
-	query := `
-	update reservations set processed = $1  where id = $2`
-
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
-
-	if err != nil {
-		return err
+	if status == models.StatusCancelled {
+		_, err = q.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	_, err = q.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`, status, time.Now(), id)
+	return err
 }
 
+// the rooms on the site, in their order. archived ones are left out, see ArchivedRooms
 func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
 	defer cancel()
-	var rooms []models.Room
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
-
-	rows, err := m.DB.QueryContext(ctx, query)
-
-	if err != nil {
-		return rooms, err
-	}
-
-	defer rows.Close()
-
-	for rows.Next() {
-		var rm models.Room
-		err := rows.Scan( 
-			&rm.ID,
-			&rm.RoomName,
-			&rm.CreatedAt,
-			&rm.UpdatedAt,
-		)
-
-		if err != nil {
-			return rooms, err
-		}
-		rooms = append(rooms, rm)
-
-	}
 
-	if err = rows.Err(); err != nil {
-		return rooms, err
-	}
-	return rooms, nil
+	query := `select ` + roomColumns + ` from rooms r where r.archived_at is null order by r.sort_order, r.room_name`
+	return m.queryRooms(ctx, query)
 }
 
 func (m *postgresDBRepo) GetReservationForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
@@ -446,10 +507,15 @@ func (m *postgresDBRepo) GetReservationForRoomByDate(roomID int, start, end time
 	var restriction []models.RoomRestriction
 	// coalesce: for the column which value may be missed
 	// here once it's missed, use 0
+	// the guest's name comes along for reservations, e.g. for the calendar feed
 	query := `
-		select id, coalesce(reservation_id,0), restriction_id, room_id, start_date, end_date
-		from room_restrictions where $1 < end_date and $2 >= start_date
-		and room_id = $3
+		select rr.id, coalesce(rr.reservation_id,0), rr.restriction_id, rr.room_id, rr.start_date, rr.end_date,
+		rr.expires_at, rr.updated_at, coalesce(r.first_name,''), coalesce(r.last_name,'')
+		from room_restrictions rr
+		left join reservations r on (r.id = rr.reservation_id)
+		where $1 < rr.end_date and $2 >= rr.start_date
+		and rr.room_id = $3
+		order by rr.start_date
 	`
 	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
 
@@ -460,6 +526,7 @@ func (m *postgresDBRepo) GetReservationForRoomByDate(roomID int, start, end time
 
 	for rows.Next() {
 		var r models.RoomRestriction
+		var expiresAt sql.NullTime
 		err := rows.Scan(
 			&r.ID,
 			&r.ReservationID,
@@ -467,14 +534,1055 @@ func (m *postgresDBRepo) GetReservationForRoomByDate(roomID int, start, end time
 			&r.RoomID,
 			&r.StartDate,
 			&r.EndDate,
+			&expiresAt,
+			&r.UpdatedAt,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
 		)
 		if err != nil {
 			return nil, err
 		}
+		r.ExpiresAt = expiresAt.Time
+		r.Reservation.ID = r.ReservationID
 		restriction = append(restriction, r)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, err
 	}
 	return restriction, nil
-}
\ No newline at end of file
+}
+
+// find the room whose calendar feed has token
+func (m *postgresDBRepo) GetRoomByICalToken(token string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.ical_token = $1`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, token))
+}
+
+// set the token of a room's calendar feed, the old feed url stops working
+func (m *postgresDBRepo) UpdateRoomICalToken(roomID int, token string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update rooms set ical_token = $1, updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, query, token, time.Now(), roomID)
+	return err
+}
+
+const roomColumns = `r.id, r.room_name, r.slug, r.description, r.capacity, r.amenities, r.sort_order, r.archived_at,
+	coalesce(r.ical_token, ''), r.nightly_rate, r.weekend_rate, r.min_nights, r.max_nights, r.arrival_days, r.lead_days,
+	r.window_days, r.created_at, r.updated_at`
+
+func scanRoom(row rowScanner) (models.Room, error) {
+	var room models.Room
+	var amenities string
+	var archivedAt sql.NullTime
+	err := row.Scan(
+		&room.ID,
+		&room.RoomName,
+		&room.Slug,
+		&room.Description,
+		&room.Capacity,
+		&amenities,
+		&room.SortOrder,
+		&archivedAt,
+		&room.ICalToken,
+		&room.NightlyRate,
+		&room.WeekendRate,
+		&room.Rules.MinNights,
+		&room.Rules.MaxNights,
+		&room.Rules.ArrivalDays,
+		&room.Rules.LeadDays,
+		&room.Rules.WindowDays,
+		&room.CreatedAt,
+		&room.UpdatedAt,
+	)
+	room.Amenities = splitAmenities(amenities)
+	room.ArchivedAt = archivedAt.Time
+	return room, err
+}
+
+// amenities are stored one per line
+func splitAmenities(s string) []string {
+	var amenities []string
+	for _, a := range strings.Split(s, "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			amenities = append(amenities, a)
+		}
+	}
+	return amenities
+}
+
+// queryRooms runs a query selecting roomColumns
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		room, err := scanRoom(rows)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return rooms, nil
+}
+
+// the archived rooms, most recently archived first
+func (m *postgresDBRepo) ArchivedRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.archived_at is not null order by r.archived_at desc, r.id`
+	return m.queryRooms(ctx, query)
+}
+
+// find a room by the slug of its page, archived rooms too
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomColumns + ` from rooms r where r.slug = $1`
+	return scanRoom(m.DB.QueryRowContext(ctx, query, slug))
+}
+
+// add a room at the end of the list, returns its id
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into rooms (room_name, slug, description, capacity, amenities, sort_order, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, (select coalesce(max(sort_order), 0) + 1 from rooms), $6, $7) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		strings.Join(room.Amenities, "\n"),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, translateSlugErr(err)
+}
+
+// save the details of a room, rates and calendar feeds have their own methods
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4, amenities = $5, updated_at = $6
+		where id = $7`
+	_, err := m.DB.ExecContext(ctx, query,
+		room.RoomName,
+		room.Slug,
+		room.Description,
+		room.Capacity,
+		strings.Join(room.Amenities, "\n"),
+		time.Now(),
+		room.ID,
+	)
+	return translateSlugErr(err)
+}
+
+// take a room off the site, or put it back if archived is false
+func (m *postgresDBRepo) SetRoomArchived(id int, archived bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var archivedAt sql.NullTime
+	if archived {
+		archivedAt = sql.NullTime{Time: time.Now(), Valid: true}
+	}
+	query := `update rooms set archived_at = $1, updated_at = $2 where id = $3`
+	_, err := m.DB.ExecContext(ctx, query, archivedAt, time.Now(), id)
+	return err
+}
+
+// put the rooms in the order of ids, rooms not in ids keep their place after them
+func (m *postgresDBRepo) ReorderRooms(ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	return m.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `update rooms set sort_order = sort_order + $1`, len(ids))
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			_, err := tx.ExecContext(ctx, `update rooms set sort_order = $1, updated_at = $2 where id = $3`, i+1, time.Now(), id)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// set the rates of a room, in cents
+func (m *postgresDBRepo) UpdateRoomRates(roomID, nightlyRate, weekendRate int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update rooms set nightly_rate = $1, weekend_rate = $2, updated_at = $3 where id = $4`
+	_, err := m.DB.ExecContext(ctx, query, nightlyRate, weekendRate, time.Now(), roomID)
+	return err
+}
+
+// set the stay rules of a room
+func (m *postgresDBRepo) UpdateRoomStayRules(roomID int, rules models.StayRules) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update rooms set min_nights = $1, max_nights = $2, arrival_days = $3, lead_days = $4, window_days = $5,
+		updated_at = $6 where id = $7`
+	_, err := m.DB.ExecContext(ctx, query,
+		rules.MinNights,
+		rules.MaxNights,
+		rules.ArrivalDays,
+		rules.LeadDays,
+		rules.WindowDays,
+		time.Now(),
+		roomID,
+	)
+	return err
+}
+
+const roomPhotoColumns = `p.id, p.room_id, p.original, p.medium, p.thumbnail, p.sort_order, p.created_at, p.updated_at`
+
+func scanRoomPhoto(row rowScanner) (models.RoomPhoto, error) {
+	var p models.RoomPhoto
+	err := row.Scan(
+		&p.ID,
+		&p.RoomID,
+		&p.Original,
+		&p.Medium,
+		&p.Thumbnail,
+		&p.SortOrder,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+	return p, err
+}
+
+// queryRoomPhotos runs a query selecting roomPhotoColumns
+func (m *postgresDBRepo) queryRoomPhotos(ctx context.Context, query string, args ...interface{}) ([]models.RoomPhoto, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []models.RoomPhoto
+	for rows.Next() {
+		p, err := scanRoomPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, p)
+	}
+	return photos, rows.Err()
+}
+
+// the photos of a room in the order they are shown
+func (m *postgresDBRepo) RoomPhotos(roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomPhotoColumns + ` from room_photos p where p.room_id = $1 order by p.sort_order, p.id`
+	return m.queryRoomPhotos(ctx, query, roomID)
+}
+
+// the first photo of every room that has photos, by room id
+func (m *postgresDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomPhotoColumns + ` from room_photos p
+		where p.sort_order = (select min(sort_order) from room_photos where room_id = p.room_id)
+		order by p.room_id, p.id`
+	photos, err := m.queryRoomPhotos(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	covers := make(map[int]models.RoomPhoto)
+	for _, p := range photos {
+		if _, ok := covers[p.RoomID]; !ok {
+			covers[p.RoomID] = p
+		}
+	}
+	return covers, nil
+}
+
+func (m *postgresDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + roomPhotoColumns + ` from room_photos p where p.id = $1`
+	return scanRoomPhoto(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// add a photo after the other photos of its room, returns its id
+func (m *postgresDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into room_photos (room_id, original, medium, thumbnail, sort_order, created_at, updated_at)
+		values ($1, $2, $3, $4, (select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1), $5, $6)
+		returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.RoomID,
+		p.Original,
+		p.Medium,
+		p.Thumbnail,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// remove a photo from the database, its files are the caller's
+func (m *postgresDBRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1`, id)
+	return err
+}
+
+// show the photos of a room in the order of ids, ids of other rooms are left alone
+func (m *postgresDBRepo) ReorderRoomPhotos(roomID int, ids []int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	return m.withTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `update room_photos set sort_order = sort_order + $1 where room_id = $2`, len(ids), roomID)
+		if err != nil {
+			return err
+		}
+		for i, id := range ids {
+			_, err := tx.ExecContext(ctx, `update room_photos set sort_order = $1, updated_at = $2 where id = $3 and room_id = $4`,
+				i+1, time.Now(), id, roomID)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const seasonalRateColumns = `s.id, s.room_id, s.name, s.first_night, s.last_night, s.nightly_rate, s.weekend_rate,
+	s.created_at, s.updated_at, r.id, r.room_name`
+
+// querySeasonalRates runs a query selecting seasonalRateColumns
+func (m *postgresDBRepo) querySeasonalRates(ctx context.Context, query string, args ...interface{}) ([]models.SeasonalRate, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var seasons []models.SeasonalRate
+	for rows.Next() {
+		var s models.SeasonalRate
+		err := rows.Scan(
+			&s.ID,
+			&s.RoomID,
+			&s.Name,
+			&s.FirstNight,
+			&s.LastNight,
+			&s.NightlyRate,
+			&s.WeekendRate,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+			&s.Room.ID,
+			&s.Room.RoomName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		seasons = append(seasons, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return seasons, nil
+}
+
+// all seasonal rates, with their rooms
+func (m *postgresDBRepo) AllSeasonalRates() ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + seasonalRateColumns + ` from seasonal_rates s
+		join rooms r on (r.id = s.room_id)
+		order by r.room_name, s.first_night, s.id`
+	return m.querySeasonalRates(ctx, query)
+}
+
+// the seasonal rates of a room covering any night of a stay from start to end
+func (m *postgresDBRepo) SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + seasonalRateColumns + ` from seasonal_rates s
+		join rooms r on (r.id = s.room_id)
+		where s.room_id = $1 and s.first_night < $3 and s.last_night >= $2
+		order by s.first_night, s.id`
+	return m.querySeasonalRates(ctx, query, roomID, start, end)
+}
+
+func (m *postgresDBRepo) InsertSeasonalRate(s models.SeasonalRate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into seasonal_rates (room_id, name, first_night, last_night, nightly_rate, weekend_rate, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		s.RoomID,
+		s.Name,
+		s.FirstNight,
+		s.LastNight,
+		s.NightlyRate,
+		s.WeekendRate,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+func (m *postgresDBRepo) DeleteSeasonalRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from seasonal_rates where id = $1`, id)
+	return err
+}
+
+const closedDateColumns = `c.id, c.room_id, c.first_date, c.last_date, c.closed_to_arrival, c.closed_to_departure, c.note,
+	c.created_at, c.updated_at, r.id, r.room_name`
+
+// queryClosedDates runs a query selecting closedDateColumns
+func (m *postgresDBRepo) queryClosedDates(ctx context.Context, query string, args ...interface{}) ([]models.ClosedDate, error) {
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var closed []models.ClosedDate
+	for rows.Next() {
+		var c models.ClosedDate
+		err := rows.Scan(
+			&c.ID,
+			&c.RoomID,
+			&c.FirstDate,
+			&c.LastDate,
+			&c.ClosedToArrival,
+			&c.ClosedToDeparture,
+			&c.Note,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+			&c.Room.ID,
+			&c.Room.RoomName,
+		)
+		if err != nil {
+			return nil, err
+		}
+		closed = append(closed, c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// all closed dates, with their rooms
+func (m *postgresDBRepo) AllClosedDates() ([]models.ClosedDate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + closedDateColumns + ` from closed_dates c
+		join rooms r on (r.id = c.room_id)
+		order by r.room_name, c.first_date, c.id`
+	return m.queryClosedDates(ctx, query)
+}
+
+// the closed dates of a room covering any day from start up to and including end
+func (m *postgresDBRepo) ClosedDatesForRoom(roomID int, start, end time.Time) ([]models.ClosedDate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + closedDateColumns + ` from closed_dates c
+		join rooms r on (r.id = c.room_id)
+		where c.room_id = $1 and c.first_date <= $3 and c.last_date >= $2
+		order by c.first_date, c.id`
+	return m.queryClosedDates(ctx, query, roomID, start, end)
+}
+
+func (m *postgresDBRepo) InsertClosedDate(c models.ClosedDate) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into closed_dates (room_id, first_date, last_date, closed_to_arrival, closed_to_departure, note,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		c.RoomID,
+		c.FirstDate,
+		c.LastDate,
+		c.ClosedToArrival,
+		c.ClosedToDeparture,
+		c.Note,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+func (m *postgresDBRepo) DeleteClosedDate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from closed_dates where id = $1`, id)
+	return err
+}
+
+// block a room for the owner for one day
+func (m *postgresDBRepo) InsertBlockForRoom(roomID int, startDate time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	_, err := insertRoomRestriction(ctx, m.DB, models.RoomRestriction{
+		StartDate:     startDate,
+		EndDate:       startDate.AddDate(0, 0, 1),
+		RoomID:        roomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+	})
+	return err
+}
+
+// insert many owner blocks, all of them or none if one overlaps something. returns the new ids
+func (m *postgresDBRepo) InsertBlocks(blocks []models.RoomRestriction) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second) // can be a lot of rows
+	defer cancel()
+
+	var ids []int
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		ids = nil // withTx runs this again after a serialization failure
+		for _, b := range blocks {
+			b.RestrictionID = models.RestrictionOwnerBlock
+			b.ReservationID = 0
+			id, err := insertRoomRestriction(ctx, tx, b)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, translateRestrictionErr(err)
+	}
+	return ids, nil
+}
+
+// remove an owner block, other restrictions with that id are left alone. returns how many blocks were removed, 0 or 1
+func (m *postgresDBRepo) DeleteBlockByID(id int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+	result, err := m.DB.ExecContext(ctx, query, id, models.RestrictionOwnerBlock)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// free days of an owner block, the nights left on either side of them stay blocked as blocks of their own
+func (m *postgresDBRepo) DeleteBlockDays(id int, days []time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	err := m.withTx(ctx, func(tx *sql.Tx) error {
+		var block models.RoomRestriction
+		err := tx.QueryRowContext(ctx, `select room_id, start_date, end_date from room_restrictions
+			where id = $1 and restriction_id = $2`, id, models.RestrictionOwnerBlock).Scan(
+			&block.RoomID,
+			&block.StartDate,
+			&block.EndDate,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			// removed already
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from room_restrictions where id = $1`, id)
+		if err != nil {
+			return err
+		}
+		for _, piece := range blockPieces(block, days) {
+			_, err = insertRoomRestriction(ctx, tx, piece)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return translateRestrictionErr(err)
+}
+
+// add a calendar to import blocks from
+func (m *postgresDBRepo) InsertICalSource(src models.ICalSource) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into ical_sources (room_id, name, url, content, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		src.RoomID,
+		src.Name,
+		src.URL,
+		src.Content,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+const icalSourceColumns = `s.id, s.room_id, s.name, s.url, s.content, s.last_synced_at, s.last_error,
+	s.created_at, s.updated_at, r.id, r.room_name`
+
+func scanICalSource(row rowScanner) (models.ICalSource, error) {
+	var src models.ICalSource
+	var lastSynced sql.NullTime
+	err := row.Scan(
+		&src.ID,
+		&src.RoomID,
+		&src.Name,
+		&src.URL,
+		&src.Content,
+		&lastSynced,
+		&src.LastError,
+		&src.CreatedAt,
+		&src.UpdatedAt,
+		&src.Room.ID,
+		&src.Room.RoomName,
+	)
+	src.LastSyncedAt = lastSynced.Time
+	return src, err
+}
+
+// all calendars to import, with their rooms
+func (m *postgresDBRepo) AllICalSources() ([]models.ICalSource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + icalSourceColumns + ` from ical_sources s
+		join rooms r on (r.id = s.room_id)
+		order by r.room_name, s.id`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sources []models.ICalSource
+	for rows.Next() {
+		src, err := scanICalSource(rows)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, src)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+func (m *postgresDBRepo) GetICalSourceByID(id int) (models.ICalSource, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + icalSourceColumns + ` from ical_sources s
+		join rooms r on (r.id = s.room_id)
+		where s.id = $1`
+	return scanICalSource(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// remove a calendar, its blocks go with it (on delete cascade)
+func (m *postgresDBRepo) DeleteICalSource(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from ical_sources where id = $1`, id)
+	return err
+}
+
+// remember how the last sync of a calendar went
+func (m *postgresDBRepo) UpdateICalSourceSync(id int, syncedAt time.Time, lastError string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update ical_sources set last_synced_at = $1, last_error = $2, updated_at = $3 where id = $4`
+	_, err := m.DB.ExecContext(ctx, query, syncedAt, lastError, time.Now(), id)
+	return err
+}
+
+// the blocks imported from a calendar
+func (m *postgresDBRepo) ExternalBlocksForSource(sourceID int) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select id, room_id, start_date, end_date, ical_source_id, ical_uid, updated_at
+		from room_restrictions
+		where ical_source_id = $1 and restriction_id = $2`
+	rows, err := m.DB.QueryContext(ctx, query, sourceID, models.RestrictionExternal)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var blocks []models.RoomRestriction
+	for rows.Next() {
+		rr := models.RoomRestriction{RestrictionID: models.RestrictionExternal}
+		err := rows.Scan(&rr.ID, &rr.RoomID, &rr.StartDate, &rr.EndDate, &rr.ICalSourceID, &rr.ICalUID, &rr.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, rr)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// add a block imported from a calendar
+func (m *postgresDBRepo) InsertExternalBlock(rr models.RoomRestriction) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	rr.RestrictionID = models.RestrictionExternal
+	return insertRoomRestriction(ctx, m.DB, rr)
+}
+
+// move an imported block to the new dates of its event
+func (m *postgresDBRepo) UpdateExternalBlock(id int, start, end time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3
+		where id = $4 and restriction_id = $5`
+	_, err := m.DB.ExecContext(ctx, query, start, end, time.Now(), id, models.RestrictionExternal)
+	return translateRestrictionErr(err)
+}
+
+// remove an imported block, its event is gone
+func (m *postgresDBRepo) DeleteExternalBlock(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionExternal)
+	return err
+}
+
+// put a message into the mail outbox, the outbox worker sends it
+func (m *postgresDBRepo) QueueMail(msg models.MailData) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	return insertOutboxMessage(ctx, m.DB, msg)
+}
+
+// return the pending messages that are due at now, oldest first
+func (m *postgresDBRepo) PendingMail(now time.Time, limit int) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var messages []models.OutboxMessage
+	query := `
+		select id, mail_to, mail_from, subject, content, plain_content, status, attempts, last_error,
+		next_attempt_at, sent_at, created_at, updated_at
+		from mail_outbox where status = $1 and next_attempt_at <= $2
+		order by next_attempt_at, id
+		limit $3
+	`
+	rows, err := m.DB.QueryContext(ctx, query, models.OutboxPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var msg models.OutboxMessage
+		var sentAt sql.NullTime
+		err := rows.Scan(
+			&msg.ID,
+			&msg.Mail.To,
+			&msg.Mail.From,
+			&msg.Mail.Subject,
+			&msg.Mail.Content,
+			&msg.Mail.PlainContent,
+			&msg.Status,
+			&msg.Attempts,
+			&msg.LastError,
+			&msg.NextAttemptAt,
+			&sentAt,
+			&msg.CreatedAt,
+			&msg.UpdatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		msg.SentAt = sentAt.Time
+		messages = append(messages, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// save the outcome of a send attempt
+func (m *postgresDBRepo) UpdateOutboxMessage(msg models.OutboxMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var sentAt sql.NullTime
+	if !msg.SentAt.IsZero() {
+		sentAt = sql.NullTime{Time: msg.SentAt, Valid: true}
+	}
+
+	query := `
+		update mail_outbox set status = $1, attempts = $2, last_error = $3, next_attempt_at = $4,
+		sent_at = $5, updated_at = $6 where id = $7
+	`
+	_, err := m.DB.ExecContext(ctx, query,
+		msg.Status,
+		msg.Attempts,
+		msg.LastError,
+		msg.NextAttemptAt,
+		sentAt,
+		time.Now(),
+		msg.ID,
+	)
+	return err
+}
+
+// store a new api key
+func (m *postgresDBRepo) InsertAPIKey(k models.APIKey) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	var newID int
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, created_at, updated_at)
+		values ($1,$2,$3,$4,$5,$6,$7) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.UserID,
+		k.Name,
+		k.Prefix,
+		k.Hash,
+		strings.Join(k.Scopes, " "),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+const apiKeyColumns = `k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes, k.last_used_at, k.revoked_at,
+	k.created_at, k.updated_at, u.id, u.first_name, u.last_name, u.email, u.access_level`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var lastUsedAt, revokedAt sql.NullTime
+	err := row.Scan(
+		&k.ID,
+		&k.UserID,
+		&k.Name,
+		&k.Prefix,
+		&k.Hash,
+		&scopes,
+		&lastUsedAt,
+		&revokedAt,
+		&k.CreatedAt,
+		&k.UpdatedAt,
+		&k.User.ID,
+		&k.User.FirstName,
+		&k.User.LastName,
+		&k.User.Email,
+		&k.User.AccessLevel,
+	)
+	k.Scopes = strings.Fields(scopes)
+	k.LastUsedAt = lastUsedAt.Time
+	k.RevokedAt = revokedAt.Time
+	return k, err
+}
+
+// find the api key with prefix, together with its user
+func (m *postgresDBRepo) GetAPIKeyByPrefix(prefix string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys k
+		join users u on (u.id = k.user_id)
+		where k.prefix = $1`
+	return scanAPIKey(m.DB.QueryRowContext(ctx, query, prefix))
+}
+
+// all api keys with their users, the newest first
+func (m *postgresDBRepo) AllAPIKeys() ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	query := `select ` + apiKeyColumns + ` from api_keys k
+		join users u on (u.id = k.user_id)
+		order by k.id desc`
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// revoke an api key, it can't be used any more
+func (m *postgresDBRepo) RevokeAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	stmt := `update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+// remember when an api key was used
+func (m *postgresDBRepo) TouchAPIKey(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // 3 seconds then cancel
+	defer cancel()
+
+	stmt := `update api_keys set last_used_at = $1 where id = $2`
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	return err
+}
+
+func insertReservation(ctx context.Context, q queryer, res models.Reservation) (int, error) {
+	var newID int
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date, end_date, room_id, price, adults, children,
+	created_at, updated_at)
+	values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) returning id`
+
+	err := q.QueryRowContext(ctx, stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.Price,
+		res.Adults,
+		res.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+func insertRoomRestriction(ctx context.Context, q queryer, res models.RoomRestriction) (int, error) {
+	var newID int
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, 
+		   created_at, updated_at, restriction_id, ical_source_id, ical_uid, expires_at)
+		   values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) returning id`
+	err := q.QueryRowContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		// owner blocks and the like belong to no reservation
+		sql.NullInt64{Int64: int64(res.ReservationID), Valid: res.ReservationID > 0},
+		time.Now(),
+		time.Now(),
+		res.RestrictionID,
+		// only external blocks come from a calendar
+		sql.NullInt64{Int64: int64(res.ICalSourceID), Valid: res.ICalSourceID > 0},
+		sql.NullString{String: res.ICalUID, Valid: res.ICalSourceID > 0},
+		// only holds expire
+		sql.NullTime{Time: res.ExpiresAt, Valid: !res.ExpiresAt.IsZero()},
+	).Scan(&newID)
+
+	return newID, translateRestrictionErr(err)
+}
+
+func searchAvailabilityByDatesByRoomID(ctx context.Context, q queryer, start, end time.Time, roomID int) (bool, error) {
+	//query for a certain room
+	query := `select count(id) from room_restrictions where 
+	room_id = $1 and
+	$2 < end_date and $3 > start_date;`
+	var numRows int
+	err := q.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	if err != nil {
+		return false, err
+	}
+
+	return numRows == 0, nil
+}
+
+func insertOutboxMessage(ctx context.Context, q queryer, msg models.MailData) error {
+	stmt := `insert into mail_outbox (mail_to, mail_from, subject, content, plain_content, status,
+		attempts, last_error, next_attempt_at, created_at, updated_at)
+		values ($1,$2,$3,$4,$5,$6,0,'',$7,$8,$9)`
+	_, err := q.ExecContext(ctx, stmt,
+		msg.To,
+		msg.From,
+		msg.Subject,
+		msg.Content,
+		msg.PlainContent,
+		models.OutboxPending,
+		time.Now().UTC(),
+		time.Now(),
+		time.Now(),
+	)
+	return err
+}
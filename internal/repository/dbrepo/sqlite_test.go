@@ -0,0 +1,238 @@
+package dbrepo
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/driver"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// newSQLiteRepo migrates a new database in a temporary file
+func newSQLiteRepo(t *testing.T) repository.DatabaseRepo {
+	t.Helper()
+	db, err := driver.ConnectSQLite(filepath.Join(t.TempDir(), "bookings.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.SQL.Close() })
+	return NewSQLiteRepo(db.SQL, &config.AppConfig{})
+}
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func book(t *testing.T, r repository.DatabaseRepo, roomID int, start, end string) (int, error) {
+	t.Helper()
+	return r.BookReservation(models.Reservation{
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: date(start),
+		EndDate:   date(end),
+		RoomID:    roomID,
+		Adults:    1,
+	}, 0, nil)
+}
+
+func TestSQLite_Overlap(t *testing.T) {
+	r := newSQLiteRepo(t)
+
+	if _, err := book(t, r, 1, "2081-01-10", "2081-01-13"); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		roomID    int
+		start     string
+		end       string
+		available bool
+	}{
+		{"same dates", 1, "2081-01-10", "2081-01-13", false},
+		{"inside", 1, "2081-01-11", "2081-01-12", false},
+		{"around", 1, "2081-01-01", "2081-01-31", false},
+		{"over the arrival", 1, "2081-01-08", "2081-01-11", false},
+		{"over the departure", 1, "2081-01-12", "2081-01-20", false},
+		{"leaving on the arrival", 1, "2081-01-07", "2081-01-10", true},
+		{"arriving on the departure", 1, "2081-01-13", "2081-01-15", true},
+		{"other room", 2, "2081-01-10", "2081-01-13", true},
+		// text dates compare across months and years too
+		{"over new year", 1, "2080-12-30", "2081-01-02", true},
+		{"month before", 1, "2080-12-10", "2080-12-13", true},
+	}
+
+	for _, e := range tests {
+		available, err := r.SearchAvailabilityByDatesByRoomID(date(e.start), date(e.end), e.roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if available != e.available {
+			t.Errorf("%s: search says available %t, wanted %t", e.name, available, e.available)
+		}
+	}
+
+	// the trigger stops what the search would miss, in a fresh database for every case
+	for _, e := range tests {
+		r := newSQLiteRepo(t)
+		if _, err := book(t, r, 1, "2081-01-10", "2081-01-13"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := r.InsertBlocks([]models.RoomRestriction{{RoomID: e.roomID, StartDate: date(e.start), EndDate: date(e.end)}})
+		if e.available && err != nil {
+			t.Errorf("%s: expected the block to be saved, got %v", e.name, err)
+		}
+		if !e.available && !errors.Is(err, repository.ErrRoomUnavailable) {
+			t.Errorf("%s: expected ErrRoomUnavailable, got %v", e.name, err)
+		}
+	}
+}
+
+func TestSQLite_Adjacent(t *testing.T) {
+	r := newSQLiteRepo(t)
+
+	// back to back stays share a day, one leaves as the other arrives
+	for _, stay := range [][2]string{{"2081-02-10", "2081-02-13"}, {"2081-02-13", "2081-02-15"}, {"2081-02-08", "2081-02-10"}} {
+		if _, err := book(t, r, 1, stay[0], stay[1]); err != nil {
+			t.Errorf("%s to %s: %v", stay[0], stay[1], err)
+		}
+	}
+
+	restrictions, err := r.GetReservationForRoomByDate(1, date("2081-02-01"), date("2081-02-28"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restrictions) != 3 {
+		t.Errorf("expected 3 restrictions in february, got %d", len(restrictions))
+	}
+	// the range is inclusive, a stay from the 13th is found by asking for the 13th only
+	restrictions, _ = r.GetReservationForRoomByDate(1, date("2081-02-13"), date("2081-02-13"))
+	if len(restrictions) != 1 || !restrictions[0].StartDate.Equal(date("2081-02-13")) {
+		t.Errorf("expected the stay from the 13th, got %+v", restrictions)
+	}
+
+	rooms, err := r.SearchAvailabilityForAllRooms(date("2081-02-15"), date("2081-02-17"), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 2 {
+		t.Errorf("expected both rooms free from the 15th, got %d", len(rooms))
+	}
+	rooms, _ = r.SearchAvailabilityForAllRooms(date("2081-02-14"), date("2081-02-16"), 1)
+	if len(rooms) != 1 || rooms[0].ID != 2 {
+		t.Errorf("expected only room 2 free from the 14th, got %+v", rooms)
+	}
+
+	// moving a stay onto its neighbour is stopped by the update trigger
+	id, _ := book(t, r, 1, "2081-02-20", "2081-02-22")
+	res, _ := r.GetReservationByID(id)
+	res.StartDate, res.EndDate = date("2081-02-14"), date("2081-02-16")
+	if err := r.ChangeReservationDates(res, nil); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected ErrRoomUnavailable moving onto another stay, got %v", err)
+	}
+	res.StartDate, res.EndDate = date("2081-02-15"), date("2081-02-17")
+	if err := r.ChangeReservationDates(res, nil); err != nil {
+		t.Errorf("expected the stay to move next to the other, got %v", err)
+	}
+}
+
+func TestSQLite_Cancelled(t *testing.T) {
+	r := newSQLiteRepo(t)
+
+	id, err := book(t, r, 1, "2081-03-10", "2081-03-13")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := book(t, r, 1, "2081-03-11", "2081-03-12"); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Fatalf("expected ErrRoomUnavailable, got %v", err)
+	}
+
+	res, _ := r.GetReservationByID(id)
+	if err := r.CancelReservation(res, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the room is free again, the reservation stays
+	available, _ := r.SearchAvailabilityByDatesByRoomID(date("2081-03-10"), date("2081-03-13"), 1)
+	if !available {
+		t.Error("expected the room to be free after the cancellation")
+	}
+	res, err = r.GetReservationByID(id)
+	if err != nil || res.Status != models.StatusCancelled {
+		t.Errorf("expected the reservation to be kept as cancelled, got %q, %v", res.Status, err)
+	}
+	if _, err := book(t, r, 1, "2081-03-11", "2081-03-12"); err != nil {
+		t.Errorf("expected the freed room to be booked again, got %v", err)
+	}
+}
+
+func TestSQLite_Holds(t *testing.T) {
+	r := newSQLiteRepo(t)
+	now := time.Now()
+
+	held, err := r.InsertHold(models.RoomRestriction{RoomID: 1, StartDate: date("2081-04-10"), EndDate: date("2081-04-12"), ExpiresAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := book(t, r, 1, "2081-04-11", "2081-04-13"); !errors.Is(err, repository.ErrRoomUnavailable) {
+		t.Errorf("expected a held room to be unavailable, got %v", err)
+	}
+	expired, _ := r.InsertHold(models.RoomRestriction{RoomID: 2, StartDate: date("2081-04-10"), EndDate: date("2081-04-12"), ExpiresAt: now.Add(-time.Minute)})
+
+	n, err := r.DeleteExpiredHolds(now)
+	if err != nil || n != 1 {
+		t.Errorf("expected 1 expired hold, got %d, %v", n, err)
+	}
+	if available, _ := r.SearchAvailabilityByDatesByRoomID(date("2081-04-10"), date("2081-04-12"), 2); !available {
+		t.Errorf("expected hold %d to be swept", expired)
+	}
+
+	// the guest holding the room books it
+	res := models.Reservation{FirstName: "John", LastName: "Smith", Email: "john@smith.com", Adults: 1,
+		RoomID: 1, StartDate: date("2081-04-10"), EndDate: date("2081-04-12")}
+	if _, err := r.BookReservation(res, held, nil); err != nil {
+		t.Fatal(err)
+	}
+	restrictions, _ := r.GetReservationForRoomByDate(1, date("2081-04-10"), date("2081-04-11"))
+	if len(restrictions) != 1 || restrictions[0].ID != held || restrictions[0].RestrictionID != models.RestrictionReservation {
+		t.Errorf("expected the hold to become the reservation, got %+v", restrictions)
+	}
+}
+
+func TestSQLite_DeleteBlockDays(t *testing.T) {
+	r := newSQLiteRepo(t)
+
+	ids, err := r.InsertBlocks([]models.RoomRestriction{{RoomID: 1, StartDate: date("2081-05-10"), EndDate: date("2081-05-15")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.DeleteBlockDays(ids[0], []time.Time{date("2081-05-10"), date("2081-05-12")}); err != nil {
+		t.Fatal(err)
+	}
+
+	restrictions, _ := r.GetReservationForRoomByDate(1, date("2081-05-01"), date("2081-05-31"))
+	var got []string
+	for _, rr := range restrictions {
+		got = append(got, rr.StartDate.Format("0102")+"-"+rr.EndDate.Format("0102"))
+	}
+	if len(got) != 2 || got[0] != "0511-0512" || got[1] != "0513-0515" {
+		t.Errorf("expected the nights of the 11th, 13th and 14th to stay blocked, got %v", got)
+	}
+
+	// a reservation is not a block
+	id, _ := book(t, r, 2, "2081-05-10", "2081-05-12")
+	res, _ := r.GetReservationByID(id)
+	restrictions, _ = r.GetReservationForRoomByDate(2, res.StartDate, res.StartDate)
+	if err := r.DeleteBlockDays(restrictions[0].ID, []time.Time{date("2081-05-10")}); err != nil {
+		t.Fatal(err)
+	}
+	if available, _ := r.SearchAvailabilityByDatesByRoomID(date("2081-05-10"), date("2081-05-11"), 2); available {
+		t.Error("expected the reservation to stay")
+	}
+}
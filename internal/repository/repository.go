@@ -1,28 +1,93 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/tsawler/bookings-app/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room is already restricted for the requested dates
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrInvalidTransition is returned when a reservation can't change to the requested status, see models.Transitions
+var ErrInvalidTransition = errors.New("reservation can't change to that status")
+
+// ErrSlugTaken is returned when another room already has the slug
+var ErrSlugTaken = errors.New("another room has that slug")
+
 type DatabaseRepo interface {
 	AllUsers() bool
 	
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(res models.RoomRestriction) error
+	BookReservation(res models.Reservation, holdID int, mail func(res models.Reservation) ([]models.MailData, error)) (int, error)
+	InsertHold(hold models.RoomRestriction) (int, error)
+	DeleteHold(id int) error
+	DeleteExpiredHolds(now time.Time) (int, error)
+	ChangeReservationDates(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error
+	CancelReservation(res models.Reservation, mail func(res models.Reservation) ([]models.MailData, error)) error
 	SearchAvailabilityByDatesByRoomID(start, end time.Time,roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, guests int) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
+	GetRoomByICalToken(token string) (models.Room, error)
+	UpdateRoomICalToken(roomID int, token string) error
+	UpdateRoomRates(roomID, nightlyRate, weekendRate int) error
+	UpdateRoomStayRules(roomID int, rules models.StayRules) error
 	GetuserByID(ID int) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, password string) (int, string, error)
-	AllReservation() ([] models.Reservation, error)
+	AllReservation(status string) ([] models.Reservation, error)
 	AllNewReservation() ([] models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
-	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	UpdateReservationStatus(id int, status string) error
 	AllRooms() ([]models.Room, error)
+	ArchivedRooms() ([]models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	SetRoomArchived(id int, archived bool) error
+	ReorderRooms(ids []int) error
+	RoomPhotos(roomID int) ([]models.RoomPhoto, error)
+	CoverPhotos() (map[int]models.RoomPhoto, error)
+	GetRoomPhotoByID(id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	DeleteRoomPhoto(id int) error
+	ReorderRoomPhotos(roomID int, ids []int) error
 	GetReservationForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlockForRoom(roomID int, startDate time.Time) error
+	InsertBlocks(blocks []models.RoomRestriction) ([]int, error)
+	DeleteBlockByID(id int) (int, error)
+	DeleteBlockDays(id int, days []time.Time) error
+
+	InsertICalSource(src models.ICalSource) (int, error)
+	AllICalSources() ([]models.ICalSource, error)
+	GetICalSourceByID(id int) (models.ICalSource, error)
+	DeleteICalSource(id int) error
+	UpdateICalSourceSync(id int, syncedAt time.Time, lastError string) error
+	ExternalBlocksForSource(sourceID int) ([]models.RoomRestriction, error)
+	InsertExternalBlock(rr models.RoomRestriction) (int, error)
+	UpdateExternalBlock(id int, start, end time.Time) error
+	DeleteExternalBlock(id int) error
+
+	AllSeasonalRates() ([]models.SeasonalRate, error)
+	SeasonalRatesForRoom(roomID int, start, end time.Time) ([]models.SeasonalRate, error)
+	InsertSeasonalRate(s models.SeasonalRate) (int, error)
+	DeleteSeasonalRate(id int) error
+
+	AllClosedDates() ([]models.ClosedDate, error)
+	ClosedDatesForRoom(roomID int, start, end time.Time) ([]models.ClosedDate, error)
+	InsertClosedDate(c models.ClosedDate) (int, error)
+	DeleteClosedDate(id int) error
+
+	InsertAPIKey(k models.APIKey) (int, error)
+	GetAPIKeyByPrefix(prefix string) (models.APIKey, error)
+	AllAPIKeys() ([]models.APIKey, error)
+	RevokeAPIKey(id int) error
+	TouchAPIKey(id int) error
+
+	QueueMail(m models.MailData) error
+	PendingMail(now time.Time, limit int) ([]models.OutboxMessage, error)
+	UpdateOutboxMessage(msg models.OutboxMessage) error
 }
\ No newline at end of file
@@ -0,0 +1,202 @@
+// Package stayrules checks a stay against the stay rules of its room and the days it is closed
+// to arrivals or departures
+package stayrules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository"
+)
+
+// the date a broken rule is about, AddErrors puts its message on the matching form field
+const (
+	Arrival   = "arrival"
+	Departure = "departure"
+)
+
+// Stay is a stay to check with everything the rules look at
+type Stay struct {
+	Room   models.Room
+	Start  time.Time           // the day of arrival
+	End    time.Time           // the day of departure
+	Today  time.Time           // the day it is booked on
+	Closed []models.ClosedDate // of the room, at least those covering Start or End
+}
+
+// Nights is how long the stay is
+func (s Stay) Nights() int {
+	return days(s.Start, s.End)
+}
+
+// Rule checks one thing about a stay, Check returns why the stay breaks it or "" if it doesn't
+type Rule struct {
+	Name  string
+	Date  string // Arrival or Departure
+	Check func(s Stay) string
+}
+
+// Violation is a rule a stay breaks
+type Violation struct {
+	Rule    string
+	Date    string
+	Message string
+}
+
+// Rules are checked in this order, which is the order their messages are shown in
+var Rules = []Rule{
+	{"lead_time", Arrival, leadTime},
+	{"booking_window", Arrival, bookingWindow},
+	{"arrival_day", Arrival, arrivalDay},
+	{"closed_to_arrival", Arrival, closedToArrival},
+	{"min_nights", Departure, minNights},
+	{"max_nights", Departure, maxNights},
+	{"closed_to_departure", Departure, closedToDeparture},
+}
+
+// Check runs every rule on s, it returns the ones s breaks
+func Check(s Stay) []Violation {
+	var violations []Violation
+	for _, rule := range Rules {
+		if msg := rule.Check(s); msg != "" {
+			violations = append(violations, Violation{Rule: rule.Name, Date: rule.Date, Message: msg})
+		}
+	}
+	return violations
+}
+
+// AddErrors adds the message of every violation to form, on the arrival or departure field
+func AddErrors(form *forms.Form, violations []Violation, arrival, departure string) {
+	for _, v := range violations {
+		field := arrival
+		if v.Date == Departure {
+			field = departure
+		}
+		form.Errors.Add(field, v.Message)
+	}
+}
+
+// Messages joins the messages of violations into one line
+func Messages(violations []Violation) string {
+	msgs := make([]string, len(violations))
+	for i, v := range violations {
+		msgs[i] = v.Message
+	}
+	return strings.Join(msgs, ". ")
+}
+
+func leadTime(s Stay) string {
+	ahead := days(s.Today, s.Start)
+	if ahead < 0 {
+		return "Arrival can't be in the past"
+	}
+	if ahead < s.Room.Rules.LeadDays {
+		return fmt.Sprintf("Stays have to be booked %s before arrival", plural(s.Room.Rules.LeadDays, "day"))
+	}
+	return ""
+}
+
+func bookingWindow(s Stay) string {
+	if s.Room.Rules.WindowDays > 0 && days(s.Today, s.Start) > s.Room.Rules.WindowDays {
+		return fmt.Sprintf("Stays can be booked at most %s ahead", plural(s.Room.Rules.WindowDays, "day"))
+	}
+	return ""
+}
+
+func arrivalDay(s Stay) string {
+	if s.Room.Rules.ArrivesOn(s.Start.Weekday()) {
+		return ""
+	}
+	var allowed []string
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if s.Room.Rules.ArrivesOn(d) {
+			allowed = append(allowed, d.String())
+		}
+	}
+	if len(allowed) == 0 {
+		return "The room can't be booked at the moment"
+	}
+	list := allowed[0]
+	if n := len(allowed); n > 1 {
+		list = strings.Join(allowed[:n-1], ", ") + " or " + allowed[n-1]
+	}
+	return fmt.Sprintf("Arrival is only possible on %s", list)
+}
+
+func closedToArrival(s Stay) string {
+	for _, c := range s.Closed {
+		if c.ClosedToArrival && c.RoomID == s.Room.ID && c.Covers(s.Start) {
+			return fmt.Sprintf("No arrivals on %s", s.Start.Format("2006-01-02"))
+		}
+	}
+	return ""
+}
+
+func minNights(s Stay) string {
+	if s.Nights() < s.Room.Rules.MinNights {
+		return fmt.Sprintf("The minimum stay is %s", plural(s.Room.Rules.MinNights, "night"))
+	}
+	return ""
+}
+
+func maxNights(s Stay) string {
+	if s.Room.Rules.MaxNights > 0 && s.Nights() > s.Room.Rules.MaxNights {
+		return fmt.Sprintf("The maximum stay is %s", plural(s.Room.Rules.MaxNights, "night"))
+	}
+	return ""
+}
+
+func closedToDeparture(s Stay) string {
+	for _, c := range s.Closed {
+		if c.ClosedToDeparture && c.RoomID == s.Room.ID && c.Covers(s.End) {
+			return fmt.Sprintf("No departures on %s", s.End.Format("2006-01-02"))
+		}
+	}
+	return ""
+}
+
+// days counts the days from a to b, both are dates at midnight
+func days(a, b time.Time) int {
+	return int(b.Sub(a).Round(24*time.Hour) / (24 * time.Hour))
+}
+
+func plural(n int, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+// Service checks stays with the rules and closed dates in the database
+type Service struct {
+	DB  repository.DatabaseRepo
+	Now func() time.Time // the clock, the date in UTC is the day stays are booked on
+}
+
+// New creates a stay rules service
+func New(db repository.DatabaseRepo) *Service {
+	return &Service{DB: db, Now: time.Now}
+}
+
+// Check returns the rules a stay in room roomID from start to end breaks, end is the day of departure
+func (s *Service) Check(roomID int, start, end time.Time) ([]Violation, error) {
+	room, err := s.DB.GetRoomByID(roomID)
+	if err != nil {
+		return nil, err
+	}
+	closed, err := s.DB.ClosedDatesForRoom(roomID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	now := s.Now().UTC()
+	return Check(Stay{
+		Room:   room,
+		Start:  start,
+		End:    end,
+		Today:  time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		Closed: closed,
+	}), nil
+}
@@ -0,0 +1,141 @@
+package stayrules
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tsawler/bookings-app/internal/config"
+	"github.com/tsawler/bookings-app/internal/forms"
+	"github.com/tsawler/bookings-app/internal/models"
+	"github.com/tsawler/bookings-app/internal/repository/dbrepo"
+)
+
+func date(s string) time.Time {
+	d, _ := time.Parse("2006-01-02", s)
+	return d
+}
+
+func TestCheck(t *testing.T) {
+	room := models.Room{ID: 1, Rules: models.StayRules{
+		MinNights:   2,
+		MaxNights:   7,
+		ArrivalDays: 1<<time.Friday | 1<<time.Saturday | 1<<time.Sunday,
+		LeadDays:    2,
+		WindowDays:  365,
+	}}
+	closed := []models.ClosedDate{
+		{RoomID: 1, FirstDate: date("2050-12-24"), LastDate: date("2050-12-25"), ClosedToArrival: true},
+		{RoomID: 1, FirstDate: date("2050-12-31"), LastDate: date("2050-12-31"), ClosedToDeparture: true},
+		{RoomID: 2, FirstDate: date("2050-07-01"), LastDate: date("2050-07-31"), ClosedToArrival: true, ClosedToDeparture: true},
+	}
+
+	var tests = []struct {
+		name     string
+		start    string
+		end      string
+		expected []string
+	}{
+		// today is 2050-07-01, a friday
+		{"fine", "2050-07-08", "2050-07-10", nil},
+		{"in the past", "2050-06-24", "2050-06-26", []string{"lead_time"}},
+		{"too soon", "2050-07-02", "2050-07-04", []string{"lead_time"}},
+		{"just in time", "2050-07-03", "2050-07-05", nil},
+		{"too far ahead", "2051-07-07", "2051-07-09", []string{"booking_window"}},
+		{"on a monday", "2050-07-11", "2050-07-13", []string{"arrival_day"}},
+		{"one night", "2050-07-08", "2050-07-09", []string{"min_nights"}},
+		{"a week", "2050-07-08", "2050-07-15", nil},
+		{"over a week", "2050-07-08", "2050-07-16", []string{"max_nights"}},
+		{"on christmas", "2050-12-25", "2050-12-27", []string{"closed_to_arrival"}},
+		{"leaving on christmas", "2050-12-23", "2050-12-25", nil},
+		{"leaving on new year's eve", "2050-12-25", "2050-12-31", []string{"closed_to_arrival", "closed_to_departure"}},
+		{"monday for a night", "2050-07-11", "2050-07-12", []string{"arrival_day", "min_nights"}},
+	}
+
+	for _, e := range tests {
+		violations := Check(Stay{Room: room, Start: date(e.start), End: date(e.end), Today: date("2050-07-01"), Closed: closed})
+		var rules []string
+		for _, v := range violations {
+			rules = append(rules, v.Rule)
+		}
+		if !reflect.DeepEqual(rules, e.expected) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.expected, rules)
+		}
+	}
+}
+
+func TestCheck_DefaultRules(t *testing.T) {
+	room := models.Room{ID: 1, Rules: models.DefaultStayRules}
+	for d := 1; d <= 7; d++ {
+		start := date("2050-07-01").AddDate(0, 0, d)
+		if v := Check(Stay{Room: room, Start: start, End: start.AddDate(0, 0, 1), Today: date("2050-07-01")}); len(v) != 0 {
+			t.Errorf("expected a night from %s to be fine, got %v", start.Weekday(), v)
+		}
+	}
+	start := date("2050-07-01")
+	if v := Check(Stay{Room: room, Start: start, End: start.AddDate(1, 0, 0), Today: start}); len(v) != 0 {
+		t.Errorf("expected a year from today to be fine, got %v", v)
+	}
+}
+
+func TestArrivalDay_Message(t *testing.T) {
+	var tests = []struct {
+		days     int
+		expected string
+	}{
+		{1 << time.Saturday, "Arrival is only possible on Saturday"},
+		{1<<time.Friday | 1<<time.Saturday, "Arrival is only possible on Friday or Saturday"},
+		{1<<time.Sunday | 1<<time.Wednesday | 1<<time.Saturday, "Arrival is only possible on Sunday, Wednesday or Saturday"},
+	}
+	// 2050-07-04 is a monday
+	for _, e := range tests {
+		s := Stay{Room: models.Room{Rules: models.StayRules{ArrivalDays: e.days}}, Start: date("2050-07-04")}
+		if msg := arrivalDay(s); msg != e.expected {
+			t.Errorf("expected %q, got %q", e.expected, msg)
+		}
+	}
+}
+
+func TestAddErrors(t *testing.T) {
+	form := forms.New(nil)
+	AddErrors(form, []Violation{
+		{Rule: "arrival_day", Date: Arrival, Message: "Arrival is only possible on Saturday"},
+		{Rule: "min_nights", Date: Departure, Message: "The minimum stay is 7 nights"},
+	}, "start_date", "end_date")
+
+	if form.Valid() {
+		t.Fatal("expected the form to be invalid")
+	}
+	if form.Errors.Get("start_date") != "Arrival is only possible on Saturday" || form.Errors.Get("end_date") != "The minimum stay is 7 nights" {
+		t.Errorf("unexpected errors %v", form.Errors)
+	}
+}
+
+func TestService_Check(t *testing.T) {
+	db := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	s := New(db)
+	s.Now = func() time.Time { return time.Date(2050, 7, 1, 22, 0, 0, 0, time.FixedZone("", -5*3600)) }
+
+	db.UpdateRoomStayRules(1, models.StayRules{MinNights: 3, ArrivalDays: models.AllDays, LeadDays: 1})
+	db.InsertClosedDate(models.ClosedDate{RoomID: 1, FirstDate: date("2050-07-10"), LastDate: date("2050-07-10"), ClosedToDeparture: true})
+
+	// 22:00 at -5 is already the 2nd in UTC, arriving that day is too soon
+	v, err := s.Check(1, date("2050-07-02"), date("2050-07-10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 || v[0].Rule != "lead_time" || v[1].Rule != "closed_to_departure" {
+		t.Errorf("unexpected violations %v", v)
+	}
+
+	v, _ = s.Check(1, date("2050-07-03"), date("2050-07-05"))
+	if len(v) != 1 || v[0].Message != "The minimum stay is 3 nights" {
+		t.Errorf("unexpected violations %v", v)
+	}
+
+	// the other room has no rules
+	v, _ = s.Check(2, date("2050-07-02"), date("2050-07-10"))
+	if len(v) != 0 {
+		t.Errorf("unexpected violations %v", v)
+	}
+}
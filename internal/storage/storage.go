@@ -0,0 +1,91 @@
+// Package storage keeps uploaded files. Local keeps them on disk, another Storage
+// (a bucket, a CDN) can take its place without the handlers noticing
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidName is returned for names that aren't a relative, slash separated path
+var ErrInvalidName = errors.New("invalid file name")
+
+// Storage saves files under names like "rooms/1/abc.jpg" and tells where the site serves them
+type Storage interface {
+	// Save stores what r reads under name, replacing a file of the same name
+	Save(name string, r io.Reader) error
+	// Delete removes the file, a file that isn't there is not an error
+	Delete(name string) error
+	// URL is the address the file is served at
+	URL(name string) string
+}
+
+// Local keeps the files in Dir, served by the site under URLPrefix
+type Local struct {
+	Dir       string
+	URLPrefix string // e.g. /uploads, without a trailing slash
+}
+
+// NewLocal creates a storage in dir
+func NewLocal(dir, urlPrefix string) *Local {
+	return &Local{Dir: dir, URLPrefix: strings.TrimSuffix(urlPrefix, "/")}
+}
+
+// Save writes to a temporary file first, so nobody is ever served half a file
+func (l *Local) Save(name string, r io.Reader) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (l *Local) Delete(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (l *Local) URL(name string) string {
+	return l.URLPrefix + "/" + name
+}
+
+// path is where name is on disk, names can't point outside of Dir
+func (l *Local) path(name string) (string, error) {
+	if name == "." || !fs.ValidPath(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(l.Dir, filepath.FromSlash(name)), nil
+}
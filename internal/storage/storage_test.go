@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	dir := t.TempDir()
+	l := NewLocal(dir, "/uploads/")
+
+	err := l.Save("rooms/1/a.jpg", strings.NewReader("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = l.Save("rooms/1/a.jpg", strings.NewReader("second"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(dir, "rooms", "1", "a.jpg"))
+	if err != nil || string(b) != "second" {
+		t.Errorf("expected the file to be replaced, got %q, %v", b, err)
+	}
+
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Join(dir, "rooms", "1"))
+	if len(entries) != 1 {
+		t.Errorf("expected one file, got %d", len(entries))
+	}
+
+	if url := l.URL("rooms/1/a.jpg"); url != "/uploads/rooms/1/a.jpg" {
+		t.Errorf("unexpected url %s", url)
+	}
+
+	err = l.Delete("rooms/1/a.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "rooms", "1", "a.jpg")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the file to be gone, got %v", err)
+	}
+	err = l.Delete("rooms/1/a.jpg")
+	if err != nil {
+		t.Errorf("expected deleting a missing file to succeed, got %v", err)
+	}
+}
+
+func TestLocal_InvalidName(t *testing.T) {
+	l := NewLocal(t.TempDir(), "/uploads")
+	for _, name := range []string{"", ".", "../a.jpg", "rooms/../../a.jpg", "/etc/passwd", "rooms//a.jpg", "rooms/"} {
+		if err := l.Save(name, strings.NewReader("x")); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: expected ErrInvalidName, got %v", name, err)
+		}
+		if err := l.Delete(name); !errors.Is(err, ErrInvalidName) {
+			t.Errorf("%q: expected ErrInvalidName deleting, got %v", name, err)
+		}
+	}
+}
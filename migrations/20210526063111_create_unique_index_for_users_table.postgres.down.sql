@@ -0,0 +1 @@
+drop index users_email_idx;
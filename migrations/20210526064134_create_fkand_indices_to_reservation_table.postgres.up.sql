@@ -0,0 +1,5 @@
+alter table room_restrictions add constraint room_restrictions_reservations_id_fk
+	foreign key (reservation_id) references reservations (id) on delete cascade on update cascade;
+
+create index reservations_email_idx on reservations (email);
+create index reservations_last_name_idx on reservations (last_name);
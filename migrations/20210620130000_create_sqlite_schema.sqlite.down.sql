@@ -0,0 +1,6 @@
+drop trigger if exists room_restrictions_no_overlap;
+drop table if exists room_restrictions;
+drop table if exists reservations;
+drop table if exists restrictions;
+drop table if exists rooms;
+drop table if exists users;
@@ -0,0 +1,77 @@
+-- sqlite gets the whole schema of the postgres migrations in one go,
+-- everything is "if not exists" / "or ignore" so it is safe to run on every start
+
+create table if not exists users (
+	id integer primary key autoincrement,
+	first_name varchar(255) not null default '',
+	last_name varchar(255) not null default '',
+	email varchar(255) not null,
+	password varchar(60) not null,
+	access_level integer not null default 1,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+create unique index if not exists users_email_idx on users (email);
+
+create table if not exists rooms (
+	id integer primary key autoincrement,
+	room_name varchar(255) not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create table if not exists restrictions (
+	id integer primary key autoincrement,
+	restriction_name varchar(255) not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create table if not exists reservations (
+	id integer primary key autoincrement,
+	first_name varchar(255) not null default '',
+	last_name varchar(255) not null default '',
+	email varchar(255) not null,
+	phone varchar(255) not null default '',
+	start_date date not null,
+	end_date date not null,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	processed integer not null default 0,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+create index if not exists reservations_email_idx on reservations (email);
+create index if not exists reservations_last_name_idx on reservations (last_name);
+
+create table if not exists room_restrictions (
+	id integer primary key autoincrement,
+	start_date date not null,
+	end_date date not null,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	reservation_id integer references reservations (id) on delete cascade on update cascade,
+	restriction_id integer not null references restrictions (id) on delete cascade on update cascade,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+create index if not exists room_restrictions_start_date_end_date_idx on room_restrictions (start_date, end_date);
+create index if not exists room_restrictions_room_id_idx on room_restrictions (room_id);
+create index if not exists room_restrictions_reservation_id_idx on room_restrictions (reservation_id);
+
+-- sqlite has no exclusion constraints, this trigger does the same job as room_restrictions_no_overlap in postgres
+create trigger if not exists room_restrictions_no_overlap
+before insert on room_restrictions
+when exists (
+	select 1 from room_restrictions
+	where room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+	select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+insert or ignore into rooms (id, room_name, created_at, updated_at) values
+	(1, 'Quarters', '2020-11-18 00:00:00', '2020-11-18 00:00:00'),
+	(2, 'Master', '2020-11-18 00:00:00', '2020-11-18 00:00:00');
+
+insert or ignore into restrictions (id, restriction_name, created_at, updated_at) values
+	(1, 'Reservation', '2021-01-01 00:00:00', '2021-01-01 00:00:00'),
+	(2, 'Owner Block', '2021-05-19 00:00:00', '2021-05-19 00:00:00');
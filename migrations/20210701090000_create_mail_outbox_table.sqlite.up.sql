@@ -0,0 +1,17 @@
+create table mail_outbox (
+	id integer primary key autoincrement,
+	mail_to varchar(255) not null,
+	mail_from varchar(255) not null,
+	subject varchar(255) not null default '',
+	content text not null default '',
+	template varchar(255) not null default '',
+	status varchar(20) not null default 'pending',
+	attempts integer not null default 0,
+	last_error text not null default '',
+	next_attempt_at timestamp not null,
+	sent_at timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create index mail_outbox_status_next_attempt_at_idx on mail_outbox (status, next_attempt_at);
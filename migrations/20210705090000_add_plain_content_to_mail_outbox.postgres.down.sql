@@ -0,0 +1,2 @@
+alter table mail_outbox add column template varchar(255) not null default '';
+alter table mail_outbox drop column plain_content;
@@ -0,0 +1,2 @@
+alter table mail_outbox add column plain_content text not null default '';
+alter table mail_outbox drop column template;
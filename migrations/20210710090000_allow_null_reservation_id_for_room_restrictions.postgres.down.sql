@@ -0,0 +1,3 @@
+-- owner blocks have no reservation, they can't survive the constraint
+delete from room_restrictions where reservation_id is null;
+alter table room_restrictions alter column reservation_id set not null;
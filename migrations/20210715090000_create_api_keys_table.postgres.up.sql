@@ -0,0 +1,15 @@
+create table api_keys (
+	id serial primary key,
+	user_id integer not null references users (id) on delete cascade on update cascade,
+	name varchar(255) not null default '',
+	prefix varchar(20) not null,
+	key_hash varchar(64) not null,
+	scopes text not null default '',
+	last_used_at timestamp,
+	revoked_at timestamp,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create unique index api_keys_prefix_idx on api_keys (prefix);
+create index api_keys_user_id_idx on api_keys (user_id);
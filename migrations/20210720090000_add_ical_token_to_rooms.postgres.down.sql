@@ -0,0 +1,3 @@
+drop index rooms_ical_token_idx;
+
+alter table rooms drop column ical_token;
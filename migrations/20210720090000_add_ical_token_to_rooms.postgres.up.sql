@@ -0,0 +1,3 @@
+alter table rooms add column ical_token varchar(64);
+
+create unique index rooms_ical_token_idx on rooms (ical_token);
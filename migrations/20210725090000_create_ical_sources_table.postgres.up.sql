@@ -0,0 +1,21 @@
+create table ical_sources (
+	id serial primary key,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	name varchar(255) not null default '',
+	url text not null default '',
+	content text not null default '',
+	last_synced_at timestamp,
+	last_error text not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create index ical_sources_room_id_idx on ical_sources (room_id);
+
+-- the blocks imported from a source, found again by the uid of their event
+alter table room_restrictions add column ical_source_id integer references ical_sources (id) on delete cascade on update cascade;
+alter table room_restrictions add column ical_uid varchar(255);
+create unique index room_restrictions_ical_source_id_ical_uid_idx on room_restrictions (ical_source_id, ical_uid);
+
+insert into restrictions (id, restriction_name, created_at, updated_at) values (3, 'External', now(), now());
+select setval('restrictions_id_seq', (select max(id) from restrictions));
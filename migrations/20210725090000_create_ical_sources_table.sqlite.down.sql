@@ -0,0 +1,10 @@
+delete from room_restrictions where restriction_id = 3;
+delete from restrictions where id = 3;
+
+drop trigger room_restrictions_no_overlap_update;
+drop trigger ical_sources_delete_blocks;
+drop index room_restrictions_ical_source_id_ical_uid_idx;
+alter table room_restrictions drop column ical_uid;
+alter table room_restrictions drop column ical_source_id;
+
+drop table ical_sources;
@@ -0,0 +1,39 @@
+create table ical_sources (
+	id integer primary key autoincrement,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	name varchar(255) not null default '',
+	url text not null default '',
+	content text not null default '',
+	last_synced_at timestamp,
+	last_error text not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create index ical_sources_room_id_idx on ical_sources (room_id);
+
+-- the blocks imported from a source, found again by the uid of their event.
+-- no foreign key, sqlite couldn't drop the column again, the trigger below does the cascade
+alter table room_restrictions add column ical_source_id integer;
+alter table room_restrictions add column ical_uid varchar(255);
+create unique index room_restrictions_ical_source_id_ical_uid_idx on room_restrictions (ical_source_id, ical_uid);
+
+create trigger ical_sources_delete_blocks
+after delete on ical_sources
+begin
+	delete from room_restrictions where ical_source_id = old.id;
+end;
+
+-- imported blocks are moved when their event changes, so check updates for overlaps too
+create trigger room_restrictions_no_overlap_update
+before update of start_date, end_date, room_id on room_restrictions
+when exists (
+	select 1 from room_restrictions
+	where id <> new.id and room_id = new.room_id and new.start_date < end_date and new.end_date > start_date
+)
+begin
+	select raise(abort, 'room_restrictions_no_overlap');
+end;
+
+insert into restrictions (id, restriction_name, created_at, updated_at) values
+	(3, 'External', datetime('now'), datetime('now'));
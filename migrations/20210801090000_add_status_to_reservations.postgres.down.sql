@@ -0,0 +1,8 @@
+drop index reservations_status_idx;
+
+-- cancelled reservations no longer block their room, before statuses they were deleted
+delete from reservations where status = 'cancelled';
+
+alter table reservations add column processed integer not null default 0;
+update reservations set processed = 1 where status <> 'pending';
+alter table reservations drop column status;
@@ -0,0 +1,7 @@
+-- processed was the only state a reservation had, processed ones count as confirmed
+alter table reservations add column status varchar(20) not null default 'pending'
+	check (status in ('pending', 'confirmed', 'checked-in', 'checked-out', 'cancelled', 'no-show'));
+update reservations set status = 'confirmed' where processed <> 0;
+alter table reservations drop column processed;
+
+create index reservations_status_idx on reservations (status);
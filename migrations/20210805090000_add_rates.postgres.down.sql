@@ -0,0 +1,6 @@
+drop table seasonal_rates;
+
+alter table reservations drop column price;
+
+alter table rooms drop column weekend_rate;
+alter table rooms drop column nightly_rate;
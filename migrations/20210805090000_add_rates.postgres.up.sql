@@ -0,0 +1,25 @@
+-- all amounts are in cents. A weekend rate of 0 means the nightly rate applies on weekends too
+alter table rooms add column nightly_rate integer not null default 0;
+alter table rooms add column weekend_rate integer not null default 0;
+
+-- what the stay cost when it was booked, later rate changes don't touch it
+alter table reservations add column price integer not null default 0;
+
+-- seasons override the rates of a room from first_night up to and including last_night
+create table seasonal_rates (
+	id serial primary key,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	name varchar(255) not null,
+	first_night date not null,
+	last_night date not null,
+	nightly_rate integer not null,
+	weekend_rate integer not null default 0,
+	created_at timestamp not null,
+	updated_at timestamp not null,
+	check (last_night >= first_night)
+);
+
+create index seasonal_rates_room_id_idx on seasonal_rates (room_id, first_night);
+
+update rooms set nightly_rate = 8900, weekend_rate = 9900 where room_name = 'Quarters';
+update rooms set nightly_rate = 12900, weekend_rate = 14900 where room_name = 'Master';
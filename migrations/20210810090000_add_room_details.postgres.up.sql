@@ -0,0 +1,26 @@
+-- amenities are one per line, archived rooms can't be booked or found but keep their reservations
+alter table rooms add column slug varchar(255) not null default '';
+alter table rooms add column description text not null default '';
+alter table rooms add column capacity integer not null default 2 check (capacity > 0);
+alter table rooms add column amenities text not null default '';
+alter table rooms add column sort_order integer not null default 0;
+alter table rooms add column archived_at timestamp;
+
+-- the seed rooms get the urls of their old pages
+update rooms set slug = 'generals-quarters', sort_order = 1, capacity = 2,
+	description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = 'Ocean view
+Queen size bed
+Private bathroom
+Free Wi-Fi'
+	where room_name = 'Quarters';
+update rooms set slug = 'majors-suite', sort_order = 2, capacity = 4,
+	description = 'A suite with room for the whole family, set on the majestic waters of the Atlantic Ocean, this will be a vacation to remember.',
+	amenities = 'Ocean view
+King size bed and sofa bed
+Kitchenette
+Free Wi-Fi'
+	where room_name = 'Master';
+update rooms set slug = 'room-' || id, sort_order = id where slug = '';
+
+create unique index rooms_slug_idx on rooms (slug);
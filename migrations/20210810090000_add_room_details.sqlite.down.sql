@@ -0,0 +1,8 @@
+drop index rooms_slug_idx;
+
+alter table rooms drop column archived_at;
+alter table rooms drop column sort_order;
+alter table rooms drop column amenities;
+alter table rooms drop column capacity;
+alter table rooms drop column description;
+alter table rooms drop column slug;
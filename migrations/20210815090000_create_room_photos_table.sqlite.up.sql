@@ -0,0 +1,13 @@
+-- the names of the files in the upload storage, the original and the smaller versions the site shows
+create table room_photos (
+	id integer primary key autoincrement,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	original varchar(255) not null,
+	medium varchar(255) not null,
+	thumbnail varchar(255) not null,
+	sort_order integer not null default 0,
+	created_at timestamp not null,
+	updated_at timestamp not null
+);
+
+create index room_photos_room_id_idx on room_photos (room_id, sort_order);
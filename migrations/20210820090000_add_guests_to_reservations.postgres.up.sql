@@ -0,0 +1,3 @@
+-- reservations from before don't know how many stayed, they count as one adult
+alter table reservations add column adults integer not null default 1 check (adults > 0);
+alter table reservations add column children integer not null default 0 check (children >= 0);
@@ -0,0 +1,2 @@
+alter table reservations drop column children;
+alter table reservations drop column adults;
@@ -0,0 +1,24 @@
+-- what stays can be booked in a room, 0 means no limit. arrival_days has a bit for every weekday
+-- guests can arrive on, sunday is 1 and saturday 64
+alter table rooms add column min_nights integer not null default 1 check (min_nights > 0);
+alter table rooms add column max_nights integer not null default 0 check (max_nights >= 0);
+alter table rooms add column arrival_days integer not null default 127 check (arrival_days between 1 and 127);
+alter table rooms add column lead_days integer not null default 0 check (lead_days >= 0);
+alter table rooms add column window_days integer not null default 0 check (window_days >= 0);
+
+-- days from first_date up to and including last_date guests can't arrive or leave on
+create table closed_dates (
+	id serial primary key,
+	room_id integer not null references rooms (id) on delete cascade on update cascade,
+	first_date date not null,
+	last_date date not null,
+	closed_to_arrival boolean not null default false,
+	closed_to_departure boolean not null default false,
+	note varchar(255) not null default '',
+	created_at timestamp not null,
+	updated_at timestamp not null,
+	check (last_date >= first_date),
+	check (closed_to_arrival or closed_to_departure)
+);
+
+create index closed_dates_room_id_idx on closed_dates (room_id, first_date);
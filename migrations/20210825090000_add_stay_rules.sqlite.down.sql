@@ -0,0 +1,7 @@
+drop table closed_dates;
+
+alter table rooms drop column window_days;
+alter table rooms drop column lead_days;
+alter table rooms drop column arrival_days;
+alter table rooms drop column max_nights;
+alter table rooms drop column min_nights;
@@ -0,0 +1,5 @@
+delete from room_restrictions where restriction_id = 4;
+delete from restrictions where id = 4;
+
+drop index room_restrictions_expires_at_idx;
+alter table room_restrictions drop column expires_at;
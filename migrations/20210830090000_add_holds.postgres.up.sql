@@ -0,0 +1,6 @@
+-- a hold keeps a room for a guest filling in the reservation form, it is removed once expires_at is past
+alter table room_restrictions add column expires_at timestamp;
+create index room_restrictions_expires_at_idx on room_restrictions (expires_at);
+
+insert into restrictions (id, restriction_name, created_at, updated_at) values (4, 'Hold', now(), now());
+select setval('restrictions_id_seq', (select max(id) from restrictions));
@@ -0,0 +1,9 @@
+// Package migrations embeds the sql migrations, so the binary can create its own schema
+package migrations
+
+import "embed"
+
+// FS holds the .sql migrations of every dialect
+//
+//go:embed *.sql
+var FS embed.FS